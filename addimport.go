@@ -0,0 +1,144 @@
+package pe
+
+import (
+	"encoding/binary"
+
+	"github.com/mewmew/pe/enum"
+	"github.com/pkg/errors"
+)
+
+// --- [ Import table rewriting ] ----------------------------------------------
+
+// AddImports adds the given import entries to the import table of the PE
+// file. The import name table entries (INTs) of each import entry specify the
+// symbols imported from the DLL of its import directory; the remaining fields
+// are ignored.
+//
+// The import directories, import name tables and hint/name entries of all
+// imports are rebuilt in a new section, together with import address tables
+// (IATs) of the added imports. The IATs of existing imports are kept in place,
+// so that code references to existing IAT entries remain valid; symbols added
+// to DLLs already imported are imported through additional import directories.
+// The import table and import address table data directories are updated,
+// and the bound import table is cleared.
+//
+// The added import entries are returned, with the relative addresses of their
+// IATs. The changes take effect when writing the PE file; see WriteTo.
+func (file *File) AddImports(imps []ImportEntry) ([]ImportEntry, error) {
+	if err := file.checkLayout(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(file.DataDirs) <= dataDirIAT {
+		return nil, errors.Errorf("unable to add imports; expected at least %d data directories, got %d", dataDirIAT+1, len(file.DataDirs))
+	}
+	if len(imps) == 0 {
+		return nil, nil
+	}
+	for _, imp := range imps {
+		if len(imp.ImpDir.Name) == 0 {
+			return nil, errors.New("invalid import entry; empty DLL name")
+		}
+		if len(imp.INTs) == 0 {
+			return nil, errors.Errorf("invalid import entry of %q; no imported symbols", imp.ImpDir.Name)
+		}
+		for _, sym := range imp.INTs {
+			if !sym.IsOrdinal && len(sym.NameEntry.Name) == 0 {
+				return nil, errors.Errorf("invalid import entry of %q; empty symbol name", imp.ImpDir.Name)
+			}
+		}
+	}
+	old, err := file.Imports()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse existing imports")
+	}
+	// Existing imports keep their IATs; added imports have IATs allocated in
+	// the import table.
+	var dlls []importDLL
+	for _, imp := range old {
+		syms := imp.INTs
+		if len(syms) == 0 {
+			syms = imp.IATs
+		}
+		dll := importDLL{
+			name:       imp.ImpDir.Name,
+			syms:       syms,
+			iatRelAddr: imp.ImpDir.IATRelAddr,
+		}
+		dlls = append(dlls, dll)
+	}
+	for _, imp := range imps {
+		dll := importDLL{
+			name: imp.ImpDir.Name,
+			syms: imp.INTs,
+		}
+		dlls = append(dlls, dll)
+	}
+	// The size of the import table is independent of its relative address;
+	// the import table is rebuilt at the relative address of the added section
+	// if needed.
+	ptrSize := file.ptrSize()
+	relAddr := file.imageEnd()
+	table := buildImportTable(dlls, relAddr, ptrSize)
+	flags := enum.SectionFlagContainsInitializedData | enum.SectionFlagMemRead | enum.SectionFlagMemWrite
+	sectHdr, err := file.AddSection(importSectName, flags, table.data)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if sectHdr.RelAddr != relAddr {
+		table = buildImportTable(dlls, sectHdr.RelAddr, ptrSize)
+		file.sectSrcs[len(file.sectSrcs)-1].data = table.data
+	}
+	// Update data directories.
+	file.DataDirs[dataDirImport] = DataDirectory{
+		RelAddr: sectHdr.RelAddr,
+		Size:    table.dirSize,
+	}
+	//
+	// The IAT data directory covers the IATs of existing imports, if present,
+	// since the loader restores the memory protection of the whole range to
+	// that of its first page after binding; the IATs of added imports are
+	// located in a writable section.
+	iats := table.iatRelAddrs
+	if len(old) > 0 {
+		iats = iats[:len(old)]
+	}
+	iatStart, iatEnd := iats[0], uint32(0)
+	for i, iatRelAddr := range iats {
+		iatStart = minUint32(iatStart, iatRelAddr)
+		iatEnd = maxUint32(iatEnd, iatRelAddr+uint32(len(dlls[i].syms)+1)*ptrSize)
+	}
+	if iatDir := file.DataDirs[dataDirIAT]; len(old) > 0 && iatDir.RelAddr != 0 {
+		iatStart = minUint32(iatStart, iatDir.RelAddr)
+		iatEnd = maxUint32(iatEnd, iatDir.RelAddr+iatDir.Size)
+	}
+	file.DataDirs[dataDirIAT] = DataDirectory{
+		RelAddr: iatStart,
+		Size:    iatEnd - iatStart,
+	}
+	if dataDirBoundImport < len(file.DataDirs) {
+		file.DataDirs[dataDirBoundImport] = DataDirectory{}
+	}
+	// Update parsed imports.
+	var all, added []ImportEntry
+	for i, dll := range dlls {
+		impDir := table.data[i*20:]
+		imp := ImportEntry{
+			ImpDir: ImportDirectory{
+				INTRelAddr: binary.LittleEndian.Uint32(impDir[0:]),
+				Date:       parseDateFromEpoch(0),
+				Name:       dll.name,
+				IATRelAddr: binary.LittleEndian.Uint32(impDir[16:]),
+			},
+			INTs: dll.syms,
+		}
+		if i < len(old) {
+			imp.IATs = old[i].IATs
+		} else {
+			imp.IATs = dll.syms
+			added = append(added, imp)
+		}
+		all = append(all, imp)
+	}
+	file.Imps = all
+	return added, nil
+}
@@ -0,0 +1,230 @@
+// Package apiset provides access to API set schemas, which map virtual DLLs
+// (e.g. "api-ms-win-core-synch-l1-2-0.dll") to their host DLLs.
+//
+// The API set schema is stored in the .apiset section of apisetschema.dll.
+// Schema versions 2 (Windows 7), 4 (Windows 8.1) and 6 (Windows 10) are
+// supported.
+//
+// ref: https://www.geoffchappell.com/studies/windows/win32/apisetschema/index.htm
+package apiset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/mewmew/pe"
+	"github.com/pkg/errors"
+)
+
+// Schema is an API set schema.
+type Schema struct {
+	// Schema version (2, 4 or 6).
+	Version uint32
+	// Schema flags; zero for version 2.
+	Flags uint32
+	// API sets of the schema.
+	APISets []APISet
+}
+
+// APISet is an API set, mapping a virtual DLL to its host DLLs.
+type APISet struct {
+	// Name of the API set (e.g. "api-ms-win-core-synch-l1-2-0"), as stored in
+	// the schema; for schema versions 2 and 4, the name is stored without its
+	// "api-" prefix.
+	Name string
+	// API set flags; bit 0 is set for sealed API sets. Zero for version 2.
+	Flags uint32
+	// Host DLLs of the API set. The default host has an empty importer name.
+	Hosts []Host
+}
+
+// Host is a host DLL of an API set.
+type Host struct {
+	// Name of the importing DLL for which the host applies; empty for the
+	// default host.
+	Importer string
+	// Name of the host DLL (e.g. "kernelbase.dll").
+	Name string
+}
+
+// Section name of API set schema.
+const sectName = ".apiset"
+
+// Parse parses the API set schema stored in the .apiset section of the given
+// PE file (e.g. apisetschema.dll).
+func Parse(file *pe.File) (*Schema, error) {
+	for _, sectHdr := range file.SectHdrs {
+		if sectHdr.Name != sectName {
+			continue
+		}
+		data := file.SectionData(sectHdr)
+		if len(data) < int(sectHdr.DataSize) && !file.Mapped() {
+			start := uint64(sectHdr.DataOffset)
+			end := start + uint64(sectHdr.DataSize)
+			return nil, errors.Errorf("invalid %s section; data range [0x%X, 0x%X) exceeds file size 0x%X", sectName, start, end, file.Size())
+		}
+		return ParseBytes(data)
+	}
+	return nil, errors.Errorf("unable to locate %s section", sectName)
+}
+
+// ParseBytes parses the given API set schema, as stored in the .apiset section
+// of apisetschema.dll.
+func ParseBytes(data []byte) (*Schema, error) {
+	p := &parser{data: data}
+	version, err := p.uint32(0)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	switch version {
+	case 2:
+		return p.parseV2()
+	case 4:
+		return p.parseV4()
+	case 6:
+		return p.parseV6()
+	default:
+		return nil, errors.Errorf("support for API set schema version %d not yet implemented", version)
+	}
+}
+
+// Resolve returns the name of the host DLL of the given virtual DLL (e.g.
+// "api-ms-win-core-synch-l1-2-0.dll"), as imported by the given DLL (e.g.
+// "kernel32.dll"); or an empty importer name to use the default host. The
+// boolean return value indicates success.
+//
+// Names are case-insensitive, and the ".dll" extension is optional.
+func (schema *Schema) Resolve(importer, name string) (string, bool) {
+	apiSet, ok := schema.Lookup(name)
+	if !ok {
+		return "", false
+	}
+	importer = trimExt(strings.ToLower(importer))
+	var host string
+	for _, h := range apiSet.Hosts {
+		if len(h.Importer) == 0 {
+			if len(host) == 0 {
+				host = h.Name
+			}
+			continue
+		}
+		if len(importer) > 0 && trimExt(strings.ToLower(h.Importer)) == importer {
+			return h.Name, len(h.Name) > 0
+		}
+	}
+	return host, len(host) > 0
+}
+
+// Redirect returns the name of the host DLL of the given DLL name, as imported
+// by the given DLL; or the DLL name as is if not a virtual DLL of the schema.
+// It may be used as the redirect function of a bind.Resolver.
+func (schema *Schema) Redirect(importer, name string) string {
+	if host, ok := schema.Resolve(importer, name); ok {
+		return host
+	}
+	return name
+}
+
+// Lookup returns the API set of the given virtual DLL (e.g.
+// "api-ms-win-core-synch-l1-2-0.dll"). The boolean return value indicates
+// success.
+//
+// For schema version 6, the last component of the version (e.g. "-0") is
+// ignored, as done by the Windows loader.
+func (schema *Schema) Lookup(name string) (APISet, bool) {
+	key := schema.key(name)
+	if len(key) == 0 {
+		return APISet{}, false
+	}
+	for _, apiSet := range schema.APISets {
+		if schema.key(apiSet.Name) == key {
+			return apiSet, true
+		}
+	}
+	return APISet{}, false
+}
+
+// IsAPISet reports whether the given DLL name is a virtual DLL name of an API
+// set (i.e. has the "api-" or "ext-" prefix).
+func IsAPISet(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasPrefix(name, "api-") || strings.HasPrefix(name, "ext-")
+}
+
+// key returns the lookup key of the given API set name.
+func (schema *Schema) key(name string) string {
+	name = trimExt(strings.ToLower(name))
+	if schema.Version < 6 {
+		// Names of schema versions 2 and 4 omit the "api-" prefix.
+		if IsAPISet(name) {
+			name = name[len("api-"):]
+		}
+		return name
+	}
+	// Ignore last component of version.
+	if pos := strings.LastIndex(name, "-"); pos != -1 {
+		name = name[:pos]
+	}
+	return name
+}
+
+// trimExt trims the ".dll" extension of the given lowercase DLL name.
+func trimExt(name string) string {
+	return strings.TrimSuffix(name, ".dll")
+}
+
+// ### [ Helper functions ] ####################################################
+
+// parser is an API set schema parser.
+type parser struct {
+	// API set schema data.
+	data []byte
+}
+
+// uint32 returns the 32-bit value at the given offset.
+func (p *parser) uint32(offset uint32) (uint32, error) {
+	if uint64(offset)+4 > uint64(len(p.data)) {
+		return 0, errors.Errorf("unable to read 32-bit value at offset 0x%X; API set schema size 0x%X", offset, len(p.data))
+	}
+	return binary.LittleEndian.Uint32(p.data[offset:]), nil
+}
+
+// checkArray reports an error if the array of n entries of the given size in
+// bytes located at the given offset extends past the end of the API set
+// schema.
+func (p *parser) checkArray(offset, n, entrySize uint32) error {
+	end := uint64(offset) + uint64(n)*uint64(entrySize)
+	if end > uint64(len(p.data)) {
+		return errors.Errorf("invalid array of %d entries (%d bytes each) at offset 0x%X; API set schema size 0x%X", n, entrySize, offset, len(p.data))
+	}
+	return nil
+}
+
+// read reads the structure at the given offset into v.
+func (p *parser) read(offset uint32, v interface{}) error {
+	if uint64(offset) > uint64(len(p.data)) {
+		return errors.Errorf("unable to read structure at offset 0x%X; API set schema size 0x%X", offset, len(p.data))
+	}
+	r := bytes.NewReader(p.data[offset:])
+	if err := binary.Read(r, binary.LittleEndian, v); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// string returns the UTF-16 encoded string at the given offset and length in
+// bytes.
+func (p *parser) string(offset, length uint32) (string, error) {
+	end := uint64(offset) + uint64(length)
+	if end > uint64(len(p.data)) {
+		return "", errors.Errorf("unable to read string at offset 0x%X (%d bytes); API set schema size 0x%X", offset, length, len(p.data))
+	}
+	buf := p.data[offset:end]
+	u := make([]uint16, len(buf)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(buf[2*i:])
+	}
+	return string(utf16.Decode(u)), nil
+}
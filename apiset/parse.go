@@ -0,0 +1,397 @@
+package apiset
+
+import "github.com/pkg/errors"
+
+// --- [ Version 2 ] -----------------------------------------------------------
+
+// rawNamespaceV2 is the header of an API set schema of version 2 (in raw
+// format). Following the header are Count namespace entries.
+type rawNamespaceV2 struct {
+	// Schema version.
+	//
+	// offset: 0x0000 (4 bytes)
+	Version uint32
+	// Number of API sets.
+	//
+	// offset: 0x0004 (4 bytes)
+	Count uint32
+}
+
+// rawNamespaceEntryV2 is an API set of an API set schema of version 2 (in raw
+// format).
+type rawNamespaceEntryV2 struct {
+	// Offset of API set name.
+	//
+	// offset: 0x0000 (4 bytes)
+	NameOffset uint32
+	// Length in bytes of API set name.
+	//
+	// offset: 0x0004 (4 bytes)
+	NameLength uint32
+	// Offset of value array, which contains a 32-bit count followed by value
+	// entries.
+	//
+	// offset: 0x0008 (4 bytes)
+	DataOffset uint32
+}
+
+// rawValueEntryV2 is a host of an API set of an API set schema of version 2
+// (in raw format).
+type rawValueEntryV2 struct {
+	// Offset of importer name.
+	//
+	// offset: 0x0000 (4 bytes)
+	NameOffset uint32
+	// Length in bytes of importer name.
+	//
+	// offset: 0x0004 (4 bytes)
+	NameLength uint32
+	// Offset of host name.
+	//
+	// offset: 0x0008 (4 bytes)
+	ValueOffset uint32
+	// Length in bytes of host name.
+	//
+	// offset: 0x000C (4 bytes)
+	ValueLength uint32
+}
+
+// parseV2 parses an API set schema of version 2.
+func (p *parser) parseV2() (*Schema, error) {
+	var hdr rawNamespaceV2
+	if err := p.read(0, &hdr); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	schema := &Schema{
+		Version: hdr.Version,
+	}
+	const (
+		hdrSize   = 8
+		entrySize = 12
+		valueSize = 16
+	)
+	if err := p.checkArray(hdrSize, hdr.Count, entrySize); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for i := uint32(0); i < hdr.Count; i++ {
+		var entry rawNamespaceEntryV2
+		if err := p.read(hdrSize+i*entrySize, &entry); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		name, err := p.string(entry.NameOffset, entry.NameLength)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		apiSet := APISet{
+			Name: name,
+		}
+		n, err := p.uint32(entry.DataOffset)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if err := p.checkArray(entry.DataOffset+4, n, valueSize); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for j := uint32(0); j < n; j++ {
+			var value rawValueEntryV2
+			if err := p.read(entry.DataOffset+4+j*valueSize, &value); err != nil {
+				return nil, errors.WithStack(err)
+			}
+			host, err := p.host(value.NameOffset, value.NameLength, value.ValueOffset, value.ValueLength)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			apiSet.Hosts = append(apiSet.Hosts, host)
+		}
+		schema.APISets = append(schema.APISets, apiSet)
+	}
+	return schema, nil
+}
+
+// --- [ Version 4 ] -----------------------------------------------------------
+
+// rawNamespaceV4 is the header of an API set schema of version 4 (in raw
+// format). Following the header are Count namespace entries.
+type rawNamespaceV4 struct {
+	// Schema version.
+	//
+	// offset: 0x0000 (4 bytes)
+	Version uint32
+	// Size in bytes of API set schema.
+	//
+	// offset: 0x0004 (4 bytes)
+	Size uint32
+	// Schema flags.
+	//
+	// offset: 0x0008 (4 bytes)
+	Flags uint32
+	// Number of API sets.
+	//
+	// offset: 0x000C (4 bytes)
+	Count uint32
+}
+
+// rawNamespaceEntryV4 is an API set of an API set schema of version 4 (in raw
+// format).
+type rawNamespaceEntryV4 struct {
+	// API set flags.
+	//
+	// offset: 0x0000 (4 bytes)
+	Flags uint32
+	// Offset of API set name.
+	//
+	// offset: 0x0004 (4 bytes)
+	NameOffset uint32
+	// Length in bytes of API set name.
+	//
+	// offset: 0x0008 (4 bytes)
+	NameLength uint32
+	// Offset of API set alias.
+	//
+	// offset: 0x000C (4 bytes)
+	AliasOffset uint32
+	// Length in bytes of API set alias.
+	//
+	// offset: 0x0010 (4 bytes)
+	AliasLength uint32
+	// Offset of value array, which contains 32-bit flags and count followed by
+	// value entries.
+	//
+	// offset: 0x0014 (4 bytes)
+	DataOffset uint32
+}
+
+// rawValueEntryV4 is a host of an API set of an API set schema of version 4
+// (in raw format).
+type rawValueEntryV4 struct {
+	// Host flags.
+	//
+	// offset: 0x0000 (4 bytes)
+	Flags uint32
+	// Offset of importer name.
+	//
+	// offset: 0x0004 (4 bytes)
+	NameOffset uint32
+	// Length in bytes of importer name.
+	//
+	// offset: 0x0008 (4 bytes)
+	NameLength uint32
+	// Offset of host name.
+	//
+	// offset: 0x000C (4 bytes)
+	ValueOffset uint32
+	// Length in bytes of host name.
+	//
+	// offset: 0x0010 (4 bytes)
+	ValueLength uint32
+}
+
+// parseV4 parses an API set schema of version 4.
+func (p *parser) parseV4() (*Schema, error) {
+	var hdr rawNamespaceV4
+	if err := p.read(0, &hdr); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	schema := &Schema{
+		Version: hdr.Version,
+		Flags:   hdr.Flags,
+	}
+	const (
+		hdrSize   = 16
+		entrySize = 24
+		valueSize = 20
+	)
+	if err := p.checkArray(hdrSize, hdr.Count, entrySize); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for i := uint32(0); i < hdr.Count; i++ {
+		var entry rawNamespaceEntryV4
+		if err := p.read(hdrSize+i*entrySize, &entry); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		name, err := p.string(entry.NameOffset, entry.NameLength)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		apiSet := APISet{
+			Name:  name,
+			Flags: entry.Flags,
+		}
+		// Skip flags of value array.
+		n, err := p.uint32(entry.DataOffset + 4)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if err := p.checkArray(entry.DataOffset+8, n, valueSize); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for j := uint32(0); j < n; j++ {
+			var value rawValueEntryV4
+			if err := p.read(entry.DataOffset+8+j*valueSize, &value); err != nil {
+				return nil, errors.WithStack(err)
+			}
+			host, err := p.host(value.NameOffset, value.NameLength, value.ValueOffset, value.ValueLength)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			apiSet.Hosts = append(apiSet.Hosts, host)
+		}
+		schema.APISets = append(schema.APISets, apiSet)
+	}
+	return schema, nil
+}
+
+// --- [ Version 6 ] -----------------------------------------------------------
+
+// rawNamespaceV6 is the header of an API set schema of version 6 (in raw
+// format).
+type rawNamespaceV6 struct {
+	// Schema version.
+	//
+	// offset: 0x0000 (4 bytes)
+	Version uint32
+	// Size in bytes of API set schema.
+	//
+	// offset: 0x0004 (4 bytes)
+	Size uint32
+	// Schema flags.
+	//
+	// offset: 0x0008 (4 bytes)
+	Flags uint32
+	// Number of API sets.
+	//
+	// offset: 0x000C (4 bytes)
+	Count uint32
+	// Offset of namespace entries.
+	//
+	// offset: 0x0010 (4 bytes)
+	EntryOffset uint32
+	// Offset of hash entries, sorted by hash.
+	//
+	// offset: 0x0014 (4 bytes)
+	HashOffset uint32
+	// Multiplier used when hashing API set names.
+	//
+	// offset: 0x0018 (4 bytes)
+	HashFactor uint32
+}
+
+// rawNamespaceEntryV6 is an API set of an API set schema of version 6 (in raw
+// format).
+type rawNamespaceEntryV6 struct {
+	// API set flags.
+	//
+	// offset: 0x0000 (4 bytes)
+	Flags uint32
+	// Offset of API set name.
+	//
+	// offset: 0x0004 (4 bytes)
+	NameOffset uint32
+	// Length in bytes of API set name.
+	//
+	// offset: 0x0008 (4 bytes)
+	NameLength uint32
+	// Length in bytes of API set name used for hashing and lookup; excludes
+	// the last component of the version.
+	//
+	// offset: 0x000C (4 bytes)
+	HashedLength uint32
+	// Offset of value entries.
+	//
+	// offset: 0x0010 (4 bytes)
+	ValueOffset uint32
+	// Number of value entries.
+	//
+	// offset: 0x0014 (4 bytes)
+	ValueCount uint32
+}
+
+// rawValueEntryV6 is a host of an API set of an API set schema of version 6
+// (in raw format).
+type rawValueEntryV6 struct {
+	// Host flags.
+	//
+	// offset: 0x0000 (4 bytes)
+	Flags uint32
+	// Offset of importer name.
+	//
+	// offset: 0x0004 (4 bytes)
+	NameOffset uint32
+	// Length in bytes of importer name.
+	//
+	// offset: 0x0008 (4 bytes)
+	NameLength uint32
+	// Offset of host name.
+	//
+	// offset: 0x000C (4 bytes)
+	ValueOffset uint32
+	// Length in bytes of host name.
+	//
+	// offset: 0x0010 (4 bytes)
+	ValueLength uint32
+}
+
+// parseV6 parses an API set schema of version 6.
+func (p *parser) parseV6() (*Schema, error) {
+	var hdr rawNamespaceV6
+	if err := p.read(0, &hdr); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	schema := &Schema{
+		Version: hdr.Version,
+		Flags:   hdr.Flags,
+	}
+	const (
+		entrySize = 24
+		valueSize = 20
+	)
+	if err := p.checkArray(hdr.EntryOffset, hdr.Count, entrySize); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for i := uint32(0); i < hdr.Count; i++ {
+		var entry rawNamespaceEntryV6
+		if err := p.read(hdr.EntryOffset+i*entrySize, &entry); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		name, err := p.string(entry.NameOffset, entry.NameLength)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		apiSet := APISet{
+			Name:  name,
+			Flags: entry.Flags,
+		}
+		if err := p.checkArray(entry.ValueOffset, entry.ValueCount, valueSize); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for j := uint32(0); j < entry.ValueCount; j++ {
+			var value rawValueEntryV6
+			if err := p.read(entry.ValueOffset+j*valueSize, &value); err != nil {
+				return nil, errors.WithStack(err)
+			}
+			host, err := p.host(value.NameOffset, value.NameLength, value.ValueOffset, value.ValueLength)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			apiSet.Hosts = append(apiSet.Hosts, host)
+		}
+		schema.APISets = append(schema.APISets, apiSet)
+	}
+	return schema, nil
+}
+
+// ### [ Helper functions ] ####################################################
+
+// host parses the host of an API set, given the offsets and lengths of the
+// importer and host names.
+func (p *parser) host(nameOffset, nameLength, valueOffset, valueLength uint32) (Host, error) {
+	importer, err := p.string(nameOffset, nameLength)
+	if err != nil {
+		return Host{}, errors.WithStack(err)
+	}
+	name, err := p.string(valueOffset, valueLength)
+	if err != nil {
+		return Host{}, errors.WithStack(err)
+	}
+	return Host{Importer: importer, Name: name}, nil
+}
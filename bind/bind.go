@@ -0,0 +1,364 @@
+// Package bind resolves the imports of PE files against a set of DLLs,
+// emulating the binding of imported symbols performed by the Windows loader.
+package bind
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mewmew/pe"
+	"github.com/pkg/errors"
+)
+
+// Errors returned for unresolved symbols.
+var (
+	// ErrModuleNotFound indicates that the DLL of an imported symbol was not
+	// found.
+	ErrModuleNotFound = errors.New("module not found")
+	// ErrExportNotFound indicates that the DLL of an imported symbol does not
+	// export the symbol.
+	ErrExportNotFound = errors.New("export not found")
+	// ErrForwarderCycle indicates that the forwarder chain of an imported
+	// symbol contains a cycle.
+	ErrForwarderCycle = errors.New("forwarder cycle")
+)
+
+// maxForwarders specifies the maximum length of forwarder chains.
+const maxForwarders = 32
+
+// Resolver resolves imports against a set of DLLs.
+type Resolver struct {
+	// DLLs indexed by lowercase file name (e.g. "kernel32.dll"). Consulted
+	// before the search path, and populated with the DLLs parsed from the
+	// search path.
+	DLLs map[string]*pe.File
+	// Directories searched for DLLs, in order.
+	SearchPath []string
+	// (optional) Redirect maps the name of an imported DLL to the name of the
+	// DLL used in its place (e.g. the host DLL of an API set), as imported by
+	// the given DLL; the importer name is empty for the PE file being
+	// resolved.
+	Redirect func(importer, dllName string) string
+
+	// File names of DLLs in each directory of the search path, indexed by
+	// lowercase file name.
+	dirs map[string]map[string]string
+}
+
+// NewResolver returns a new resolver which searches for DLLs in the given
+// directories.
+func NewResolver(searchPath ...string) *Resolver {
+	return &Resolver{
+		DLLs:       make(map[string]*pe.File),
+		SearchPath: searchPath,
+	}
+}
+
+// Symbol is an imported symbol.
+type Symbol struct {
+	// DLL name, as specified by the import table (e.g. "KERNEL32.dll").
+	DLL string
+	// Specifies whether to import by ordinal or name.
+	IsOrdinal bool
+	// Ordinal number (used if IsOrdinal is set).
+	Ordinal uint16
+	// Symbol name (used if IsOrdinal is clear).
+	Name string
+	// Index into the export name table of the DLL, used as a hint (used if
+	// IsOrdinal is clear).
+	Hint uint16
+	// Relative address of the import address table entry of the symbol
+	// (relative to image base of the importing file).
+	IATRelAddr uint32
+	// (optional) Lowercase file name of the importing DLL (e.g.
+	// "kernel32.dll"), passed to the redirect function of the resolver.
+	Importer string
+}
+
+// String returns the string representation of the imported symbol.
+func (sym Symbol) String() string {
+	if sym.IsOrdinal {
+		return fmt.Sprintf("%s!#%d", sym.DLL, sym.Ordinal)
+	}
+	return fmt.Sprintf("%s!%s", sym.DLL, sym.Name)
+}
+
+// Binding is an imported symbol bound to its address.
+type Binding struct {
+	// Imported symbol.
+	Symbol
+	// Lowercase file name of the DLL providing the symbol, after following
+	// forwarders (e.g. "ntdll.dll").
+	Module string
+	// Relative address of the symbol (relative to image base of the providing
+	// DLL).
+	RelAddr uint32
+	// Bound address of the symbol, based on the preferred image base of the
+	// providing DLL.
+	Addr uint64
+	// Forwarders followed to locate the symbol (e.g. "NTDLL.RtlAllocateHeap").
+	Forwarders []string
+}
+
+// Unresolved is an imported symbol which could not be resolved.
+type Unresolved struct {
+	// Imported symbol.
+	Symbol
+	// Reason for failing to resolve the symbol; the cause is one of
+	// ErrModuleNotFound, ErrExportNotFound or ErrForwarderCycle.
+	Err error
+}
+
+// Result is the result of resolving the imports of a PE file.
+type Result struct {
+	// Bound symbols.
+	Bindings []Binding
+	// Unresolved symbols.
+	Unresolved []Unresolved
+}
+
+// Resolve binds the imported symbols of the given PE file to the exports of
+// the DLLs available to the resolver. Symbols which could not be resolved are
+// reported in the result; an error is only returned if the import table of the
+// PE file is malformed, or a DLL could not be parsed.
+func (r *Resolver) Resolve(file *pe.File) (*Result, error) {
+	syms, err := Imports(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	result := &Result{}
+	for _, sym := range syms {
+		binding, err := r.ResolveSymbol(sym)
+		if err != nil {
+			if !IsUnresolved(err) {
+				return nil, errors.WithStack(err)
+			}
+			result.Unresolved = append(result.Unresolved, Unresolved{Symbol: sym, Err: err})
+			continue
+		}
+		result.Bindings = append(result.Bindings, binding)
+	}
+	return result, nil
+}
+
+// ResolveSymbol binds the given imported symbol to its address, following
+// forwarders across DLLs. If the symbol could not be resolved, the cause of the
+// returned error is one of ErrModuleNotFound, ErrExportNotFound or
+// ErrForwarderCycle.
+func (r *Resolver) ResolveSymbol(sym Symbol) (Binding, error) {
+	binding := Binding{
+		Symbol: sym,
+	}
+	target := sym
+	for {
+		dllName := target.DLL
+		if r.Redirect != nil {
+			dllName = r.Redirect(target.Importer, dllName)
+		}
+		module, dll, err := r.Load(dllName)
+		if err != nil {
+			return Binding{}, errors.WithStack(err)
+		}
+		exps, err := dll.Exports()
+		if err != nil {
+			return Binding{}, errors.WithStack(err)
+		}
+		var eat pe.EATEntry
+		var ok bool
+		if exps != nil {
+			if target.IsOrdinal {
+				eat, ok = exps.LookupOrdinal(target.Ordinal)
+			} else {
+				eat, ok = exps.LookupName(target.Name, target.Hint)
+			}
+		}
+		if !ok {
+			if target.IsOrdinal {
+				return Binding{}, errors.Wrapf(ErrExportNotFound, "unable to locate ordinal %d in %q", target.Ordinal, module)
+			}
+			return Binding{}, errors.Wrapf(ErrExportNotFound, "unable to locate %q in %q", target.Name, module)
+		}
+		if len(eat.Forwarder) == 0 {
+			binding.Module = module
+			binding.RelAddr = eat.RelAddr
+			binding.Addr = dll.OptHdr.ImageBase + uint64(eat.RelAddr)
+			return binding, nil
+		}
+		// Follow forwarder.
+		for _, fwd := range binding.Forwarders {
+			if strings.EqualFold(fwd, eat.Forwarder) {
+				return Binding{}, errors.Wrapf(ErrForwarderCycle, "forwarder %q of %v", eat.Forwarder, sym)
+			}
+		}
+		if len(binding.Forwarders) >= maxForwarders {
+			return Binding{}, errors.Wrapf(ErrForwarderCycle, "forwarder chain of %v exceeds %d entries", sym, maxForwarders)
+		}
+		binding.Forwarders = append(binding.Forwarders, eat.Forwarder)
+		// Hints are not available for forwarded symbols.
+		target, err = ParseForwarder(eat.Forwarder)
+		if err != nil {
+			return Binding{}, errors.WithStack(err)
+		}
+		target.Importer = module
+	}
+}
+
+// Load returns the parsed DLL of the given name, and its lowercase file name.
+// The DLL is located by consulting the DLLs of the resolver, followed by the
+// search path. If not found, the cause of the returned error is
+// ErrModuleNotFound.
+func (r *Resolver) Load(dllName string) (string, *pe.File, error) {
+	module := strings.ToLower(dllName)
+	if filepath.Ext(module) == "" {
+		module += ".dll"
+	}
+	if r.DLLs == nil {
+		r.DLLs = make(map[string]*pe.File)
+	}
+	if dll, ok := r.DLLs[module]; ok {
+		return module, dll, nil
+	}
+	for _, dir := range r.SearchPath {
+		fileNames, err := r.dirFileNames(dir)
+		if err != nil {
+			return "", nil, errors.WithStack(err)
+		}
+		fileName, ok := fileNames[module]
+		if !ok {
+			continue
+		}
+		dllPath := filepath.Join(dir, fileName)
+		buf, err := ioutil.ReadFile(dllPath)
+		if err != nil {
+			return "", nil, errors.WithStack(err)
+		}
+		// Only the headers are parsed up front; the export table is parsed on
+		// first use, and parse failures are reported by Exports.
+		dll, err := pe.NewFile(bytes.NewReader(buf), int64(len(buf)))
+		if err != nil {
+			return "", nil, errors.Wrapf(err, "unable to parse DLL %q", dllPath)
+		}
+		r.DLLs[module] = dll
+		return module, dll, nil
+	}
+	return "", nil, errors.Wrapf(ErrModuleNotFound, "unable to locate %q", module)
+}
+
+// dirFileNames returns the file names of the given directory, indexed by
+// lowercase file name.
+func (r *Resolver) dirFileNames(dir string) (map[string]string, error) {
+	if fileNames, ok := r.dirs[dir]; ok {
+		return fileNames, nil
+	}
+	fileNames := make(map[string]string)
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.WithStack(err)
+	}
+	for _, fi := range fis {
+		if fi.IsDir() {
+			continue
+		}
+		fileNames[strings.ToLower(fi.Name())] = fi.Name()
+	}
+	if r.dirs == nil {
+		r.dirs = make(map[string]map[string]string)
+	}
+	r.dirs[dir] = fileNames
+	return fileNames, nil
+}
+
+// IsUnresolved reports whether the given error indicates an unresolved
+// symbol.
+func IsUnresolved(err error) bool {
+	switch errors.Cause(err) {
+	case ErrModuleNotFound, ErrExportNotFound, ErrForwarderCycle:
+		return true
+	}
+	return false
+}
+
+// Imports returns the imported symbols of the given PE file.
+func Imports(file *pe.File) ([]Symbol, error) {
+	imps, err := file.Imports()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	ptrSize := ptrSize(file)
+	var syms []Symbol
+	for _, imp := range imps {
+		// Use IAT entries when the INT is not present.
+		ints := imp.INTs
+		if len(ints) == 0 {
+			ints = imp.IATs
+		}
+		for i, intEntry := range ints {
+			sym := Symbol{
+				DLL:        imp.ImpDir.Name,
+				IsOrdinal:  intEntry.IsOrdinal,
+				Ordinal:    intEntry.Ordinal,
+				Name:       intEntry.NameEntry.Name,
+				Hint:       intEntry.NameEntry.Hint,
+				IATRelAddr: imp.ImpDir.IATRelAddr + uint32(i)*ptrSize,
+			}
+			syms = append(syms, sym)
+		}
+	}
+	return syms, nil
+}
+
+// DelayImports returns the delay-load imported symbols of the given PE file.
+func DelayImports(file *pe.File) ([]Symbol, error) {
+	delayImps, err := file.DelayImports()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	ptrSize := ptrSize(file)
+	var syms []Symbol
+	for _, delayImp := range delayImps {
+		for i, intEntry := range delayImp.INTs {
+			sym := Symbol{
+				DLL:        delayImp.DelayImpDir.Name,
+				IsOrdinal:  intEntry.IsOrdinal,
+				Ordinal:    intEntry.Ordinal,
+				Name:       intEntry.NameEntry.Name,
+				Hint:       intEntry.NameEntry.Hint,
+				IATRelAddr: delayImp.DelayImpDir.IATRelAddr + uint32(i)*ptrSize,
+			}
+			syms = append(syms, sym)
+		}
+	}
+	return syms, nil
+}
+
+// ptrSize returns the pointer size in bytes of the given PE file.
+func ptrSize(file *pe.File) uint32 {
+	if file.OptHdr.Magic == 0x020B {
+		// PE32+ (64-bit).
+		return 8
+	}
+	return 4
+}
+
+// ParseForwarder parses the given forwarder of an exported symbol (e.g.
+// "NTDLL.RtlAllocateHeap" or "NTDLL.#123") into the symbol forwarded to.
+func ParseForwarder(fwd string) (Symbol, error) {
+	pos := strings.Index(fwd, ".")
+	if pos == -1 {
+		return Symbol{}, errors.Errorf("invalid forwarder %q; missing '.'", fwd)
+	}
+	dllName, name := fwd[:pos], fwd[pos+1:]
+	if strings.HasPrefix(name, "#") {
+		x, err := strconv.ParseUint(name[1:], 10, 16)
+		if err != nil {
+			return Symbol{}, errors.Wrapf(err, "invalid ordinal of forwarder %q", fwd)
+		}
+		return Symbol{DLL: dllName, IsOrdinal: true, Ordinal: uint16(x)}, nil
+	}
+	return Symbol{DLL: dllName, Name: name}, nil
+}
@@ -0,0 +1,162 @@
+// Package carve locates PE images embedded in arbitrary byte streams (e.g.
+// firmware, memory dumps, documents or the overlays of other PE files).
+//
+// Candidate images are located by the MS-DOS signature ("MZ"), and validated by
+// the offset of the PE header (e_lfanew) and the PE signature. The on-disk
+// length of each image is computed from its headers, section headers, COFF
+// symbol table and certificate table.
+package carve
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/mewmew/pe"
+	"github.com/pkg/errors"
+)
+
+// Image is a PE image embedded in a byte stream.
+type Image struct {
+	// Offset of the image in the byte stream.
+	Offset int64
+	// Length of the image in bytes, truncated at the end of the byte stream.
+	Length int64
+	// Specifies whether the image is truncated by the end of the byte stream.
+	Truncated bool
+	// PE file of the image, reading from the byte stream; the contents of data
+	// directories are parsed on first use.
+	File *pe.File
+}
+
+// maxPEOffset specifies the maximum offset of the PE header (e_lfanew)
+// accepted, relative to the MS-DOS header.
+const maxPEOffset = 0x10000
+
+// chunkSize specifies the size in bytes of chunks read while scanning.
+const chunkSize = 1 << 20
+
+// Scan locates the PE images embedded in the given byte stream of size bytes,
+// reading from r. Images are reported in order of offset, including images
+// embedded within other images (e.g. in resources or overlays).
+func Scan(r io.ReaderAt, size int64) ([]Image, error) {
+	var images []Image
+	// Consecutive chunks overlap by one byte, to locate signatures crossing
+	// chunk boundaries.
+	buf := make([]byte, chunkSize+1)
+	for offset := int64(0); offset < size; offset += chunkSize {
+		n, err := r.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return nil, errors.WithStack(err)
+		}
+		chunk := buf[:n]
+		for pos := 0; ; pos++ {
+			i := bytes.Index(chunk[pos:], []byte("MZ"))
+			if i == -1 {
+				break
+			}
+			pos += i
+			if pos >= chunkSize {
+				// Located in overlap; handled by next chunk.
+				break
+			}
+			image, ok := parseImage(r, size, offset+int64(pos))
+			if ok {
+				images = append(images, image)
+			}
+		}
+	}
+	return images, nil
+}
+
+// ScanBytes locates the PE images embedded in the given data. See Scan.
+func ScanBytes(data []byte) []Image {
+	// Reading from a byte slice never fails.
+	images, _ := Scan(bytes.NewReader(data), int64(len(data)))
+	return images
+}
+
+// parseImage parses the PE image at the given offset of the byte stream. The
+// boolean return value indicates whether a valid PE image was located.
+func parseImage(r io.ReaderAt, size, offset int64) (Image, bool) {
+	// Validate the MZ, e_lfanew and PE signature chain.
+	var dosHdr [0x40]byte
+	if _, err := r.ReadAt(dosHdr[:], offset); err != nil {
+		return Image{}, false
+	}
+	peOffset := int64(binary.LittleEndian.Uint32(dosHdr[0x3C:]))
+	if peOffset < int64(len(dosHdr)) || peOffset > maxPEOffset {
+		return Image{}, false
+	}
+	var sig [4]byte
+	if _, err := r.ReadAt(sig[:], offset+peOffset); err != nil {
+		return Image{}, false
+	}
+	if !bytes.Equal(sig[:], []byte("PE\x00\x00")) {
+		return Image{}, false
+	}
+	sr := io.NewSectionReader(r, offset, size-offset)
+	file, err := pe.NewFile(sr, size-offset)
+	if err != nil {
+		return Image{}, false
+	}
+	length := imageLength(file)
+	image := Image{
+		Offset: offset,
+		Length: length,
+	}
+	if length > size-offset {
+		image.Length = size - offset
+		image.Truncated = true
+	}
+	// Limit the PE file to the contents of the image.
+	file, err = pe.NewFile(io.NewSectionReader(r, offset, image.Length), image.Length)
+	if err != nil {
+		return Image{}, false
+	}
+	image.File = file
+	return image, true
+}
+
+// imageLength returns the on-disk length of the given PE image, as specified by
+// its headers; i.e. the end offset of the headers, the raw data of sections,
+// the COFF symbol table (and following string table) and the certificate
+// table, whichever is last.
+func imageLength(file *pe.File) int64 {
+	end := int64(file.OptHdr.HeadersSize)
+	for _, sectHdr := range file.SectHdrs {
+		if sectHdr.DataSize == 0 {
+			continue
+		}
+		end = maxInt64(end, int64(sectHdr.DataOffset)+int64(sectHdr.DataSize))
+	}
+	// COFF symbol table, followed by the string table; the first 4 bytes of
+	// the string table specify its size in bytes, including the size field.
+	if symTabOffset := int64(file.FileHdr.SymbolTableOffset); symTabOffset != 0 {
+		const symSize = 18
+		strTabOffset := symTabOffset + int64(file.FileHdr.NSymbols)*symSize
+		end = maxInt64(end, strTabOffset)
+		var buf [4]byte
+		if _, err := file.ReadAt(buf[:], strTabOffset); err == nil {
+			end = maxInt64(end, strTabOffset+int64(binary.LittleEndian.Uint32(buf[:])))
+		}
+	}
+	// Certificate table; the relative address of the data directory is a file
+	// offset.
+	const certTableIndex = 4
+	if certTableIndex < len(file.DataDirs) {
+		certTable := file.DataDirs[certTableIndex]
+		if certTable.RelAddr != 0 && certTable.Size != 0 {
+			end = maxInt64(end, int64(certTable.RelAddr)+int64(certTable.Size))
+		}
+	}
+	return end
+}
+
+// maxInt64 returns the maximum of x and y.
+func maxInt64(x, y int64) int64 {
+	if x > y {
+		return x
+	}
+	return y
+}
@@ -0,0 +1,89 @@
+// pediff compares two PE files structurally.
+//
+// Usage:
+//
+//	pediff [OPTION]... OLD.exe NEW.exe
+//
+// Flags:
+//
+//	-json
+//	      output JSON
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/mewmew/pe"
+	"github.com/mewmew/pe/pediff"
+	"github.com/pkg/errors"
+)
+
+func usage() {
+	const use = `
+Compare two PE files structurally.
+
+Usage:
+
+	pediff [OPTION]... OLD.exe NEW.exe
+
+Flags:
+`
+	fmt.Fprint(os.Stderr, use[1:])
+	flag.PrintDefaults()
+}
+
+func main() {
+	var (
+		// output JSON.
+		outputJSON bool
+	)
+	flag.BoolVar(&outputJSON, "json", false, "output JSON")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if err := diff(flag.Arg(0), flag.Arg(1), outputJSON); err != nil {
+		log.Fatalf("%+v", err)
+	}
+}
+
+// diff compares the given PE files, writing the report to standard output.
+func diff(oldPath, newPath string, outputJSON bool) error {
+	oldFile, err := parseFile(oldPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	newFile, err := parseFile(newPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	report, err := pediff.Compare(oldFile, newFile)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if outputJSON {
+		return report.WriteJSON(os.Stdout)
+	}
+	return report.WriteText(os.Stdout)
+}
+
+// parseFile parses the headers of the given PE file; the contents of data
+// directories are parsed on first use.
+func parseFile(pePath string) (*pe.File, error) {
+	buf, err := ioutil.ReadFile(pePath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	file, err := pe.NewFile(bytes.NewReader(buf), int64(len(buf)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse %q", pePath)
+	}
+	return file, nil
+}
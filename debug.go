@@ -1,6 +1,7 @@
 package pe
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/mewmew/pe/enum"
@@ -15,6 +16,9 @@ import (
 //    *DebugCodeView
 //    *DebugFPO
 //    *DebugMisc
+//
+// Debug data of types not otherwise supported (e.g. POGO, ILTCG, VC_FEATURE,
+// REPRO and EX_DLLCHARACTERISTICS) is stored as *DebugMisc with raw contents.
 type DebugData interface {
 	// DebugDir returns the debug data directory of the debug data.
 	DebugDir() DebugDirectory
@@ -55,8 +59,24 @@ func (dbg *DebugCodeView) DebugDir() DebugDirectory {
 	return dbg.DbgDir
 }
 
-// CodeViewInfo contains CodeView debug information.
-type CodeViewInfo struct {
+// CodeViewInfo contains CodeView debug information, identifying the PDB file
+// of the PE file.
+//
+// CodeViewInfo is one of the following types.
+//
+//    *CodeViewNB10
+//    *CodeViewRSDS
+type CodeViewInfo interface {
+	// PDB returns the path to the PDB file.
+	PDB() string
+	// SymbolServerKey returns the key used to locate the PDB file on symbol
+	// servers; the PDB file is stored at "<name>/<key>/<name>", where name is
+	// the base name of the PDB path.
+	SymbolServerKey() string
+}
+
+// CodeViewNB10 contains CodeView debug information in PDB 2.0 format.
+type CodeViewNB10 struct {
 	// CodeView signature ("NB10").
 	Signature uint32
 	// CodeView offset (set to 0 since debug info is stored in a separate file).
@@ -70,6 +90,58 @@ type CodeViewInfo struct {
 	PDBPath string
 }
 
+// PDB returns the path to the PDB file.
+func (info *CodeViewNB10) PDB() string {
+	return info.PDBPath
+}
+
+// SymbolServerKey returns the key used to locate the PDB file on symbol
+// servers; the creation time in hexadecimal followed by the age in
+// hexadecimal (e.g. "3417712B3").
+func (info *CodeViewNB10) SymbolServerKey() string {
+	return fmt.Sprintf("%08X%X", uint32(info.Date.Unix()), info.Age)
+}
+
+// CodeViewRSDS contains CodeView debug information in PDB 7.0 format.
+type CodeViewRSDS struct {
+	// CodeView signature ("RSDS").
+	Signature uint32
+	// Unique identifier of the PDB file.
+	GUID GUID
+	// Incremental number, initially set to 1 and incremented for each partial
+	// write to the PDB file.
+	Age uint32
+	// Path to PDB file (UTF-8 encoded).
+	PDBPath string
+}
+
+// PDB returns the path to the PDB file.
+func (info *CodeViewRSDS) PDB() string {
+	return info.PDBPath
+}
+
+// SymbolServerKey returns the key used to locate the PDB file on symbol
+// servers; the GUID in hexadecimal without separators followed by the age in
+// hexadecimal (e.g. "DBE09E71B3709CB722C55E5573FA7BE11").
+func (info *CodeViewRSDS) SymbolServerKey() string {
+	guid := info.GUID
+	return fmt.Sprintf("%08X%04X%04X%X%X", guid.Data1, guid.Data2, guid.Data3, guid.Data4[:], info.Age)
+}
+
+// GUID is a globally unique identifier.
+type GUID struct {
+	Data1 uint32
+	Data2 uint16
+	Data3 uint16
+	Data4 [8]byte
+}
+
+// String returns the string representation of the GUID (e.g.
+// "DBE09E71-B370-9CB7-22C5-5E5573FA7BE1").
+func (guid GUID) String() string {
+	return fmt.Sprintf("%08X-%04X-%04X-%X-%X", guid.Data1, guid.Data2, guid.Data3, guid.Data4[:2], guid.Data4[2:])
+}
+
 // ~~~ [ FPO ] ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 
 // DebugFPO contains the contents of a FPO debug data directory.
@@ -113,11 +185,12 @@ type FPOData struct {
 
 // ~~~ [ Misc ] ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 
-// DebugMisc contains the contents of a miscellaneous debug data directory.
+// DebugMisc contains the raw contents of a miscellaneous debug data directory,
+// or of a debug data directory of an unsupported type or CodeView format.
 type DebugMisc struct {
 	// Debug data directory.
 	DbgDir DebugDirectory
-	// Contents of miscellaneous debug data directory.
+	// Raw contents of debug data directory.
 	Content []byte
 }
 
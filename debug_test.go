@@ -0,0 +1,129 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/mewmew/pe/enum"
+)
+
+func TestParseDebugCodeViewInfo(t *testing.T) {
+	golden := []struct {
+		name string
+		buf  []byte
+		// Expected PDB path and symbol server key; empty if not supported.
+		pdb string
+		key string
+		// Expected GUID of RSDS debug information.
+		guid string
+	}{
+		{
+			// CodeView debug information of kernel32.dll.
+			name: "RSDS",
+			buf: cat(
+				[]byte("RSDS"),
+				[]byte{0x71, 0x9E, 0xE0, 0xDB, 0x70, 0xB3, 0xB7, 0x9C, 0x22, 0xC5, 0x5E, 0x55, 0x73, 0xFA, 0x7B, 0xE1},
+				[]byte{0x01, 0x00, 0x00, 0x00},
+				[]byte("kernel32.pdb\x00"),
+			),
+			pdb:  "kernel32.pdb",
+			key:  "DBE09E71B3709CB722C55E5573FA7BE11",
+			guid: "DBE09E71-B370-9CB7-22C5-5E5573FA7BE1",
+		},
+		{
+			name: "NB10",
+			buf: cat(
+				[]byte("NB10"),
+				[]byte{0x00, 0x00, 0x00, 0x00},
+				[]byte{0x2B, 0x71, 0x17, 0x34},
+				[]byte{0x03, 0x00, 0x00, 0x00},
+				[]byte(`d:\wince\arp.pdb`+"\x00"),
+			),
+			pdb: `d:\wince\arp.pdb`,
+			key: "3417712B3",
+		},
+		{name: "NB09", buf: []byte("NB09\x00\x00\x00\x00")},
+		{name: "zero", buf: make([]byte, 24)},
+		{name: "truncated", buf: []byte("RS")},
+	}
+	for _, g := range golden {
+		dbgCodeView, err := parseDebugCodeViewInfo(DebugDirectory{Type: enum.DebugTypeCodeView}, g.buf)
+		if err != nil {
+			t.Errorf("%s: unable to parse CodeView debug information; %+v", g.name, err)
+			continue
+		}
+		if len(g.pdb) == 0 {
+			if dbgCodeView != nil {
+				t.Errorf("%s: expected unsupported CodeView format, got %T", g.name, dbgCodeView.CodeViewInfo)
+			}
+			continue
+		}
+		if dbgCodeView == nil {
+			t.Errorf("%s: expected CodeView debug information, got nil", g.name)
+			continue
+		}
+		if got := dbgCodeView.PDB(); got != g.pdb {
+			t.Errorf("%s: PDB path mismatch; expected %q, got %q", g.name, g.pdb, got)
+		}
+		if got := dbgCodeView.SymbolServerKey(); got != g.key {
+			t.Errorf("%s: symbol server key mismatch; expected %q, got %q", g.name, g.key, got)
+		}
+		if info, ok := dbgCodeView.CodeViewInfo.(*CodeViewRSDS); ok {
+			if got := info.GUID.String(); got != g.guid {
+				t.Errorf("%s: GUID mismatch; expected %q, got %q", g.name, g.guid, got)
+			}
+		}
+	}
+}
+
+func TestDebugUnsupportedCodeView(t *testing.T) {
+	// Add CodeView debug information in NB11 format to the executable.
+	const path = "testdata/gcc-386-mingw-no-symbols-exec"
+	file, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("%q: unable to parse file; %+v", path, err)
+	}
+	const dbgDirSize = 28
+	info := []byte("NB11\x00\x00\x00\x00")
+	sectHdr, err := file.AddSection(".debug", enum.SectionFlagContainsInitializedData|enum.SectionFlagMemRead, make([]byte, dbgDirSize+len(info)))
+	if err != nil {
+		t.Fatalf("%q: unable to add section; %+v", path, err)
+	}
+	data := file.sectSrcs[len(file.sectSrcs)-1].data
+	binary.LittleEndian.PutUint32(data[12:], uint32(enum.DebugTypeCodeView))
+	binary.LittleEndian.PutUint32(data[16:], uint32(len(info)))
+	binary.LittleEndian.PutUint32(data[20:], uint32(sectHdr.RelAddr+dbgDirSize))
+	binary.LittleEndian.PutUint32(data[24:], uint32(sectHdr.DataOffset+dbgDirSize))
+	copy(data[dbgDirSize:], info)
+	file.DataDirs[dataDirDebug] = DataDirectory{RelAddr: sectHdr.RelAddr, Size: dbgDirSize}
+	buf := &bytes.Buffer{}
+	if _, err := file.WriteTo(buf); err != nil {
+		t.Fatalf("%q: unable to write file; %+v", path, err)
+	}
+	file, err = ParseBytes(buf.Bytes())
+	if err != nil {
+		t.Fatalf("%q: unable to parse file; %+v", path, err)
+	}
+	// The raw contents of unsupported CodeView formats are stored.
+	dbgData, err := file.Debug()
+	if err != nil {
+		t.Fatalf("%q: unable to parse debug data; %+v", path, err)
+	}
+	if len(dbgData) != 1 {
+		t.Fatalf("%q: number of debug data mismatch; expected 1, got %d", path, len(dbgData))
+	}
+	if dbg, ok := dbgData[0].(*DebugMisc); !ok {
+		t.Errorf("%q: debug data type mismatch; expected *DebugMisc, got %T", path, dbgData[0])
+	} else if !bytes.Equal(dbg.Content, info) {
+		t.Errorf("%q: contents mismatch of debug data; expected % X, got % X", path, info, dbg.Content)
+	}
+	if dbgCodeView, err := file.CodeView(); err != nil || dbgCodeView != nil {
+		t.Errorf("%q: CodeView debug information mismatch; expected nil, got %v (%v)", path, dbgCodeView, err)
+	}
+}
+
+// cat returns the concatenation of the given byte slices.
+func cat(bufs ...[]byte) []byte {
+	return bytes.Join(bufs, nil)
+}
@@ -0,0 +1,340 @@
+// Package depgraph builds DLL dependency graphs of PE files.
+//
+// Starting from a PE file, the regular, delay-load and bound imports are
+// walked recursively, locating DLLs using a bind.Resolver. Missing modules,
+// missing exports and dependency cycles are recorded in the graph.
+package depgraph
+
+import (
+	"strings"
+
+	"github.com/mewmew/pe"
+	"github.com/mewmew/pe/bind"
+	"github.com/pkg/errors"
+)
+
+// Kind specifies the kind of a dependency.
+type Kind uint8
+
+// Dependency kinds.
+const (
+	// Regular import.
+	KindImport Kind = iota + 1
+	// Delay-load import.
+	KindDelayImport
+	// Bound import.
+	KindBoundImport
+	// Forwarded export of an imported symbol.
+	KindForwarder
+)
+
+// String returns the string representation of the dependency kind.
+func (kind Kind) String() string {
+	switch kind {
+	case KindImport:
+		return "import"
+	case KindDelayImport:
+		return "delay"
+	case KindBoundImport:
+		return "bound"
+	case KindForwarder:
+		return "forwarder"
+	default:
+		return "unknown"
+	}
+}
+
+// Graph is a DLL dependency graph.
+type Graph struct {
+	// Root module of the graph.
+	Root *Node
+	// Modules of the graph, in order of discovery.
+	Nodes []*Node
+	// Dependencies between modules, in order of discovery.
+	Edges []*Edge
+	// Dependency cycles, each specified by the names of its modules in order.
+	Cycles [][]string
+}
+
+// Node is a module of a dependency graph.
+type Node struct {
+	// Lowercase file name of the module (e.g. "kernel32.dll").
+	Name string
+	// Specifies whether the module was not found.
+	Missing bool
+	// (optional) Error encountered while parsing the module, if malformed
+	// (e.g. a truncated import table); the remaining dependencies of malformed
+	// modules are not walked.
+	Err error
+}
+
+// Edge is a dependency between two modules.
+type Edge struct {
+	// Importing module.
+	From *Node
+	// Imported module.
+	To *Node
+	// Dependency kind.
+	Kind Kind
+	// DLL names as imported, if redirected to the imported module (e.g. API
+	// set names).
+	Via []string
+	// Imported symbols which could not be resolved (e.g. missing exports, or
+	// malformed export tables).
+	Unresolved []bind.Unresolved
+}
+
+// Build builds the dependency graph of the given PE file, with the given
+// module name (e.g. "foo.exe"). DLLs are located using the given resolver.
+// Malformed DLLs are recorded in the graph (see Node.Err and Edge.Unresolved)
+// rather than aborting the walk.
+func Build(file *pe.File, name string, r *bind.Resolver) (*Graph, error) {
+	b := &builder{
+		r:     r,
+		g:     &Graph{},
+		nodes: make(map[string]*Node),
+		edges: make(map[edgeKey]*Edge),
+		files: make(map[*Node]*pe.File),
+	}
+	root := b.node(strings.ToLower(name))
+	b.g.Root = root
+	b.files[root] = file
+	// Walk modules in order of discovery.
+	for i := 0; i < len(b.g.Nodes); i++ {
+		node := b.g.Nodes[i]
+		file, ok := b.files[node]
+		if !ok {
+			// Missing module.
+			continue
+		}
+		if err := b.walk(node, file); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	b.g.Cycles = findCycles(b.g)
+	return b.g, nil
+}
+
+// builder is a dependency graph builder.
+type builder struct {
+	// Resolver used to locate DLLs.
+	r *bind.Resolver
+	// Dependency graph.
+	g *Graph
+	// Modules indexed by lowercase file name.
+	nodes map[string]*Node
+	// Dependencies indexed by importing module, imported module and kind.
+	edges map[edgeKey]*Edge
+	// Parsed PE file of each module found.
+	files map[*Node]*pe.File
+}
+
+// edgeKey is the key of a dependency.
+type edgeKey struct {
+	from, to *Node
+	kind     Kind
+}
+
+// node returns the module of the given lowercase file name, creating it if not
+// present.
+func (b *builder) node(name string) *Node {
+	if node, ok := b.nodes[name]; ok {
+		return node
+	}
+	node := &Node{Name: name}
+	b.nodes[name] = node
+	b.g.Nodes = append(b.g.Nodes, node)
+	return node
+}
+
+// walk adds the dependencies of the given module to the graph.
+func (b *builder) walk(node *Node, file *pe.File) error {
+	syms, err := bind.Imports(file)
+	if err != nil {
+		// Malformed import table.
+		node.Err = err
+		return nil
+	}
+	if err := b.addImports(node, syms, KindImport); err != nil {
+		return errors.WithStack(err)
+	}
+	delaySyms, err := bind.DelayImports(file)
+	if err != nil {
+		// Malformed delay import table.
+		node.Err = err
+		return nil
+	}
+	if err := b.addImports(node, delaySyms, KindDelayImport); err != nil {
+		return errors.WithStack(err)
+	}
+	boundImps, err := file.BoundImports()
+	if err != nil {
+		// Malformed bound import table.
+		node.Err = err
+		return nil
+	}
+	for _, boundImp := range boundImps {
+		b.addDep(node, boundImp.Name, KindBoundImport)
+		for _, ref := range boundImp.ForwarderRefs {
+			b.addDep(node, ref.Name, KindBoundImport)
+		}
+	}
+	return nil
+}
+
+// addImports adds the dependencies of the given imported symbols of a module
+// to the graph.
+func (b *builder) addImports(node *Node, syms []bind.Symbol, kind Kind) error {
+	for _, sym := range syms {
+		if node != b.g.Root {
+			sym.Importer = node.Name
+		}
+		edge := b.addDep(node, sym.DLL, kind)
+		if edge.To.Missing || edge.To.Err != nil {
+			continue
+		}
+		binding, err := b.r.ResolveSymbol(sym)
+		if err != nil {
+			// Record unresolved symbols along with the cause, including parse
+			// errors of malformed DLLs (e.g. with a truncated export table).
+			edge.Unresolved = append(edge.Unresolved, bind.Unresolved{Symbol: sym, Err: err})
+			continue
+		}
+		// Add dependencies of forwarded exports.
+		from := edge.To
+		for _, fwd := range binding.Forwarders {
+			fwdSym, err := bind.ParseForwarder(fwd)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			fwdEdge := b.addDep(from, fwdSym.DLL, KindForwarder)
+			from = fwdEdge.To
+		}
+	}
+	return nil
+}
+
+// addDep adds a dependency of the given kind from the module to the imported
+// DLL, and returns the corresponding edge.
+func (b *builder) addDep(from *Node, dllName string, kind Kind) *Edge {
+	importer := from.Name
+	if from == b.g.Root {
+		importer = ""
+	}
+	target := dllName
+	if b.r.Redirect != nil {
+		target = b.r.Redirect(importer, dllName)
+	}
+	module, file, err := b.r.Load(target)
+	var to *Node
+	switch {
+	case err == nil:
+		to = b.node(module)
+		if _, ok := b.files[to]; !ok {
+			b.files[to] = file
+		}
+	case errors.Cause(err) == bind.ErrModuleNotFound:
+		to = b.node(moduleName(target))
+		to.Missing = true
+	default:
+		// Malformed DLL.
+		to = b.node(moduleName(target))
+		to.Err = err
+	}
+	key := edgeKey{from: from, to: to, kind: kind}
+	edge, ok := b.edges[key]
+	if !ok {
+		edge = &Edge{From: from, To: to, Kind: kind}
+		b.edges[key] = edge
+		b.g.Edges = append(b.g.Edges, edge)
+	}
+	if !strings.EqualFold(target, dllName) && !contains(edge.Via, dllName) {
+		edge.Via = append(edge.Via, dllName)
+	}
+	return edge
+}
+
+// findCycles returns the dependency cycles of the given graph.
+func findCycles(g *Graph) [][]string {
+	succs := make(map[*Node][]*Node)
+	for _, edge := range g.Edges {
+		succs[edge.From] = append(succs[edge.From], edge.To)
+	}
+	const (
+		white = iota // not yet visited.
+		grey         // being visited.
+		black        // visited.
+	)
+	color := make(map[*Node]int)
+	var stack []*Node
+	var cycles [][]string
+	seen := make(map[string]bool)
+	var visit func(node *Node)
+	visit = func(node *Node) {
+		color[node] = grey
+		stack = append(stack, node)
+		for _, succ := range succs[node] {
+			switch color[succ] {
+			case white:
+				visit(succ)
+			case grey:
+				// Back edge; record cycle from succ to node.
+				var cycle []string
+				for i := len(stack) - 1; i >= 0; i-- {
+					cycle = append([]string{stack[i].Name}, cycle...)
+					if stack[i] == succ {
+						break
+					}
+				}
+				key := cycleKey(cycle)
+				if !seen[key] {
+					seen[key] = true
+					cycles = append(cycles, cycle)
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		color[node] = black
+	}
+	for _, node := range g.Nodes {
+		if color[node] == white {
+			visit(node)
+		}
+	}
+	return cycles
+}
+
+// ### [ Helper functions ] ####################################################
+
+// moduleName returns the lowercase file name of the given DLL name.
+func moduleName(dllName string) string {
+	module := strings.ToLower(dllName)
+	if !strings.Contains(module, ".") {
+		module += ".dll"
+	}
+	return module
+}
+
+// cycleKey returns a key identifying the given cycle, regardless of its
+// starting module.
+func cycleKey(cycle []string) string {
+	// Rotate cycle to start at its lexically smallest module.
+	min := 0
+	for i, name := range cycle {
+		if name < cycle[min] {
+			min = i
+		}
+	}
+	rotated := append(append([]string{}, cycle[min:]...), cycle[:min]...)
+	return strings.Join(rotated, "\x00")
+}
+
+// contains reports whether the list of strings contains s.
+func contains(ss []string, s string) bool {
+	for _, t := range ss {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package depgraph
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// WriteDOT writes the dependency graph to w in Graphviz DOT format. Missing
+// modules are drawn with dashed red outlines, malformed modules with red
+// outlines, and delay-load dependencies with dashed edges.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph {")
+	for _, node := range g.Nodes {
+		var attrs string
+		switch {
+		case node.Missing:
+			attrs = " [style=dashed color=red]"
+		case node.Err != nil:
+			attrs = " [color=red]"
+		case node == g.Root:
+			attrs = " [shape=box]"
+		}
+		fmt.Fprintf(bw, "\t%s%s\n", strconv.Quote(node.Name), attrs)
+	}
+	for _, edge := range g.Edges {
+		var attrs string
+		switch edge.Kind {
+		case KindDelayImport:
+			attrs = " [style=dashed]"
+		case KindBoundImport:
+			attrs = " [style=dotted]"
+		case KindForwarder:
+			attrs = " [color=blue]"
+		}
+		if len(edge.Unresolved) > 0 && edge.Kind != KindForwarder {
+			attrs = fmt.Sprintf(" [color=red label=%s]", strconv.Quote(fmt.Sprintf("%d unresolved", len(edge.Unresolved))))
+			if edge.Kind == KindDelayImport {
+				attrs = attrs[:len(attrs)-1] + " style=dashed]"
+			}
+		}
+		fmt.Fprintf(bw, "\t%s -> %s%s\n", strconv.Quote(edge.From.Name), strconv.Quote(edge.To.Name), attrs)
+	}
+	fmt.Fprintln(bw, "}")
+	if err := bw.Flush(); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// WriteJSON writes the dependency graph to w in JSON format.
+func (g *Graph) WriteJSON(w io.Writer) error {
+	jg := jsonGraph{
+		Cycles: g.Cycles,
+	}
+	if g.Root != nil {
+		jg.Root = g.Root.Name
+	}
+	for _, node := range g.Nodes {
+		jn := jsonNode{Name: node.Name, Missing: node.Missing}
+		if node.Err != nil {
+			jn.Err = node.Err.Error()
+		}
+		jg.Nodes = append(jg.Nodes, jn)
+	}
+	for _, edge := range g.Edges {
+		je := jsonEdge{
+			From: edge.From.Name,
+			To:   edge.To.Name,
+			Kind: edge.Kind.String(),
+			Via:  edge.Via,
+		}
+		for _, u := range edge.Unresolved {
+			je.Unresolved = append(je.Unresolved, jsonUnresolved{Symbol: u.Symbol.String(), Err: u.Err.Error()})
+		}
+		jg.Edges = append(jg.Edges, je)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(jg); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// jsonGraph is the JSON representation of a dependency graph.
+type jsonGraph struct {
+	Root   string     `json:"root"`
+	Nodes  []jsonNode `json:"nodes"`
+	Edges  []jsonEdge `json:"edges"`
+	Cycles [][]string `json:"cycles,omitempty"`
+}
+
+// jsonNode is the JSON representation of a module.
+type jsonNode struct {
+	Name    string `json:"name"`
+	Missing bool   `json:"missing,omitempty"`
+	Err     string `json:"error,omitempty"`
+}
+
+// jsonEdge is the JSON representation of a dependency.
+type jsonEdge struct {
+	From       string           `json:"from"`
+	To         string           `json:"to"`
+	Kind       string           `json:"kind"`
+	Via        []string         `json:"via,omitempty"`
+	Unresolved []jsonUnresolved `json:"unresolved,omitempty"`
+}
+
+// jsonUnresolved is the JSON representation of an unresolved symbol.
+type jsonUnresolved struct {
+	Symbol string `json:"symbol"`
+	Err    string `json:"error"`
+}
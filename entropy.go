@@ -0,0 +1,209 @@
+package pe
+
+import (
+	"io"
+	"math"
+)
+
+// ByteStats holds byte frequency statistics of a data block, used to identify
+// compressed or encrypted contents.
+type ByteStats struct {
+	// Size of data in number of bytes.
+	Size int
+	// Number of occurrences of each byte value.
+	Histogram [256]int
+	// Shannon entropy in bits per byte, in the range [0, 8]. Compressed or
+	// encrypted data has an entropy close to 8.
+	Entropy float64
+	// Pearson's chi-square statistic of the byte distribution, compared to a
+	// uniform distribution. Encrypted data has a chi-square close to 255, the
+	// number of degrees of freedom; compressed data typically has a
+	// considerably larger chi-square.
+	ChiSquare float64
+}
+
+// NewByteStats returns the byte frequency statistics of the given data.
+func NewByteStats(data []byte) ByteStats {
+	stats := ByteStats{
+		Size: len(data),
+	}
+	for _, b := range data {
+		stats.Histogram[b]++
+	}
+	stats.calcStats()
+	return stats
+}
+
+// calcStats computes the entropy and chi-square statistic from the size and
+// histogram of the byte frequency statistics.
+func (stats *ByteStats) calcStats() {
+	if stats.Size == 0 {
+		return
+	}
+	n := float64(stats.Size)
+	expected := n / 256
+	for _, count := range stats.Histogram {
+		d := float64(count) - expected
+		stats.ChiSquare += d * d / expected
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / n
+		stats.Entropy -= p * math.Log2(p)
+	}
+}
+
+// rangeStats returns the byte frequency statistics of the n bytes of the PE
+// file at the given file offset, truncated at the end of file. The contents
+// are read in chunks, to support large files opened with NewFile.
+func (file *File) rangeStats(offset, n int64) ByteStats {
+	var stats ByteStats
+	buf := make([]byte, 1<<20)
+	sr := io.NewSectionReader(file, offset, n)
+	for {
+		m, err := sr.Read(buf)
+		for _, b := range buf[:m] {
+			stats.Histogram[b]++
+		}
+		stats.Size += m
+		if err != nil {
+			break
+		}
+	}
+	stats.calcStats()
+	return stats
+}
+
+// SectionData returns the raw contents of the given section on file,
+// truncated at the end of file.
+func (file *File) SectionData(sectHdr SectionHeader) []byte {
+	return file.fileRange(file.sectionOffset(sectHdr), file.sectionSize(sectHdr))
+}
+
+// SectionStats returns the byte frequency statistics of the raw contents of
+// each section, in the same order as the section headers.
+func (file *File) SectionStats() []ByteStats {
+	stats := make([]ByteStats, len(file.SectHdrs))
+	for i, sectHdr := range file.SectHdrs {
+		stats[i] = file.rangeStats(int64(file.sectionOffset(sectHdr)), int64(file.sectionSize(sectHdr)))
+	}
+	return stats
+}
+
+// FileStats returns the byte frequency statistics of the whole PE file.
+func (file *File) FileStats() ByteStats {
+	return file.rangeStats(0, file.Size())
+}
+
+// OverlayOffset returns the file offset of the overlay, the data appended to
+// the PE file after the headers and the raw contents of sections. The overlay
+// is not mapped into memory by the Windows loader. The boolean return value
+// indicates whether the PE file has an overlay; mapped images have none.
+func (file *File) OverlayOffset() (int64, bool) {
+	if file.mapped {
+		return 0, false
+	}
+	end := file.layoutEnd()
+	if end >= uint64(file.Size()) {
+		return 0, false
+	}
+	return int64(end), true
+}
+
+// layoutEnd returns the end offset of the headers and the raw contents of
+// sections, as specified by the headers; which may extend past the end of
+// truncated files.
+func (file *File) layoutEnd() uint64 {
+	var end uint64
+	if file.OptHdr != nil {
+		end = uint64(file.OptHdr.HeadersSize)
+	}
+	for _, sectHdr := range file.SectHdrs {
+		if sectHdr.DataSize == 0 {
+			continue
+		}
+		end = maxUint64(end, uint64(sectHdr.DataOffset)+uint64(sectHdr.DataSize))
+	}
+	return end
+}
+
+// Overlay returns the contents of the overlay, the data appended to the PE
+// file after the headers and the raw contents of sections; or nil if not
+// present.
+func (file *File) Overlay() []byte {
+	offset, ok := file.OverlayOffset()
+	if !ok {
+		return nil
+	}
+	buf := make([]byte, file.Size()-offset)
+	n, _ := file.ReadAt(buf, offset)
+	return buf[:n]
+}
+
+// OverlayReader returns a reader of the overlay, or nil if not present. The
+// data is read on demand.
+func (file *File) OverlayReader() *io.SectionReader {
+	offset, ok := file.OverlayOffset()
+	if !ok {
+		return nil
+	}
+	return io.NewSectionReader(file, offset, file.Size()-offset)
+}
+
+// OverlayStats returns the byte frequency statistics of the overlay.
+func (file *File) OverlayStats() ByteStats {
+	offset, ok := file.OverlayOffset()
+	if !ok {
+		return ByteStats{}
+	}
+	return file.rangeStats(offset, file.Size()-offset)
+}
+
+// EntropyWindows returns the Shannon entropy in bits per byte of each window
+// of the given size in data, with consecutive windows starting step bytes
+// apart. The last window is dropped if it extends past the end of data.
+//
+// Use file.SectionData to compute the entropy profile of a section.
+func EntropyWindows(data []byte, window, step int) []float64 {
+	if window <= 0 || step <= 0 || len(data) < window {
+		return nil
+	}
+	// Precompute -p*log2(p) for each possible count of a byte value.
+	terms := make([]float64, window+1)
+	for count := 1; count <= window; count++ {
+		p := float64(count) / float64(window)
+		terms[count] = -p * math.Log2(p)
+	}
+	var hist [256]int
+	for _, b := range data[:window] {
+		hist[b]++
+	}
+	var entropies []float64
+	for start := 0; ; {
+		var entropy float64
+		for _, count := range hist {
+			entropy += terms[count]
+		}
+		entropies = append(entropies, entropy)
+		next := start + step
+		if next+window > len(data) {
+			break
+		}
+		if step < window {
+			// Slide the window, updating the histogram incrementally.
+			for _, b := range data[start:next] {
+				hist[b]--
+			}
+			for _, b := range data[start+window : next+window] {
+				hist[b]++
+			}
+		} else {
+			hist = [256]int{}
+			for _, b := range data[next : next+window] {
+				hist[b]++
+			}
+		}
+		start = next
+	}
+	return entropies
+}
@@ -0,0 +1,104 @@
+package pe
+
+import (
+	"sort"
+	"time"
+)
+
+// ExportDirectory is an export data directory.
+type ExportDirectory struct {
+	// Reserved.
+	Characteristics uint32
+	// Export data creation time.
+	Date time.Time
+	// Major export table format version.
+	MajorVer uint16
+	// Minor export table format version.
+	MinorVer uint16
+	// DLL name.
+	Name string
+	// Starting ordinal number of exports in the image; typically 1.
+	OrdinalBase uint32
+	// Number of entries in the export address table.
+	NFuncs uint32
+	// Number of entries in the export name pointer table.
+	NNames uint32
+	// Relative address of export address table (EAT).
+	FuncsRelAddr uint32
+	// Relative address of export name pointer table.
+	NamesRelAddr uint32
+	// Relative address of export ordinal table.
+	OrdinalsRelAddr uint32
+}
+
+// ExportTable contains the contents of an export table.
+type ExportTable struct {
+	// Export data directory.
+	ExpDir ExportDirectory
+	// Export address table entries, indexed by ordinal minus ordinal base.
+	EATs []EATEntry
+	// Export name table entries, sorted lexically by name.
+	Names []ExportName
+}
+
+// EATEntry is an export address table entry.
+type EATEntry struct {
+	// Ordinal number (biased by ordinal base).
+	Ordinal uint16
+	// Relative address of the exported symbol (relative to image base); zero
+	// if not present.
+	RelAddr uint32
+	// Forwarder of the exported symbol (e.g. "NTDLL.RtlAllocateHeap" or
+	// "NTDLL.#123"); empty if not forwarded.
+	Forwarder string
+}
+
+// ExportName is an export name table entry.
+type ExportName struct {
+	// Name of the exported symbol.
+	Name string
+	// Index into the export address table (unbiased ordinal).
+	EATIndex uint16
+}
+
+// LookupName returns the export address table entry of the symbol exported
+// with the given name, using the hint (index into the export name table) to
+// try and locate the entry; if not successful, by binary search of the export
+// name table. The boolean return value indicates success.
+func (exps *ExportTable) LookupName(name string, hint uint16) (EATEntry, bool) {
+	if int(hint) < len(exps.Names) && exps.Names[hint].Name == name {
+		return exps.eatEntry(exps.Names[hint].EATIndex)
+	}
+	i := sort.Search(len(exps.Names), func(i int) bool {
+		return exps.Names[i].Name >= name
+	})
+	if i < len(exps.Names) && exps.Names[i].Name == name {
+		return exps.eatEntry(exps.Names[i].EATIndex)
+	}
+	return EATEntry{}, false
+}
+
+// LookupOrdinal returns the export address table entry of the symbol exported
+// with the given ordinal number (biased by ordinal base). The boolean return
+// value indicates success.
+func (exps *ExportTable) LookupOrdinal(ordinal uint16) (EATEntry, bool) {
+	if uint32(ordinal) < exps.ExpDir.OrdinalBase {
+		return EATEntry{}, false
+	}
+	idx := uint32(ordinal) - exps.ExpDir.OrdinalBase
+	return exps.eatEntry(uint16(idx))
+}
+
+// eatEntry returns the export address table entry at the given index. The
+// boolean return value indicates success.
+func (exps *ExportTable) eatEntry(idx uint16) (EATEntry, bool) {
+	if int(idx) >= len(exps.EATs) {
+		return EATEntry{}, false
+	}
+	eat := exps.EATs[idx]
+	if eat.RelAddr == 0 {
+		// Unused entry.
+		return EATEntry{}, false
+	}
+	return eat, true
+}
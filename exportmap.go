@@ -0,0 +1,217 @@
+package pe
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// --- [ Export map ] ----------------------------------------------------------
+
+// ExportSymbol is a symbol exported by a DLL.
+type ExportSymbol struct {
+	// DLL name (e.g. "KERNEL32.dll").
+	DLL string
+	// Ordinal number (biased by ordinal base).
+	Ordinal uint16
+	// Symbol name; empty if exported by ordinal only.
+	Name string
+	// Index into the export name table of the DLL (used if Name is present).
+	Hint uint16
+}
+
+// intEntry returns the import name table entry importing the exported symbol;
+// by name if present, and by ordinal otherwise.
+func (sym ExportSymbol) intEntry() INTEntry {
+	if len(sym.Name) == 0 {
+		return INTEntry{IsOrdinal: true, Ordinal: sym.Ordinal}
+	}
+	return INTEntry{NameEntry: NameEntry{Hint: sym.Hint, Name: sym.Name}}
+}
+
+// ExportMap maps addresses in the address space of a process to the symbols
+// exported at each address by the DLLs loaded in the process. It is used to
+// rebuild the import table of images dumped from memory, the import address
+// tables of which contain resolved addresses (see Unmap).
+type ExportMap struct {
+	// DLLs in order of addition.
+	modules []exportModule
+	// Exported symbols indexed by address; nil if not yet computed.
+	syms map[uint64][]ExportSymbol
+}
+
+// exportModule is a DLL loaded in the address space of a process.
+type exportModule struct {
+	// DLL name.
+	name string
+	// Export table of the DLL.
+	exps *ExportTable
+	// Image base of the DLL in the process.
+	base uint64
+}
+
+// NewExportMap returns a new empty export map.
+func NewExportMap() *ExportMap {
+	return &ExportMap{}
+}
+
+// AddModule adds the exports of the given DLL, loaded at the specified image
+// base in the address space of the process.
+func (m *ExportMap) AddModule(dllName string, dll *File, base uint64) error {
+	exps, err := dll.Exports()
+	if err != nil {
+		return errors.Wrapf(err, "unable to parse exports of %q", dllName)
+	}
+	if exps == nil {
+		return nil
+	}
+	mod := exportModule{
+		name: dllName,
+		exps: exps,
+		base: base,
+	}
+	m.modules = append(m.modules, mod)
+	// Recompute symbols on next lookup.
+	m.syms = nil
+	return nil
+}
+
+// Lookup returns the symbols exported at the given address. Symbols exported
+// directly precede symbols forwarded to the address (e.g. ntdll.dll
+// RtlAllocateHeap precedes kernel32.dll HeapAlloc), and are otherwise ordered
+// by module addition.
+func (m *ExportMap) Lookup(addr uint64) []ExportSymbol {
+	if m.syms == nil {
+		m.init()
+	}
+	return m.syms[addr]
+}
+
+// init computes the exported symbols of each address, following forwarders
+// between the modules of the export map.
+func (m *ExportMap) init() {
+	m.syms = make(map[uint64][]ExportSymbol)
+	var forwarded []ExportSymbol
+	var fwdAddrs []uint64
+	for _, mod := range m.modules {
+		for _, sym := range mod.symbols() {
+			eat, ok := mod.lookup(sym)
+			if !ok {
+				continue
+			}
+			if len(eat.Forwarder) == 0 {
+				addr := mod.base + uint64(eat.RelAddr)
+				m.syms[addr] = append(m.syms[addr], sym)
+				continue
+			}
+			addr, ok := m.resolveForwarder(eat.Forwarder)
+			if !ok {
+				// Skip forwarders to unknown modules (e.g. API sets).
+				continue
+			}
+			forwarded = append(forwarded, sym)
+			fwdAddrs = append(fwdAddrs, addr)
+		}
+	}
+	for i, sym := range forwarded {
+		addr := fwdAddrs[i]
+		m.syms[addr] = append(m.syms[addr], sym)
+	}
+}
+
+// resolveForwarder returns the address of the symbol forwarded to by the given
+// forwarder (e.g. "NTDLL.RtlAllocateHeap" or "NTDLL.#123"), following chains
+// of forwarders. The boolean return value indicates success.
+func (m *ExportMap) resolveForwarder(fwd string) (uint64, bool) {
+	// Maximum length of forwarder chains.
+	const maxForwarders = 32
+	for i := 0; i < maxForwarders; i++ {
+		pos := strings.LastIndex(fwd, ".")
+		if pos == -1 {
+			return 0, false
+		}
+		mod, ok := m.module(fwd[:pos])
+		if !ok {
+			return 0, false
+		}
+		name := fwd[pos+1:]
+		var eat EATEntry
+		if strings.HasPrefix(name, "#") {
+			ordinal, err := strconv.ParseUint(name[1:], 10, 16)
+			if err != nil {
+				return 0, false
+			}
+			eat, ok = mod.exps.LookupOrdinal(uint16(ordinal))
+		} else {
+			eat, ok = mod.exps.LookupName(name, 0)
+		}
+		if !ok {
+			return 0, false
+		}
+		if len(eat.Forwarder) == 0 {
+			return mod.base + uint64(eat.RelAddr), true
+		}
+		fwd = eat.Forwarder
+	}
+	return 0, false
+}
+
+// module returns the module with the given DLL name, ignoring case and
+// extension. The boolean return value indicates success.
+func (m *ExportMap) module(dllName string) (exportModule, bool) {
+	for _, mod := range m.modules {
+		if strings.EqualFold(trimDLLExt(mod.name), trimDLLExt(dllName)) {
+			return mod, true
+		}
+	}
+	return exportModule{}, false
+}
+
+// symbols returns the symbols exported by the module; symbols exported by name
+// precede those exported by ordinal only.
+func (mod exportModule) symbols() []ExportSymbol {
+	var syms []ExportSymbol
+	named := make(map[uint16]bool)
+	for hint, name := range mod.exps.Names {
+		if int(name.EATIndex) >= len(mod.exps.EATs) {
+			continue
+		}
+		named[name.EATIndex] = true
+		sym := ExportSymbol{
+			DLL:     mod.name,
+			Ordinal: uint16(uint32(name.EATIndex) + mod.exps.ExpDir.OrdinalBase),
+			Name:    name.Name,
+			Hint:    uint16(hint),
+		}
+		syms = append(syms, sym)
+	}
+	for idx, eat := range mod.exps.EATs {
+		if eat.RelAddr == 0 || named[uint16(idx)] {
+			continue
+		}
+		sym := ExportSymbol{
+			DLL:     mod.name,
+			Ordinal: uint16(uint32(idx) + mod.exps.ExpDir.OrdinalBase),
+		}
+		syms = append(syms, sym)
+	}
+	return syms
+}
+
+// lookup returns the export address table entry of the given symbol exported
+// by the module. The boolean return value indicates success.
+func (mod exportModule) lookup(sym ExportSymbol) (EATEntry, bool) {
+	if len(sym.Name) > 0 {
+		return mod.exps.LookupName(sym.Name, sym.Hint)
+	}
+	return mod.exps.LookupOrdinal(sym.Ordinal)
+}
+
+// trimDLLExt returns the given DLL name without the ".dll" extension.
+func trimDLLExt(dllName string) string {
+	if strings.HasSuffix(strings.ToLower(dllName), ".dll") {
+		return dllName[:len(dllName)-len(".dll")]
+	}
+	return dllName
+}
@@ -1,10 +1,11 @@
 package pe
 
 import (
-	"fmt"
+	"io"
 	"time"
 
 	"github.com/mewmew/pe/enum"
+	"github.com/pkg/errors"
 )
 
 // File is a Portable Executable (PE) file.
@@ -22,10 +23,11 @@ type File struct {
 	// Data directory contents.
 	//
 	// 0 - Export Table
+	Exps *ExportTable
 	// 1 - Import Table
 	Imps []ImportEntry
 	// 2 - Resource Table
-	Resources []Resource
+	Rsrcs *ResourceDirectory
 	// 3 - Exception Table
 	// 4 - Certificate Table
 	// 5 - Base Relocation Table
@@ -37,27 +39,85 @@ type File struct {
 	// 9 - TLS Table
 	// 10 - Load Config Table
 	// 11 - Bound Import Table
+	BoundImps []BoundImportDirectory
 	// 12 - Import Address Table
 	// 13 - Delay Import Descriptor
+	DelayImps []DelayImportEntry
 	// 14 - CLR Header
 	// 15 - Reserved
+
+	// Warnings recorded while parsing malformed structures. Unsupported data
+	// directories are skipped silently.
+	Warnings []Anomaly
+
+	// Reader of file contents, used when Content is not loaded into memory.
+	r io.ReaderAt
+	// Size of file contents in bytes, when read from r.
+	size int64
+	// Specifies whether the file contents use the mapped layout of in-memory
+	// images, rather than the on-disk layout.
+	mapped bool
+	// Bitset of data directories with parsed contents, indexed by data
+	// directory index.
+	parsedDirs uint16
+	// Sources of the raw contents of sections when writing, indexed by section
+	// header index.
+	sectSrcs []sectionSource
+	// Section headers of removed sections, the raw data of which is cleared
+	// when writing.
+	removedSectHdrs []SectionHeader
+	// Number of section headers in the section table, as parsed or added;
+	// unused section headers are cleared when writing.
+	sectTableLen int
+	// Ranges of the original file contents cleared when writing (e.g. stripped
+	// debug data).
+	clearedRanges []contentRange
+	// Ranges of the original overlay removed when writing, sorted by offset
+	// (e.g. a stripped certificate table).
+	overlayCuts []contentRange
+	// Specifies whether to recompute the checksum when writing.
+	fixChecksum bool
+	// Memory regions of the in-memory image, cached by imageRegions; nil if
+	// not yet computed or invalidated by a change to the layout of sections.
+	regions []imageRegion
+	// File offset of the optional header, as parsed.
+	optHdrOff int64
+	// Number of warnings recorded while parsing headers; followed by the
+	// warnings of data directories parsed.
+	nhdrWarnings int
+	// End offset of the headers and section contents as parsed, as specified
+	// by the headers; the overlay, if present, starts at this offset.
+	origEnd int64
 }
 
 // ReadData reads the data with the specified address and length from the
 // section containing the memory range. It panics if no such section is located.
 func (file *File) ReadData(addr uint64, n int64) []byte {
+	buf, err := file.readData(addr, n)
+	if err != nil {
+		panic(err)
+	}
+	return buf
+}
+
+// readData reads the data with the specified address and length from the
+// section containing the memory range.
+func (file *File) readData(addr uint64, n int64) ([]byte, error) {
 	for _, sectHdr := range file.SectHdrs {
 		sectStartAddr := file.OptHdr.ImageBase + uint64(sectHdr.RelAddr)
-		sectEndAddr := sectStartAddr + uint64(sectHdr.DataSize)
-		if !(sectStartAddr <= addr && addr+uint64(n) < sectEndAddr) {
+		sectEndAddr := sectStartAddr + uint64(file.sectionSize(sectHdr))
+		if !(sectStartAddr <= addr && addr+uint64(n) <= sectEndAddr) {
 			continue
 		}
 		offset := addr - sectStartAddr
-		start := uint64(sectHdr.DataOffset) + offset
+		start := uint64(file.sectionOffset(sectHdr)) + offset
 		end := start + uint64(n)
-		return file.Content[start:end]
+		if end > uint64(file.Size()) {
+			break
+		}
+		return file.fileRange(uint32(start), uint32(n)), nil
 	}
-	panic(fmt.Errorf("unable to locate data at address 0x%08X (%d bytes)", addr, n))
+	return nil, errors.Errorf("unable to locate data at address 0x%08X (%d bytes)", addr, n)
 }
 
 // FileHeader is a COFF file header.
@@ -0,0 +1,456 @@
+package fingerprint
+
+// bzip2Size returns the size in bytes of the given data compressed using
+// bzip2 with a block size of 900k, as produced by libbzip2 (e.g. the bz2
+// module of Python).
+//
+// Only the size of the compressed stream is computed; the encoding choices
+// (run-length encoding, Burrows-Wheeler transform, move-to-front coding and
+// selection of Huffman coding tables) mirror those of libbzip2, so that the
+// size matches exactly.
+func bzip2Size(data []byte) int {
+	const (
+		// Maximum block size after the initial run-length encoding.
+		nblockMax = 100000*9 - 19
+	)
+	// Stream header ("BZh9").
+	nbits := 32
+	b := &bzip2Block{}
+	// Pending run of the initial run-length encoding.
+	runChar, runLen := -1, 0
+	for i := 0; i < len(data); {
+		for ; i < len(data) && len(b.data) < nblockMax; i++ {
+			c := int(data[i])
+			if c != runChar || runLen == 255 {
+				if runChar != -1 {
+					b.addRun(byte(runChar), runLen)
+				}
+				runChar, runLen = c, 1
+			} else {
+				runLen++
+			}
+		}
+		if len(b.data) >= nblockMax {
+			nbits += b.size()
+			b = &bzip2Block{}
+		}
+	}
+	if runChar != -1 {
+		b.addRun(byte(runChar), runLen)
+	}
+	if len(b.data) > 0 {
+		nbits += b.size()
+	}
+	// Stream trailer (end-of-stream magic and combined CRC).
+	nbits += 48 + 32
+	return (nbits + 7) / 8
+}
+
+// bzip2Block is a block of bzip2 compressed data.
+type bzip2Block struct {
+	// Block contents after the initial run-length encoding.
+	data []byte
+	// Bytes used in the block.
+	inUse [256]bool
+}
+
+// addRun adds a run of the given byte to the block, using the initial
+// run-length encoding of bzip2; runs of 4 to 255 bytes are stored as four
+// bytes followed by the remaining run length.
+func (b *bzip2Block) addRun(c byte, n int) {
+	b.inUse[c] = true
+	if n < 4 {
+		for i := 0; i < n; i++ {
+			b.data = append(b.data, c)
+		}
+		return
+	}
+	b.inUse[n-4] = true
+	b.data = append(b.data, c, c, c, c, byte(n-4))
+}
+
+// size returns the size in bits of the compressed block.
+func (b *bzip2Block) size() int {
+	const (
+		// Number of symbols per selector group.
+		groupSize = 50
+		// Number of iterations to improve coding tables.
+		nIters = 4
+		// Maximum Huffman code length.
+		maxLen = 17
+		// Code length costs of the initial coding tables.
+		lesserCost  = 0
+		greaterCost = 15
+	)
+	// Block header: magic (48 bits), CRC (32 bits), randomised (1 bit) and
+	// origin pointer (24 bits).
+	nbits := 48 + 32 + 1 + 24
+	// Symbol mapping table.
+	nbits += 16
+	for i := 0; i < 16; i++ {
+		for j := 0; j < 16; j++ {
+			if b.inUse[i*16+j] {
+				nbits += 16
+				break
+			}
+		}
+	}
+	mtfv, mtfFreq, alphaSize := b.mtfValues()
+	nMTF := len(mtfv)
+	var nGroups int
+	switch {
+	case nMTF < 200:
+		nGroups = 2
+	case nMTF < 600:
+		nGroups = 3
+	case nMTF < 1200:
+		nGroups = 4
+	case nMTF < 2400:
+		nGroups = 5
+	default:
+		nGroups = 6
+	}
+	// Generate initial coding tables, partitioning the symbols by frequency.
+	lens := make([][]int, nGroups)
+	for t := range lens {
+		lens[t] = make([]int, alphaSize)
+	}
+	remFreq := nMTF
+	gs := 0
+	for nPart := nGroups; nPart > 0; nPart-- {
+		targetFreq := remFreq / nPart
+		ge := gs - 1
+		freq := 0
+		for freq < targetFreq && ge < alphaSize-1 {
+			ge++
+			freq += mtfFreq[ge]
+		}
+		if ge > gs && nPart != nGroups && nPart != 1 && (nGroups-nPart)%2 == 1 {
+			freq -= mtfFreq[ge]
+			ge--
+		}
+		for v := 0; v < alphaSize; v++ {
+			if v >= gs && v <= ge {
+				lens[nPart-1][v] = lesserCost
+			} else {
+				lens[nPart-1][v] = greaterCost
+			}
+		}
+		gs = ge + 1
+		remFreq -= freq
+	}
+	// Iteratively improve the coding tables.
+	var selectors []int
+	for iter := 0; iter < nIters; iter++ {
+		rfreq := make([][]int, nGroups)
+		for t := range rfreq {
+			rfreq[t] = make([]int, alphaSize)
+		}
+		selectors = selectors[:0]
+		for gs := 0; gs < nMTF; gs += groupSize {
+			ge := gs + groupSize
+			if ge > nMTF {
+				ge = nMTF
+			}
+			// Select the coding table with the lowest cost for the group.
+			bestCost, best := -1, -1
+			for t := 0; t < nGroups; t++ {
+				cost := 0
+				for _, v := range mtfv[gs:ge] {
+					cost += lens[t][v]
+				}
+				if best == -1 || cost < bestCost {
+					bestCost, best = cost, t
+				}
+			}
+			selectors = append(selectors, best)
+			for _, v := range mtfv[gs:ge] {
+				rfreq[best][v]++
+			}
+		}
+		for t := 0; t < nGroups; t++ {
+			lens[t] = huffmanCodeLengths(rfreq[t], maxLen)
+		}
+	}
+	// Number of coding tables (3 bits) and selectors (15 bits).
+	nbits += 3 + 15
+	// Selectors, move-to-front and unary encoded.
+	var pos [6]int
+	for i := range pos {
+		pos[i] = i
+	}
+	for _, sel := range selectors {
+		j := 0
+		for pos[j] != sel {
+			j++
+		}
+		copy(pos[1:j+1], pos[:j])
+		pos[0] = sel
+		nbits += j + 1
+	}
+	// Coding tables, delta encoded.
+	for t := 0; t < nGroups; t++ {
+		cur := lens[t][0]
+		nbits += 5
+		for _, l := range lens[t] {
+			if l > cur {
+				nbits += 2 * (l - cur)
+			} else {
+				nbits += 2 * (cur - l)
+			}
+			cur = l
+			nbits++
+		}
+	}
+	// Block data.
+	for i, sel := range selectors {
+		gs := i * groupSize
+		ge := gs + groupSize
+		if ge > nMTF {
+			ge = nMTF
+		}
+		for _, v := range mtfv[gs:ge] {
+			nbits += lens[sel][v]
+		}
+	}
+	return nbits
+}
+
+// mtfValues returns the move-to-front encoded symbols of the Burrows-Wheeler
+// transform of the block, with runs of zeros encoded using the RUNA and RUNB
+// symbols and terminated by an end-of-block symbol. The symbol frequencies and
+// the alphabet size are also returned.
+func (b *bzip2Block) mtfValues() (mtfv []int, mtfFreq []int, alphaSize int) {
+	const (
+		runA = 0
+		runB = 1
+	)
+	var seq [256]byte
+	nInUse := 0
+	for c, used := range b.inUse {
+		if used {
+			seq[c] = byte(nInUse)
+			nInUse++
+		}
+	}
+	alphaSize = nInUse + 2
+	eob := nInUse + 1
+	mtfFreq = make([]int, alphaSize)
+	yy := make([]byte, nInUse)
+	for i := range yy {
+		yy[i] = byte(i)
+	}
+	zPend := 0
+	flushZeros := func() {
+		if zPend == 0 {
+			return
+		}
+		zPend--
+		for {
+			if zPend&1 != 0 {
+				mtfv = append(mtfv, runB)
+				mtfFreq[runB]++
+			} else {
+				mtfv = append(mtfv, runA)
+				mtfFreq[runA]++
+			}
+			if zPend < 2 {
+				break
+			}
+			zPend = (zPend - 2) / 2
+		}
+		zPend = 0
+	}
+	n := len(b.data)
+	for _, p := range sortRotations(b.data) {
+		j := p - 1
+		if j < 0 {
+			j += n
+		}
+		c := seq[b.data[j]]
+		if yy[0] == c {
+			zPend++
+			continue
+		}
+		flushZeros()
+		j = 1
+		for yy[j] != c {
+			j++
+		}
+		copy(yy[1:j+1], yy[:j])
+		yy[0] = c
+		mtfv = append(mtfv, j+1)
+		mtfFreq[j+1]++
+	}
+	flushZeros()
+	mtfv = append(mtfv, eob)
+	mtfFreq[eob]++
+	return mtfv, mtfFreq, alphaSize
+}
+
+// sortRotations returns the start offsets of the cyclic rotations of the given
+// data, in sorted order.
+func sortRotations(data []byte) []int {
+	n := len(data)
+	p := make([]int, n)
+	class := make([]int, n)
+	count := make([]int, 256)
+	// Sort by first byte.
+	for _, c := range data {
+		count[c]++
+	}
+	for i := 1; i < 256; i++ {
+		count[i] += count[i-1]
+	}
+	for i := n - 1; i >= 0; i-- {
+		count[data[i]]--
+		p[count[data[i]]] = i
+	}
+	nClasses := 1
+	for i := 1; i < n; i++ {
+		if data[p[i]] != data[p[i-1]] {
+			nClasses++
+		}
+		class[p[i]] = nClasses - 1
+	}
+	// Sort by prefixes of doubling length.
+	pn := make([]int, n)
+	cn := make([]int, n)
+	for h := 1; h < n && nClasses < n; h <<= 1 {
+		for i := range p {
+			pn[i] = p[i] - h
+			if pn[i] < 0 {
+				pn[i] += n
+			}
+		}
+		count = count[:0]
+		for i := 0; i < nClasses; i++ {
+			count = append(count, 0)
+		}
+		for _, q := range pn {
+			count[class[q]]++
+		}
+		for i := 1; i < nClasses; i++ {
+			count[i] += count[i-1]
+		}
+		for i := n - 1; i >= 0; i-- {
+			count[class[pn[i]]]--
+			p[count[class[pn[i]]]] = pn[i]
+		}
+		cn[p[0]] = 0
+		nClasses = 1
+		for i := 1; i < n; i++ {
+			cur, prev := p[i], p[i-1]
+			curNext, prevNext := (cur+h)%n, (prev+h)%n
+			if class[cur] != class[prev] || class[curNext] != class[prevNext] {
+				nClasses++
+			}
+			cn[cur] = nClasses - 1
+		}
+		class, cn = cn, class
+	}
+	return p
+}
+
+// huffmanCodeLengths returns the Huffman code lengths of symbols with the
+// given frequencies, limited to maxLen bits, as computed by libbzip2.
+func huffmanCodeLengths(freq []int, maxLen int) []int {
+	alphaSize := len(freq)
+	lens := make([]int, alphaSize)
+	heap := make([]int, alphaSize+2)
+	weight := make([]int, alphaSize*2)
+	parent := make([]int, alphaSize*2)
+	for i, f := range freq {
+		if f == 0 {
+			f = 1
+		}
+		weight[i+1] = f << 8
+	}
+	// The 8 least significant bits of weights record the depth of the
+	// subtree, to favour shallow trees among equal weights.
+	addWeights := func(w1, w2 int) int {
+		d1, d2 := w1&0xFF, w2&0xFF
+		d := d1
+		if d2 > d {
+			d = d2
+		}
+		return ((w1 &^ 0xFF) + (w2 &^ 0xFF)) | (1 + d)
+	}
+	for {
+		nNodes := alphaSize
+		nHeap := 0
+		heap[0] = 0
+		weight[0] = 0
+		parent[0] = -2
+		upHeap := func(z int) {
+			tmp := heap[z]
+			for weight[tmp] < weight[heap[z>>1]] {
+				heap[z] = heap[z>>1]
+				z >>= 1
+			}
+			heap[z] = tmp
+		}
+		downHeap := func(z int) {
+			tmp := heap[z]
+			for {
+				y := z << 1
+				if y > nHeap {
+					break
+				}
+				if y < nHeap && weight[heap[y+1]] < weight[heap[y]] {
+					y++
+				}
+				if weight[tmp] < weight[heap[y]] {
+					break
+				}
+				heap[z] = heap[y]
+				z = y
+			}
+			heap[z] = tmp
+		}
+		for i := 1; i <= alphaSize; i++ {
+			parent[i] = -1
+			nHeap++
+			heap[nHeap] = i
+			upHeap(nHeap)
+		}
+		for nHeap > 1 {
+			n1 := heap[1]
+			heap[1] = heap[nHeap]
+			nHeap--
+			downHeap(1)
+			n2 := heap[1]
+			heap[1] = heap[nHeap]
+			nHeap--
+			downHeap(1)
+			nNodes++
+			parent[n1] = nNodes
+			parent[n2] = nNodes
+			weight[nNodes] = addWeights(weight[n1], weight[n2])
+			parent[nNodes] = -1
+			nHeap++
+			heap[nHeap] = nNodes
+			upHeap(nHeap)
+		}
+		tooLong := false
+		for i := 1; i <= alphaSize; i++ {
+			j := 0
+			for k := i; parent[k] >= 0; k = parent[k] {
+				j++
+			}
+			lens[i-1] = j
+			if j > maxLen {
+				tooLong = true
+			}
+		}
+		if !tooLong {
+			return lens
+		}
+		// Flatten the frequency distribution and retry.
+		for i := 1; i <= alphaSize; i++ {
+			j := weight[i] >> 8
+			j = 1 + j/2
+			weight[i] = j << 8
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package fingerprint
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestBzip2Size(t *testing.T) {
+	// The expected sizes were computed using the bz2 module of Python (i.e.
+	// len(bz2.compress(data, 9))).
+	golden := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{name: "empty", data: []byte{}, want: 14},
+		{name: "single", data: []byte("a"), want: 37},
+		{name: "abracadabra", data: []byte("abracadabra"), want: 45},
+		{name: "run-4", data: bytes.Repeat([]byte("a"), 4), want: 39},
+		{name: "run-255", data: bytes.Repeat([]byte("a"), 255), want: 39},
+		{name: "run-256", data: bytes.Repeat([]byte("a"), 256), want: 39},
+		{name: "run-1000", data: bytes.Repeat([]byte("a"), 1000), want: 45},
+		{name: "zeros-64k", data: make([]byte, 65536), want: 43},
+		{name: "random-10k", data: lcg(10000, 1, nil), want: 10487},
+		{name: "text-100k", data: lcg(100000, 2, []byte("etaoin shrdlu")), want: 47667},
+		{name: "runs-45k", data: runs(), want: 752},
+		// Spanning two blocks.
+		{name: "text-1m", data: lcg(1000000, 3, []byte("abcdefghijklmnop")), want: 498348},
+		{name: "gcc-386-mingw-exec", data: readFile(t, "../testdata/gcc-386-mingw-exec"), want: 8693},
+		{name: "gcc-386-mingw-no-symbols-exec", data: readFile(t, "../testdata/gcc-386-mingw-no-symbols-exec"), want: 3634},
+	}
+	for _, g := range golden {
+		got := bzip2Size(g.data)
+		if got != g.want {
+			t.Errorf("%s: compressed size mismatch; expected %d, got %d", g.name, g.want, got)
+		}
+	}
+}
+
+// lcg returns n pseudo-random bytes generated by a linear congruential
+// generator with the given seed; mapped to the given alphabet if non-nil.
+func lcg(n int, seed uint32, alphabet []byte) []byte {
+	buf := make([]byte, n)
+	x := seed
+	for i := range buf {
+		x = (x*1103515245 + 12345) & 0x7FFFFFFF
+		b := byte(x >> 16)
+		if alphabet != nil {
+			b = alphabet[int(b)%len(alphabet)]
+		}
+		buf[i] = b
+	}
+	return buf
+}
+
+// runs returns runs of 1 to 300 bytes, exercising the initial run-length
+// encoding of bzip2.
+func runs() []byte {
+	var buf []byte
+	for i := 0; i < 300; i++ {
+		buf = append(buf, bytes.Repeat([]byte{byte(i % 7)}, i%300+1)...)
+	}
+	return buf
+}
+
+// readFile returns the contents of the given file.
+func readFile(t *testing.T, path string) []byte {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unable to read file %q; %v", path, err)
+	}
+	return buf
+}
@@ -0,0 +1,181 @@
+package fingerprint
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"math"
+
+	"github.com/mewmew/pe"
+	"github.com/pkg/errors"
+)
+
+// PEHash returns the PEhash of the given PE file, as computed by totalhash.
+//
+// PEhash is the SHA-1 hash of a bit-packed summary of structural properties of
+// the PE file, which remain stable across related samples:
+//
+//   - image characteristics (16 bits folded to 8 bits)
+//   - machine type (16 bits folded to 8 bits)
+//   - stack commit size (24 bits folded to 8 bits)
+//   - heap commit size (24 bits folded to 8 bits)
+//
+// And for each section:
+//
+//   - relative address (bits 8-31)
+//   - size of data on disk (24 bits)
+//   - section flags (16 least significant bits folded to 8 bits)
+//   - Kolmogorov complexity, approximated by the bzip2 compression ratio of
+//     the file contents (8 most significant bits as a 32-bit float)
+//
+// The reference implementation of totalhash packs the hexadecimal digits of
+// each value, padded with zero bits to a byte boundary; and approximates the
+// Kolmogorov complexity of a section using the file contents following the
+// file offset given by the sum of its relative address and size of data on
+// disk. These quirks are reproduced to match the published hashes.
+//
+// ref: https://www.usenix.org/legacy/event/leet09/tech/full_papers/wicherski/wicherski_html/
+func PEHash(file *pe.File) (string, error) {
+	if file.FileHdr == nil || file.OptHdr == nil {
+		return "", errors.New("unable to compute PEhash; missing file header or optional header")
+	}
+	w := &bitWriter{}
+	// Image characteristics.
+	chars := hexBits(uint64(file.FileHdr.Characteristics)).pad()
+	if chars.n == 8 {
+		w.append(chars)
+	} else {
+		w.append(chars.slice(0, 8).xor(chars.slice(8, 16)))
+	}
+	// Machine type.
+	machine := hexBits(uint64(file.FileHdr.Machine)).pad()
+	x := machine.slice(0, 8).xor(machine.slice(8, 16))
+	if x.n != 8 {
+		return "", errors.Errorf("unable to compute PEhash; unsupported machine type 0x%X", uint16(file.FileHdr.Machine))
+	}
+	w.append(x)
+	// Stack and heap commit size.
+	for _, size := range []uint64{file.OptHdr.InitialStackSize, file.OptHdr.InitialHeapSize} {
+		b := hexBits(size).zfill(32)
+		w.append(b.slice(8, 16).xor(b.slice(16, 24)).xor(b.slice(24, 32)))
+	}
+	// Sections.
+	for _, sectHdr := range file.SectHdrs {
+		w.append(hexBits(uint64(sectHdr.RelAddr)).pad().slice(8, 32))
+		w.append(hexBits(uint64(sectHdr.DataSize)).pad().zfill(32).slice(8, 32))
+		flags := hexBits(uint64(sectHdr.Flags)).pad()
+		lo, hi := flags.slice(16, 24), flags.slice(24, 32)
+		if lo.n != hi.n {
+			return "", errors.Errorf("unable to compute PEhash; unsupported flags 0x%08X of section %q", uint32(sectHdr.Flags), sectHdr.Name)
+		}
+		w.append(lo.xor(hi))
+		k, err := kolmogorov(file, sectHdr)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		w.append(bits{v: uint64(math.Float32bits(k) >> 24), n: 8})
+	}
+	sum := sha1.Sum(w.buf)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// kolmogorov returns the approximate Kolmogorov complexity of the given
+// section, as the bzip2 compression ratio of the file contents following the
+// file offset given by the sum of its relative address and size of data on
+// disk (as computed by totalhash).
+func kolmogorov(file *pe.File, sectHdr pe.SectionHeader) (float32, error) {
+	if sectHdr.DataSize == 0 {
+		return 1, nil
+	}
+	var data []byte
+	offset := int64(sectHdr.RelAddr) + int64(sectHdr.DataSize)
+	if offset < file.Size() {
+		data = make([]byte, file.Size()-offset)
+		if _, err := file.ReadAt(data, offset); err != nil && err != io.EOF {
+			return 0, errors.WithStack(err)
+		}
+	}
+	compressedSize := bzip2Size(data)
+	return float32(float64(compressedSize) / float64(sectHdr.DataSize)), nil
+}
+
+// ### [ Helper functions ] ####################################################
+
+// bits is a sequence of at most 64 bits.
+type bits struct {
+	// Bits of the sequence, with the last bit in the least significant bit.
+	v uint64
+	// Number of bits.
+	n int
+}
+
+// hexBits returns the bits of the hexadecimal digits of x, with leading zero
+// digits omitted.
+func hexBits(x uint64) bits {
+	n := 4
+	for x>>uint(n) != 0 {
+		n += 4
+	}
+	return bits{v: x, n: n}
+}
+
+// pad pads the bit sequence with zero bits to a byte boundary.
+func (b bits) pad() bits {
+	if b.n%8 == 0 {
+		return b
+	}
+	m := 8 - b.n%8
+	return bits{v: b.v << uint(m), n: b.n + m}
+}
+
+// zfill pads the bit sequence with leading zero bits to n bits.
+func (b bits) zfill(n int) bits {
+	if b.n < n {
+		b.n = n
+	}
+	return b
+}
+
+// slice returns the bits [start, end) of the bit sequence, truncated at the
+// end of the sequence.
+func (b bits) slice(start, end int) bits {
+	if end > b.n {
+		end = b.n
+	}
+	if start >= end {
+		return bits{}
+	}
+	v := b.v >> uint(b.n-end)
+	v &= 1<<uint(end-start) - 1
+	return bits{v: v, n: end - start}
+}
+
+// xor returns the exclusive or of the bit sequences, which are of equal length
+// or empty.
+func (b bits) xor(c bits) bits {
+	if b.n != c.n {
+		return bits{}
+	}
+	return bits{v: b.v ^ c.v, n: b.n}
+}
+
+// bitWriter packs bit sequences into bytes, in big-endian bit order.
+type bitWriter struct {
+	// Packed bytes; the last byte is padded with zero bits.
+	buf []byte
+	// Number of bits packed.
+	n int
+}
+
+// append appends the given bit sequence.
+func (w *bitWriter) append(b bits) {
+	for i := b.n - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		if b.v>>uint(i)&1 != 0 {
+			w.buf[len(w.buf)-1] |= 0x80 >> uint(w.n%8)
+		}
+		w.n++
+	}
+}
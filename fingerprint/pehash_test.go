@@ -0,0 +1,56 @@
+package fingerprint
+
+import (
+	"testing"
+
+	"github.com/mewmew/pe"
+)
+
+func TestPEHash(t *testing.T) {
+	// The expected hashes were computed using the totalhash function of the
+	// reference Python implementation of PEhash.
+	golden := []struct {
+		path string
+		want string
+	}{
+		{path: "../testdata/gcc-386-mingw-exec", want: "eaab1345de8b064741612ee1d3dfc22e38c24f63"},
+		{path: "../testdata/gcc-386-mingw-no-symbols-exec", want: "d4f0bd16cf9bc1d8934f3afc7aedd5f46c5514ed"},
+	}
+	for _, g := range golden {
+		file, err := pe.ParseFile(g.path)
+		if err != nil {
+			t.Errorf("%q: unable to parse file; %+v", g.path, err)
+			continue
+		}
+		got, err := PEHash(file)
+		if err != nil {
+			t.Errorf("%q: unable to compute PEhash; %+v", g.path, err)
+			continue
+		}
+		if got != g.want {
+			t.Errorf("%q: PEhash mismatch; expected %q, got %q", g.path, g.want, got)
+		}
+	}
+}
+
+func TestHexBits(t *testing.T) {
+	golden := []struct {
+		x uint64
+		// Bits of the hexadecimal digits of x, padded to a byte boundary.
+		want bits
+	}{
+		{x: 0x0, want: bits{v: 0x00, n: 8}},
+		{x: 0x7, want: bits{v: 0x70, n: 8}},
+		{x: 0x14C, want: bits{v: 0x14C0, n: 16}},
+		{x: 0x8664, want: bits{v: 0x8664, n: 16}},
+		{x: 0x1000, want: bits{v: 0x1000, n: 16}},
+		{x: 0x11000, want: bits{v: 0x110000, n: 24}},
+		{x: 0x60000020, want: bits{v: 0x60000020, n: 32}},
+	}
+	for _, g := range golden {
+		got := hexBits(g.x).pad()
+		if got != g.want {
+			t.Errorf("bits mismatch of 0x%X; expected %+v, got %+v", g.x, g.want, got)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Package fingerprint computes structural fingerprints of PE files, used to
+// cluster related samples.
+package fingerprint
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+
+	"github.com/mewmew/pe"
+)
+
+// RichHeader is the Rich header of a PE file, which records the tools used to
+// build the executable. It is stored XOR encrypted between the MS-DOS stub and
+// the PE header, and is present in executables linked by the Microsoft linker.
+//
+// ref: https://www.ntcore.com/files/richsign.htm
+type RichHeader struct {
+	// File offset of the "DanS" signature.
+	Offset int
+	// XOR key, stored after the "Rich" signature.
+	Key uint32
+	// Decrypted Rich header, from the "DanS" signature up to (but not
+	// including) the "Rich" signature.
+	ClearData []byte
+	// Tool entries.
+	Entries []RichEntry
+}
+
+// RichEntry is a tool entry of the Rich header.
+type RichEntry struct {
+	// Product ID of the tool.
+	ProdID uint16
+	// Build number of the tool.
+	Build uint16
+	// Number of objects produced by the tool.
+	Count uint32
+}
+
+// Signatures of the Rich header.
+const (
+	// "DanS" as little-endian 32-bit value.
+	dansSig = 0x536E6144
+	// "Rich" as little-endian 32-bit value.
+	richSig = 0x68636952
+)
+
+// ParseRichHeader parses the Rich header of the given PE file. The boolean
+// return value indicates whether a valid Rich header was located.
+func ParseRichHeader(file *pe.File) (*RichHeader, bool) {
+	if file.Size() < 0x40 {
+		return nil, false
+	}
+	// The Rich header is located before the PE header.
+	buf := make([]byte, 4)
+	if _, err := file.ReadAt(buf, 0x3C); err != nil {
+		return nil, false
+	}
+	end := int64(binary.LittleEndian.Uint32(buf))
+	if end > file.Size() {
+		end = file.Size()
+	}
+	// Read up to and including the XOR key following the "Rich" signature.
+	content := make([]byte, end+4)
+	n, _ := file.ReadAt(content, 0)
+	content = content[:n]
+	if int64(n) < end {
+		end = int64(n)
+	}
+	richOffset := bytes.Index(content[:end], []byte("Rich"))
+	if richOffset == -1 || richOffset+8 > len(content) {
+		return nil, false
+	}
+	key := binary.LittleEndian.Uint32(content[richOffset+4:])
+	// Decrypt backwards from the "Rich" signature until the "DanS" signature.
+	dansOffset := -1
+	for offset := richOffset - 4; offset >= 0x40; offset -= 4 {
+		if binary.LittleEndian.Uint32(content[offset:])^key == dansSig {
+			dansOffset = offset
+			break
+		}
+	}
+	if dansOffset == -1 {
+		return nil, false
+	}
+	clearData := make([]byte, richOffset-dansOffset)
+	for i := 0; i < len(clearData); i += 4 {
+		x := binary.LittleEndian.Uint32(content[dansOffset+i:]) ^ key
+		binary.LittleEndian.PutUint32(clearData[i:], x)
+	}
+	rich := &RichHeader{
+		Offset:    dansOffset,
+		Key:       key,
+		ClearData: clearData,
+	}
+	// Tool entries follow the "DanS" signature and three padding values.
+	for i := 16; i+8 <= len(clearData); i += 8 {
+		compID := binary.LittleEndian.Uint32(clearData[i:])
+		entry := RichEntry{
+			ProdID: uint16(compID >> 16),
+			Build:  uint16(compID),
+			Count:  binary.LittleEndian.Uint32(clearData[i+4:]),
+		}
+		rich.Entries = append(rich.Entries, entry)
+	}
+	return rich, true
+}
+
+// RichPEHash returns the Rich header hash of the given PE file, as computed by
+// pefile; or an empty string if the PE file has no Rich header.
+//
+// The Rich header hash is the MD5 hash of the decrypted Rich header, from the
+// "DanS" signature up to the "Rich" signature.
+func RichPEHash(file *pe.File) string {
+	rich, ok := ParseRichHeader(file)
+	if !ok {
+		return ""
+	}
+	sum := md5.Sum(rich.ClearData)
+	return hex.EncodeToString(sum[:])
+}
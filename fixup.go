@@ -0,0 +1,248 @@
+package pe
+
+import (
+	"encoding/binary"
+
+	"github.com/mewmew/pe/enum"
+	"github.com/pkg/errors"
+)
+
+// --- [ Fixups ] --------------------------------------------------------------
+
+// Fixup is a base relocation resolved against the in-memory image of a PE file.
+type Fixup struct {
+	// Base relocation type.
+	Type enum.BaseRelocType
+	// Relative address of the relocated location (relative to image base);
+	// computed by adding the offset of the base relocation entry to the page
+	// relative address of its block.
+	RelAddr uint32
+	// Current value stored at the relocated location. The value is 16, 32 or 64
+	// bits wide, depending on the base relocation type.
+	//
+	// For machine specific base relocation types, the value is decoded from the
+	// immediates of the relocated instructions; the 32-bit value of MOVW/MOVT
+	// instruction pairs (ARM and Thumb MOV32), the high 20 bits of RISC-V
+	// HIGH20 base relocations, and the 32-bit value of RISC-V LOW12I and LOW12S
+	// base relocations combined with the preceding HIGH20 base relocation. The
+	// raw instruction is stored for other machine specific base relocation
+	// types (e.g. MIPS JMPADDR).
+	Value uint64
+	// Section targeted by the pointer stored at the relocated location; or nil
+	// if the pointer does not target a section, or the base relocation type
+	// does not relocate a full pointer (e.g. HIGH, LOW, RISC-V HIGH20).
+	Target *SectionHeader
+}
+
+// FixupIterator iterates over the base relocations of a PE file, resolving
+// each base relocation into a fixup. Padding (ABSOLUTE base relocations) is
+// skipped.
+//
+// Example usage:
+//
+//	it := file.Fixups()
+//	for it.Next() {
+//		fixup := it.Fixup()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type FixupIterator struct {
+	// PE file.
+	file *File
+	// Index of current base relocation block.
+	blockIdx int
+	// Index of next base relocation entry within the current block.
+	entryIdx int
+	// Current fixup.
+	cur Fixup
+	// Instruction of pending RISC-V HIGH20 base relocation within the current
+	// block, combined with the following LOW12I or LOW12S base relocation;
+	// valid if hasHigh20 is set.
+	high20    uint32
+	hasHigh20 bool
+	// First error encountered.
+	err error
+}
+
+// Fixups returns an iterator over the base relocations of the PE file.
+func (file *File) Fixups() *FixupIterator {
+	return &FixupIterator{file: file}
+}
+
+// Next advances the iterator to the next fixup, which is then available
+// through the Fixup method. It returns false when the iteration stops, either
+// by reaching the end of the base relocations or on error.
+func (it *FixupIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	blocks, err := it.file.BaseRelocs()
+	if err != nil {
+		it.err = errors.WithStack(err)
+		return false
+	}
+	for it.blockIdx < len(blocks) {
+		block := blocks[it.blockIdx]
+		if it.entryIdx >= len(block.Entries) {
+			it.blockIdx++
+			it.entryIdx = 0
+			it.hasHigh20 = false
+			continue
+		}
+		entry := block.Entries[it.entryIdx]
+		it.entryIdx++
+		switch entry.Type {
+		case enum.BaseRelocTypeAbsolute:
+			// Padding; skip.
+			continue
+		case enum.BaseRelocTypeHighAdj:
+			// Skip the slot of the following entry, which holds the low 16 bits
+			// of the 32-bit value.
+			it.entryIdx++
+		}
+		relAddr := block.PageRelAddr + uint32(entry.Offset)
+		fixup, err := it.resolveFixup(entry.Type, relAddr)
+		if err != nil {
+			it.err = errors.WithStack(err)
+			return false
+		}
+		it.cur = fixup
+		return true
+	}
+	return false
+}
+
+// Fixup returns the current fixup of the iterator.
+func (it *FixupIterator) Fixup() Fixup {
+	return it.cur
+}
+
+// Err returns the first error encountered by the iterator.
+func (it *FixupIterator) Err() error {
+	return it.err
+}
+
+// resolveFixup resolves the base relocation of the given type, located at the
+// specified relative address.
+func (it *FixupIterator) resolveFixup(typ enum.BaseRelocType, relAddr uint32) (Fixup, error) {
+	file := it.file
+	fixup := Fixup{
+		Type:    typ,
+		RelAddr: relAddr,
+	}
+	switch typ {
+	case enum.BaseRelocTypeHigh, enum.BaseRelocTypeLow, enum.BaseRelocTypeHighAdj:
+		buf, err := file.ReadImage(relAddr, 2)
+		if err != nil {
+			return Fixup{}, errors.WithStack(err)
+		}
+		fixup.Value = uint64(binary.LittleEndian.Uint16(buf))
+	case enum.BaseRelocTypeHighLow:
+		buf, err := file.ReadImage(relAddr, 4)
+		if err != nil {
+			return Fixup{}, errors.WithStack(err)
+		}
+		fixup.Value = uint64(binary.LittleEndian.Uint32(buf))
+		fixup.Target = file.targetSection(fixup.Value)
+	case enum.BaseRelocTypeDir64:
+		buf, err := file.ReadImage(relAddr, 8)
+		if err != nil {
+			return Fixup{}, errors.WithStack(err)
+		}
+		fixup.Value = binary.LittleEndian.Uint64(buf)
+		fixup.Target = file.targetSection(fixup.Value)
+	default:
+		if err := it.resolveMachineSpecific(&fixup); err != nil {
+			return Fixup{}, errors.WithStack(err)
+		}
+	}
+	return fixup, nil
+}
+
+// resolveMachineSpecific resolves the machine specific base relocation of the
+// given fixup, based on the machine type of the PE file.
+func (it *FixupIterator) resolveMachineSpecific(fixup *Fixup) error {
+	file := it.file
+	machine := file.FileHdr.Machine
+	typ, relAddr := fixup.Type, fixup.RelAddr
+	switch {
+	case typ == enum.BaseRelocTypeARMMov32 && isARM(machine):
+		// MOVW followed by MOVT (ARM encoding).
+		buf, err := file.ReadImage(relAddr, 8)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		movw := binary.LittleEndian.Uint32(buf[0:])
+		movt := binary.LittleEndian.Uint32(buf[4:])
+		fixup.Value = uint64(armImm16(movt))<<16 | uint64(armImm16(movw))
+		fixup.Target = file.targetSection(fixup.Value)
+	case typ == enum.BaseRelocTypeThumbMov32 && isARM(machine):
+		// MOVW followed by MOVT (Thumb-2 encoding).
+		buf, err := file.ReadImage(relAddr, 8)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		movw := thumbInst(buf[0:])
+		movt := thumbInst(buf[4:])
+		fixup.Value = uint64(thumbImm16(movt))<<16 | uint64(thumbImm16(movw))
+		fixup.Target = file.targetSection(fixup.Value)
+	case typ == enum.BaseRelocTypeRISCVHigh20 && isRISCV(machine):
+		// Combined with the following LOW12I or LOW12S base relocation.
+		buf, err := file.ReadImage(relAddr, 4)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		inst := binary.LittleEndian.Uint32(buf)
+		fixup.Value = uint64(inst & 0xFFFFF000)
+		it.high20, it.hasHigh20 = inst, true
+	case (typ == enum.BaseRelocTypeRISCVLow12i || typ == enum.BaseRelocTypeRISCVLow12s) && isRISCV(machine):
+		if !it.hasHigh20 {
+			return errors.Errorf("missing HIGH20 base relocation preceding %v base relocation at relative address 0x%08X", typ, relAddr)
+		}
+		buf, err := file.ReadImage(relAddr, 4)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		inst := binary.LittleEndian.Uint32(buf)
+		var lo uint32
+		if typ == enum.BaseRelocTypeRISCVLow12i {
+			lo = riscvImmI(inst)
+		} else {
+			lo = riscvImmS(inst)
+		}
+		fixup.Value = uint64(it.high20&0xFFFFF000 + lo)
+		fixup.Target = file.targetSection(fixup.Value)
+		it.hasHigh20 = false
+	default:
+		// Store raw instruction.
+		buf, err := file.ReadImage(relAddr, 4)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		fixup.Value = uint64(binary.LittleEndian.Uint32(buf))
+	}
+	return nil
+}
+
+// targetSection returns the section containing the given address; or nil if
+// not present.
+func (file *File) targetSection(addr uint64) *SectionHeader {
+	if addr < file.OptHdr.ImageBase {
+		return nil
+	}
+	relAddr := addr - file.OptHdr.ImageBase
+	for i := range file.SectHdrs {
+		sectHdr := &file.SectHdrs[i]
+		size := sectHdr.VirtualSize
+		if size == 0 {
+			size = sectHdr.DataSize
+		}
+		start := uint64(sectHdr.RelAddr)
+		if start <= relAddr && relAddr < start+uint64(size) {
+			return sectHdr
+		}
+	}
+	return nil
+}
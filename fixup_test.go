@@ -0,0 +1,126 @@
+package pe
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mewmew/pe/enum"
+)
+
+func TestFixups(t *testing.T) {
+	// The code is located at the start of the .text section; the image base is
+	// 0x10000000.
+	type fixup struct {
+		typ     enum.BaseRelocType
+		relAddr uint32
+		value   uint64
+		// Name of target section; empty if none.
+		target string
+	}
+	golden := []struct {
+		name    string
+		machine enum.MachineType
+		code    []byte
+		entries []BaseRelocEntry
+		want    []fixup
+		// Expected error; empty if none.
+		err string
+	}{
+		{
+			// movw r0, #0x2010; movt r0, #0x1000
+			name:    "arm-mov32",
+			machine: enum.MachineTypeARM,
+			code:    []byte{0x10, 0x00, 0x02, 0xE3, 0x00, 0x00, 0x41, 0xE3},
+			entries: []BaseRelocEntry{{Type: enum.BaseRelocTypeARMMov32, Offset: 0}},
+			want: []fixup{
+				{typ: enum.BaseRelocTypeARMMov32, relAddr: 0x1000, value: 0x10002010, target: ".data"},
+			},
+		},
+		{
+			// movw r0, #0x2010; movt r0, #0x1000
+			name:    "thumb-mov32",
+			machine: enum.MachineTypeARMNT,
+			code:    []byte{0x42, 0xF2, 0x10, 0x00, 0xC1, 0xF2, 0x00, 0x00},
+			entries: []BaseRelocEntry{{Type: enum.BaseRelocTypeThumbMov32, Offset: 0}},
+			want: []fixup{
+				{typ: enum.BaseRelocTypeThumbMov32, relAddr: 0x1000, value: 0x10002010, target: ".data"},
+			},
+		},
+		{
+			// lui a0, 0x10002; addi a0, a0, -0x7F0
+			// lui a1, 0x10003; sw a2, -0x800(a1)
+			name:    "riscv-high20-low12",
+			machine: enum.MachineTypeRISCV64,
+			code: []byte{
+				0x37, 0x25, 0x00, 0x10, 0x13, 0x05, 0x05, 0x81,
+				0xB7, 0x35, 0x00, 0x10, 0x23, 0xA0, 0xC5, 0x80,
+			},
+			entries: []BaseRelocEntry{
+				{Type: enum.BaseRelocTypeRISCVHigh20, Offset: 0},
+				{Type: enum.BaseRelocTypeRISCVLow12i, Offset: 4},
+				{Type: enum.BaseRelocTypeRISCVHigh20, Offset: 8},
+				{Type: enum.BaseRelocTypeRISCVLow12s, Offset: 12},
+			},
+			want: []fixup{
+				{typ: enum.BaseRelocTypeRISCVHigh20, relAddr: 0x1000, value: 0x10002000},
+				{typ: enum.BaseRelocTypeRISCVLow12i, relAddr: 0x1004, value: 0x10001810, target: ".text"},
+				{typ: enum.BaseRelocTypeRISCVHigh20, relAddr: 0x1008, value: 0x10003000},
+				{typ: enum.BaseRelocTypeRISCVLow12s, relAddr: 0x100C, value: 0x10002800, target: ".data"},
+			},
+		},
+		{
+			// addi a0, a0, -0x7F0
+			name:    "riscv-low12i-missing-high20",
+			machine: enum.MachineTypeRISCV64,
+			code:    []byte{0x13, 0x05, 0x05, 0x81},
+			entries: []BaseRelocEntry{{Type: enum.BaseRelocTypeRISCVLow12i, Offset: 0}},
+			err:     "missing HIGH20 base relocation",
+		},
+	}
+	for _, g := range golden {
+		const imageSize = 0x3000
+		content := make([]byte, imageSize)
+		copy(content[0x1000:], g.code)
+		file := &File{
+			Content:  content,
+			FileHdr:  &FileHeader{Machine: g.machine},
+			OptHdr:   &OptHeader{ImageBase: 0x10000000, SectionAlign: 0x1000, FileAlign: 0x200, ImageSize: imageSize},
+			SectHdrs: []SectionHeader{{Name: ".text", VirtualSize: 0x1000, RelAddr: 0x1000}, {Name: ".data", VirtualSize: 0x1000, RelAddr: 0x2000}},
+			BaseRelocBlocks: []BaseRelocBlock{{
+				PageRelAddr: 0x1000,
+				Entries:     g.entries,
+			}},
+			mapped: true,
+		}
+		var got []fixup
+		it := file.Fixups()
+		for it.Next() {
+			f := it.Fixup()
+			x := fixup{typ: f.Type, relAddr: f.RelAddr, value: f.Value}
+			if f.Target != nil {
+				x.target = f.Target.Name
+			}
+			got = append(got, x)
+		}
+		err := it.Err()
+		if len(g.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), g.err) {
+				t.Errorf("%s: error mismatch; expected %q, got %v", g.name, g.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unable to resolve fixups; %+v", g.name, err)
+			continue
+		}
+		if len(got) != len(g.want) {
+			t.Errorf("%s: number of fixups mismatch; expected %d, got %d", g.name, len(g.want), len(got))
+			continue
+		}
+		for i := range got {
+			if got[i] != g.want[i] {
+				t.Errorf("%s: fixup %d mismatch; expected %+v, got %+v", g.name, i, g.want[i], got[i])
+			}
+		}
+	}
+}
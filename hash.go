@@ -0,0 +1,90 @@
+package pe
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// ImpHash returns the import hash of the PE file, as computed by pefile and
+// VirusTotal; or an empty string if the PE file has no imports.
+//
+// The import hash is the MD5 hash of the comma-separated list of imported
+// symbols, in import table order, each formatted as "dll.symbol" in lowercase.
+// The extension of DLL names is removed if ".dll", ".ocx" or ".sys". Symbols
+// imported by ordinal from ws2_32.dll, wsock32.dll and oleaut32.dll are mapped
+// to their names; other symbols imported by ordinal are named "ord<N>".
+//
+// The import table is parsed on first use; an empty string is returned if it
+// is malformed.
+//
+// ref: https://www.mandiant.com/resources/blog/tracking-malware-import-hashing
+func (file *File) ImpHash() string {
+	imps, err := file.Imports()
+	if err != nil {
+		return ""
+	}
+	var syms []string
+	for _, imp := range imps {
+		dllName := strings.ToLower(imp.ImpDir.Name)
+		libName := dllName
+		if pos := strings.LastIndex(libName, "."); pos != -1 {
+			switch libName[pos+1:] {
+			case "dll", "ocx", "sys":
+				libName = libName[:pos]
+			}
+		}
+		// Use IAT entries when the INT is not present.
+		ints := imp.INTs
+		if len(ints) == 0 {
+			ints = imp.IATs
+		}
+		for _, intEntry := range ints {
+			name := intEntry.NameEntry.Name
+			if intEntry.IsOrdinal {
+				name = ordLookup(dllName, intEntry.Ordinal)
+			}
+			if len(name) == 0 {
+				continue
+			}
+			sym := fmt.Sprintf("%s.%s", libName, strings.ToLower(name))
+			syms = append(syms, sym)
+		}
+	}
+	if len(syms) == 0 {
+		return ""
+	}
+	return md5Hex(strings.Join(syms, ","))
+}
+
+// ExpHash returns the export hash of the PE file; or an empty string if the PE
+// file has no named exports.
+//
+// The export hash is the MD5 hash of the comma-separated list of lowercase
+// names of exported symbols, in export name table order (i.e. sorted by name).
+// Symbols exported by ordinal only are not included. The export table is
+// parsed on first use; an empty string is returned if it is malformed.
+func (file *File) ExpHash() string {
+	exps, err := file.Exports()
+	if err != nil || exps == nil {
+		return ""
+	}
+	var names []string
+	for _, name := range exps.Names {
+		if len(name.Name) == 0 {
+			continue
+		}
+		names = append(names, strings.ToLower(name.Name))
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	return md5Hex(strings.Join(names, ","))
+}
+
+// md5Hex returns the hex-encoded MD5 hash of the given string.
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
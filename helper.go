@@ -2,7 +2,10 @@ package pe
 
 import (
 	"bytes"
+	"io"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 // ### [ Helper functions ] ####################################################
@@ -24,18 +27,94 @@ func parseCString(b []byte) string {
 }
 
 // parseCString parses a NULL-terminated string at the given address into a
-// corresponding Go string.
+// corresponding Go string. The string is read from the in-memory image, and is
+// truncated at the end of the image.
 func (file *File) parseCString(addr uint64) string {
+	const chunkSize = 64
 	var buf []byte
-	for {
-		bs := file.ReadData(addr, 1)
-		b := bs[0]
-		if b == '\x00' {
+	relAddr := addr - file.OptHdr.ImageBase
+	for relAddr < uint64(file.OptHdr.ImageSize) {
+		n := minUint64(chunkSize, uint64(file.OptHdr.ImageSize)-relAddr)
+		chunk, err := file.ReadImage(uint32(relAddr), int64(n))
+		if err != nil {
+			break
+		}
+		if pos := bytes.IndexByte(chunk, '\x00'); pos != -1 {
 			// Break at NULL-byte
+			buf = append(buf, chunk[:pos]...)
 			break
 		}
-		buf = append(buf, b)
-		addr++
+		buf = append(buf, chunk...)
+		relAddr += n
 	}
 	return string(buf)
 }
+
+// alignUp rounds x up to the nearest multiple of align. The value of x is
+// returned as is if align is zero.
+func alignUp(x, align uint32) uint32 {
+	if align == 0 {
+		return x
+	}
+	return (x + align - 1) / align * align
+}
+
+// alignUp64 rounds x up to the nearest multiple of align. The value of x is
+// returned as is if align is zero.
+func alignUp64(x, align uint64) uint64 {
+	if align == 0 {
+		return x
+	}
+	return (x + align - 1) / align * align
+}
+
+// alignDown rounds x down to the nearest multiple of align. The value of x is
+// returned as is if align is zero.
+func alignDown(x, align uint32) uint32 {
+	if align == 0 {
+		return x
+	}
+	return x / align * align
+}
+
+// minUint64 returns the smaller of x and y.
+func minUint64(x, y uint64) uint64 {
+	if x < y {
+		return x
+	}
+	return y
+}
+
+// maxUint64 returns the larger of x and y.
+func maxUint64(x, y uint64) uint64 {
+	if x > y {
+		return x
+	}
+	return y
+}
+
+// minInt64 returns the smaller of x and y.
+func minInt64(x, y int64) int64 {
+	if x < y {
+		return x
+	}
+	return y
+}
+
+// maxInt64 returns the larger of x and y.
+func maxInt64(x, y int64) int64 {
+	if x > y {
+		return x
+	}
+	return y
+}
+
+// isEOF reports whether the cause of the given error is io.EOF or
+// io.ErrUnexpectedEOF.
+func isEOF(err error) bool {
+	switch errors.Cause(err) {
+	case io.EOF, io.ErrUnexpectedEOF:
+		return true
+	}
+	return false
+}
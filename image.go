@@ -0,0 +1,238 @@
+package pe
+
+import "github.com/pkg/errors"
+
+// --- [ Image ] ---------------------------------------------------------------
+
+// Page size used by the Windows loader.
+const pageSize = 0x1000
+
+// Image returns the in-memory image of the PE file, as mapped by the Windows
+// loader. The headers are located at relative address 0 and each section is
+// placed at its relative address. Uninitialized data (e.g. BSS) is
+// zero-filled.
+//
+// The returned buffer is SizeOfImage bytes long, truncated at the end of the
+// last section in memory; memory past the end of the sections is not backed
+// by the PE file, and is zero when read with ReadImage. For mapped images and
+// images in low alignment mode, the buffer is truncated at the end of file,
+// aligned to the section alignment.
+//
+// A new buffer is returned on each invocation, and may be modified by the
+// caller.
+func (file *File) Image() []byte {
+	var end uint64
+	for _, region := range file.imageRegions() {
+		end = maxUint64(end, uint64(region.relAddr)+uint64(region.size))
+	}
+	end = minUint64(end, uint64(file.OptHdr.ImageSize))
+	buf := make([]byte, end)
+	file.mapImage(buf, 0)
+	return buf
+}
+
+// ReadImage reads n bytes from the in-memory image of the PE file, starting at
+// the given relative address (relative to image base). An error is returned if
+// the memory range is outside of the image.
+func (file *File) ReadImage(relAddr uint32, n int64) ([]byte, error) {
+	if n < 0 {
+		return nil, errors.Errorf("invalid number of bytes to read; expected >= 0, got %d", n)
+	}
+	end := uint64(relAddr) + uint64(n)
+	if end > uint64(file.OptHdr.ImageSize) {
+		return nil, errors.Errorf("unable to read image data at relative address 0x%08X (%d bytes); image size 0x%08X", relAddr, n, file.OptHdr.ImageSize)
+	}
+	buf := make([]byte, n)
+	file.mapImage(buf, relAddr)
+	return buf, nil
+}
+
+// imageRegion is a memory region of the in-memory image, backed by contents of
+// the PE file.
+type imageRegion struct {
+	// Relative address of region (relative to image base).
+	relAddr uint32
+	// Size of region in memory.
+	size uint32
+	// File offset of region contents.
+	offset uint32
+	// Size of region contents on file; the remaining size-dataSize bytes are
+	// zero-filled.
+	dataSize uint32
+}
+
+// Mapped reports whether the file contents use the mapped layout of in-memory
+// images (see ParseMapped), rather than the on-disk layout.
+func (file *File) Mapped() bool {
+	return file.mapped
+}
+
+// imageRegions returns the memory regions of the in-memory image, in the order
+// they are mapped by the Windows loader. Later regions take precedence over
+// earlier ones.
+//
+// The regions are computed once and cached until the layout of sections
+// changes.
+func (file *File) imageRegions() []imageRegion {
+	if file.regions == nil {
+		file.regions = file.computeImageRegions()
+	}
+	return file.regions
+}
+
+// computeImageRegions computes the memory regions of the in-memory image; see
+// imageRegions.
+func (file *File) computeImageRegions() []imageRegion {
+	sectAlign := file.OptHdr.SectionAlign
+	fileAlign := file.OptHdr.FileAlign
+	if file.mapped || sectAlign < pageSize {
+		// Mapped images and low alignment mode; in low alignment mode, file
+		// alignment is required to be equal to section alignment and the file
+		// is mapped as is.
+		dataSize := file.fileRangeSize(0, file.OptHdr.ImageSize)
+		// Memory past the end of file is not backed by file contents.
+		size := minUint64(uint64(file.OptHdr.ImageSize), alignUp64(uint64(dataSize), uint64(sectAlign)))
+		region := imageRegion{
+			relAddr:  0,
+			size:     uint32(size),
+			offset:   0,
+			dataSize: dataSize,
+		}
+		return []imageRegion{region}
+	}
+	// Headers.
+	hdrSize := alignUp(file.OptHdr.HeadersSize, fileAlign)
+	regions := []imageRegion{{
+		relAddr:  0,
+		size:     hdrSize,
+		offset:   0,
+		dataSize: file.fileRangeSize(0, hdrSize),
+	}}
+	// Sections.
+	for _, sectHdr := range file.SectHdrs {
+		virtSize := sectHdr.VirtualSize
+		if virtSize == 0 {
+			virtSize = sectHdr.DataSize
+		}
+		virtSize = alignUp(virtSize, sectAlign)
+		// The loader rounds the file offset of section contents down to a
+		// multiple of 512 when the file alignment is at least 512.
+		offset := sectHdr.DataOffset
+		if fileAlign >= 0x200 {
+			offset = alignDown(offset, 0x200)
+		}
+		dataSize := alignUp(sectHdr.DataSize, fileAlign)
+		if dataSize > virtSize {
+			dataSize = virtSize
+		}
+		region := imageRegion{
+			relAddr:  alignDown(sectHdr.RelAddr, sectAlign),
+			size:     virtSize,
+			offset:   offset,
+			dataSize: file.fileRangeSize(offset, dataSize),
+		}
+		regions = append(regions, region)
+	}
+	return regions
+}
+
+// mapImage maps the in-memory image of the PE file into buf, starting at the
+// given relative address. Memory not backed by file contents is zero-filled.
+func (file *File) mapImage(buf []byte, relAddr uint32) {
+	start := uint64(relAddr)
+	end := start + uint64(len(buf))
+	for _, region := range file.imageRegions() {
+		regionStart := uint64(region.relAddr)
+		regionEnd := regionStart + uint64(region.size)
+		// Clear memory of region, as it may overlap a previous region.
+		lo, hi := maxUint64(start, regionStart), minUint64(end, regionEnd)
+		if lo >= hi {
+			continue
+		}
+		for i := lo; i < hi; i++ {
+			buf[i-start] = 0
+		}
+		// Copy file contents of region.
+		dataEnd := regionStart + uint64(region.dataSize)
+		if hi > dataEnd {
+			hi = dataEnd
+		}
+		if lo >= hi {
+			continue
+		}
+		data := file.fileRange(region.offset+uint32(lo-regionStart), uint32(hi-lo))
+		copy(buf[lo-start:hi-start], data)
+	}
+}
+
+// fileOffset returns the file offset of the n bytes located at the given
+// relative address (relative to image base) of the in-memory image. The boolean
+// return value indicates success; it is false if the memory range is not
+// backed by file contents.
+func (file *File) fileOffset(relAddr uint32, n uint32) (uint32, bool) {
+	regions := file.imageRegions()
+	for i := len(regions) - 1; i >= 0; i-- {
+		region := regions[i]
+		start := uint64(region.relAddr)
+		if !(start <= uint64(relAddr) && uint64(relAddr) < start+uint64(region.size)) {
+			continue
+		}
+		// Later regions take precedence over earlier ones.
+		if uint64(relAddr)+uint64(n) > start+uint64(region.dataSize) {
+			return 0, false
+		}
+		return region.offset + (relAddr - region.relAddr), true
+	}
+	return 0, false
+}
+
+// sectionOffset returns the offset of the contents of the given section within
+// the file contents; i.e. the relative address of the section in mapped images
+// and the raw data offset otherwise.
+func (file *File) sectionOffset(sectHdr SectionHeader) uint32 {
+	if file.mapped {
+		return sectHdr.RelAddr
+	}
+	return sectHdr.DataOffset
+}
+
+// sectionSize returns the size of the contents of the given section within the
+// file contents; i.e. the virtual size of the section in mapped images and the
+// raw data size otherwise.
+func (file *File) sectionSize(sectHdr SectionHeader) uint32 {
+	if file.mapped {
+		return virtualSize(sectHdr)
+	}
+	return sectHdr.DataSize
+}
+
+// fileRange returns the contents of the PE file at the given file offset and
+// length, truncated at the end of file.
+func (file *File) fileRange(offset, n uint32) []byte {
+	if file.Content != nil {
+		start := uint64(offset)
+		if start > uint64(len(file.Content)) {
+			start = uint64(len(file.Content))
+		}
+		end := start + uint64(file.fileRangeSize(offset, n))
+		return file.Content[start:end]
+	}
+	buf := make([]byte, file.fileRangeSize(offset, n))
+	m, _ := file.ReadAt(buf, int64(offset))
+	return buf[:m]
+}
+
+// fileRangeSize returns the length of the contents of the PE file at the given
+// file offset and length, truncated at the end of file.
+func (file *File) fileRangeSize(offset, n uint32) uint32 {
+	size := uint64(file.Size())
+	start := uint64(offset)
+	end := start + uint64(n)
+	if start > size {
+		start = size
+	}
+	if end > size {
+		end = size
+	}
+	return uint32(end - start)
+}
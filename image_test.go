@@ -0,0 +1,155 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+
+	"github.com/mewmew/pe/enum"
+)
+
+func TestImage(t *testing.T) {
+	golden := []struct {
+		path string
+	}{
+		{path: "testdata/gcc-386-mingw-exec"},
+		{path: "testdata/gcc-386-mingw-no-symbols-exec"},
+	}
+	for _, g := range golden {
+		content, err := ioutil.ReadFile(g.path)
+		if err != nil {
+			t.Errorf("%q: unable to read file; %v", g.path, err)
+			continue
+		}
+		file, err := ParseBytes(content)
+		if err != nil {
+			t.Errorf("%q: unable to parse file; %+v", g.path, err)
+			continue
+		}
+		image := file.Image()
+		if len(image) != int(file.OptHdr.ImageSize) {
+			t.Errorf("%q: image size mismatch; expected 0x%X, got 0x%X", g.path, file.OptHdr.ImageSize, len(image))
+			continue
+		}
+		hdrsSize := file.OptHdr.HeadersSize
+		if !bytes.Equal(image[:hdrsSize], content[:hdrsSize]) {
+			t.Errorf("%q: headers mismatch", g.path)
+		}
+		for _, sectHdr := range file.SectHdrs {
+			n := minUint32(sectHdr.DataSize, virtualSize(sectHdr))
+			want := content[sectHdr.DataOffset : sectHdr.DataOffset+n]
+			got := image[sectHdr.RelAddr : sectHdr.RelAddr+n]
+			if !bytes.Equal(got, want) {
+				t.Errorf("%q: contents mismatch of section %q", g.path, sectHdr.Name)
+			}
+			// Memory not backed by raw data is zero-filled.
+			end := sectHdr.RelAddr + alignUp(virtualSize(sectHdr), file.OptHdr.SectionAlign)
+			for relAddr := sectHdr.RelAddr + n; relAddr < end; relAddr++ {
+				if image[relAddr] != 0 {
+					t.Errorf("%q: non-zero byte 0x%02X at relative address 0x%08X past raw data of section %q", g.path, image[relAddr], relAddr, sectHdr.Name)
+					break
+				}
+			}
+		}
+		// Parse the in-memory image.
+		mapped, err := ParseMapped(image)
+		if err != nil {
+			t.Errorf("%q: unable to parse mapped image; %+v", g.path, err)
+			continue
+		}
+		if !bytes.Equal(mapped.Image(), image) {
+			t.Errorf("%q: contents mismatch of mapped image", g.path)
+		}
+	}
+}
+
+func TestImageSizeBound(t *testing.T) {
+	const path = "testdata/gcc-386-mingw-exec"
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%q: unable to read file; %v", path, err)
+	}
+	file, err := ParseBytes(content)
+	if err != nil {
+		t.Fatalf("%q: unable to parse file; %+v", path, err)
+	}
+	want := file.Image()
+	// Set SizeOfImage far past the end of the last section.
+	content = append([]byte(nil), content...)
+	binary.LittleEndian.PutUint32(content[file.optHdrOffset()+optImageSizeOffset:], 0xFFFFF000)
+	file, err = ParseBytes(content)
+	if err != nil {
+		t.Fatalf("%q: unable to parse file; %+v", path, err)
+	}
+	// The image is truncated at the end of the last section; the contents
+	// match except for the patched SizeOfImage of the headers.
+	got := file.Image()
+	if len(got) != len(want) {
+		t.Errorf("%q: image size mismatch; expected 0x%X, got 0x%X", path, len(want), len(got))
+	} else if hdrsSize := file.OptHdr.HeadersSize; !bytes.Equal(got[hdrsSize:], want[hdrsSize:]) {
+		t.Errorf("%q: contents mismatch of sections", path)
+	}
+	// Memory past the end of the last section is zero-filled.
+	buf, err := file.ReadImage(0xFFFFE000, 0x10)
+	if err != nil {
+		t.Errorf("%q: unable to read image; %+v", path, err)
+	} else if !bytes.Equal(buf, make([]byte, 0x10)) {
+		t.Errorf("%q: contents mismatch past end of sections; expected zeros, got % X", path, buf)
+	}
+}
+
+func TestReadImage(t *testing.T) {
+	const path = "testdata/gcc-386-mingw-exec"
+	file, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("%q: unable to parse file; %+v", path, err)
+	}
+	image := file.Image()
+	imageSize := file.OptHdr.ImageSize
+	golden := []struct {
+		relAddr uint32
+		n       int64
+		// Expected error; false if none.
+		err bool
+	}{
+		{relAddr: 0, n: 0},
+		{relAddr: 0, n: 0x40},
+		{relAddr: file.OptHdr.EntryRelAddr, n: 0x10},
+		// Crossing the end of the headers into the first section.
+		{relAddr: file.SectHdrs[0].RelAddr - 0x10, n: 0x20},
+		// Spanning every section.
+		{relAddr: 0, n: int64(imageSize)},
+		{relAddr: imageSize - 1, n: 1},
+		{relAddr: imageSize, n: 0},
+		{relAddr: imageSize - 1, n: 2, err: true},
+		{relAddr: imageSize, n: 1, err: true},
+		{relAddr: 0xFFFFFFFF, n: 2, err: true},
+		{relAddr: 0, n: -1, err: true},
+	}
+	for _, g := range golden {
+		buf, err := file.ReadImage(g.relAddr, g.n)
+		if g.err {
+			if err == nil {
+				t.Errorf("relative address 0x%08X (%d bytes): expected error, got nil", g.relAddr, g.n)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("relative address 0x%08X (%d bytes): unable to read image; %+v", g.relAddr, g.n, err)
+			continue
+		}
+		want := image[g.relAddr : int64(g.relAddr)+g.n]
+		if !bytes.Equal(buf, want) {
+			t.Errorf("relative address 0x%08X (%d bytes): contents mismatch; expected % X, got % X", g.relAddr, g.n, want, buf)
+		}
+	}
+	// The cached memory regions are updated when adding a section.
+	data := []byte("section contents")
+	if _, err := file.AddSection(".test", enum.SectionFlagContainsInitializedData|enum.SectionFlagMemRead, data); err != nil {
+		t.Fatalf("%q: unable to add section; %+v", path, err)
+	}
+	if got := len(file.Image()); got != int(file.OptHdr.ImageSize) {
+		t.Errorf("%q: image size mismatch after adding section; expected 0x%X, got 0x%X", path, file.OptHdr.ImageSize, got)
+	}
+}
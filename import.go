@@ -25,6 +25,10 @@ type ImportEntry struct {
 	INTs []INTEntry
 	// Import address table entries.
 	IATs []INTEntry
+	// Resolved addresses of imported symbols, as stored in the import address
+	// table of mapped images (see ParseMapped); IATs is not present for mapped
+	// images.
+	IATAddrs []uint64
 }
 
 // INTEntry is an import name table entry of a PE file.
@@ -49,3 +53,57 @@ type NameEntry struct {
 	// Name of the entry.
 	Name string
 }
+
+// --- [ Bound Import ] --------------------------------------------------------
+
+// BoundImportDirectory is a bound import data directory.
+type BoundImportDirectory struct {
+	// Creation time of the bound DLL.
+	Date time.Time
+	// DLL name.
+	Name string
+	// Forwarder references of the bound DLL.
+	ForwarderRefs []BoundForwarderRef
+}
+
+// BoundForwarderRef is a forwarder reference of a bound import data directory.
+type BoundForwarderRef struct {
+	// Creation time of the DLL forwarded to.
+	Date time.Time
+	// DLL name.
+	Name string
+}
+
+// --- [ Delay Import ] --------------------------------------------------------
+
+// DelayImportDirectory is a delay-load import data directory.
+type DelayImportDirectory struct {
+	// Attributes; bit 0 is set if the addresses of the raw directory are
+	// relative (relative to image base). Addresses are converted to relative
+	// addresses when parsed.
+	Attributes uint32
+	// DLL name.
+	Name string
+	// Relative address of the module handle of the DLL.
+	ModuleHandleRelAddr uint32
+	// Relative address of delay-load import address table (IAT).
+	IATRelAddr uint32
+	// Relative address of delay-load import name table (INT).
+	INTRelAddr uint32
+	// (optional) Relative address of bound delay-load import address table;
+	// zero if not present.
+	BoundIATRelAddr uint32
+	// (optional) Relative address of unload delay-load import address table;
+	// zero if not present.
+	UnloadIATRelAddr uint32
+	// Creation time of the bound DLL; zero (Epoch) if not bound.
+	Date time.Time
+}
+
+// DelayImportEntry contains the contents of a delay-load import entry.
+type DelayImportEntry struct {
+	// Delay-load import data directory.
+	DelayImpDir DelayImportDirectory
+	// Delay-load import name table entries.
+	INTs []INTEntry
+}
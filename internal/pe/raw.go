@@ -335,6 +335,59 @@ type RawSectionHeader struct {
 
 // --- [ Data directories ] ----------------------------------------------------
 
+// ~~~ [ 0 - Export Table ] ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+
+// RawExportDirectory is an export data directory (in raw format).
+//
+// ref: https://docs.microsoft.com/en-us/windows/desktop/debug/pe-format#export-directory-table
+type RawExportDirectory struct {
+	// Reserved.
+	//
+	// offset: 0x0000 (4 bytes)
+	Characteristics uint32
+	// Export data creation time, measured in number of seconds since Epoch.
+	//
+	// offset: 0x0004 (4 bytes)
+	Date uint32
+	// Major export table format version.
+	//
+	// offset: 0x0008 (2 bytes)
+	MajorVer uint16
+	// Minor export table format version.
+	//
+	// offset: 0x000A (2 bytes)
+	MinorVer uint16
+	// Relative address of the DLL name (relative to image base).
+	//
+	// offset: 0x000C (4 bytes)
+	NameRelAddr uint32
+	// Starting ordinal number of exports in the image; typically 1.
+	//
+	// offset: 0x0010 (4 bytes)
+	OrdinalBase uint32
+	// Number of entries in the export address table.
+	//
+	// offset: 0x0014 (4 bytes)
+	NFuncs uint32
+	// Number of entries in the export name pointer table and the export
+	// ordinal table.
+	//
+	// offset: 0x0018 (4 bytes)
+	NNames uint32
+	// Relative address of export address table (EAT).
+	//
+	// offset: 0x001C (4 bytes)
+	FuncsRelAddr uint32
+	// Relative address of export name pointer table.
+	//
+	// offset: 0x0020 (4 bytes)
+	NamesRelAddr uint32
+	// Relative address of export ordinal table.
+	//
+	// offset: 0x0024 (4 bytes)
+	OrdinalsRelAddr uint32
+}
+
 // ~~~ [ 1 - Import Table ] ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 
 // RawImportDirectory is an import data directory (in raw format). The last
@@ -623,11 +676,12 @@ type RawDebugDirectory struct {
 	Offset uint32
 }
 
-// RawCodeViewInfo contains CodeView debug information (in raw format).
+// RawCodeViewInfoNB10 contains CodeView debug information in PDB 2.0 format
+// (in raw format).
 //
 // ref: Visual C++ 5.0 Symbolic Debug Information Specification
 // ref: https://github.com/Microsoft/microsoft-pdb/blob/master/include/cvinfo.h
-type RawCodeViewInfo struct {
+type RawCodeViewInfoNB10 struct {
 	// CodeView signature ("NB10").
 	//
 	// offset: 0x0000 (4 bytes)
@@ -648,6 +702,38 @@ type RawCodeViewInfo struct {
 	Age uint32
 }
 
+// RawCodeViewInfoRSDS contains CodeView debug information in PDB 7.0 format
+// (in raw format).
+//
+// ref: https://github.com/Microsoft/microsoft-pdb/blob/master/PDB/dbi/locator.h
+type RawCodeViewInfoRSDS struct {
+	// CodeView signature ("RSDS").
+	//
+	// offset: 0x0000 (4 bytes)
+	Signature uint32
+	// Unique identifier of the PDB file.
+	//
+	// offset: 0x0004 (16 bytes)
+	GUID RawGUID
+	// Incremental number, initially set to 1 and incremented for each partial
+	// write to the PDB file.
+	//
+	// offset: 0x0014 (4 bytes)
+	Age uint32
+}
+
+// RawGUID is a globally unique identifier (in raw format).
+type RawGUID struct {
+	// offset: 0x0000 (4 bytes)
+	Data1 uint32
+	// offset: 0x0004 (2 bytes)
+	Data2 uint16
+	// offset: 0x0006 (2 bytes)
+	Data3 uint16
+	// offset: 0x0008 (8 bytes)
+	Data4 [8]byte
+}
+
 // RawFPOData represents the stack frame layout for a function on an x86
 // computer when frame pointer omission (FPO) optimization is used. The
 // structure is used to locate the base of the call frame.
@@ -691,3 +777,88 @@ type RawFPOData struct {
 	// offset: 0x000F (1 bytes)
 	Bitfield uint8
 }
+
+// ~~~ [ 11 - Bound Import Table ] ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+
+// RawBoundImportDirectory is a bound import data directory (in raw format).
+// Following the directory are NForwarderRefs forwarder references. The last
+// entry is zero to indicate the end of the bound import table.
+//
+// ref: https://docs.microsoft.com/en-us/archive/msdn-magazine/2002/march/inside-windows-an-in-depth-look-into-the-win32-portable-executable-file-format-part-2
+type RawBoundImportDirectory struct {
+	// Creation time of the bound DLL, measured in number of seconds since
+	// Epoch.
+	//
+	// offset: 0x0000 (4 bytes)
+	Date uint32
+	// Offset of DLL name, relative to the start of the bound import table.
+	//
+	// offset: 0x0004 (2 bytes)
+	NameOffset uint16
+	// Number of forwarder references following the directory.
+	//
+	// offset: 0x0006 (2 bytes)
+	NForwarderRefs uint16
+}
+
+// RawBoundForwarderRef is a forwarder reference of a bound import data
+// directory (in raw format).
+type RawBoundForwarderRef struct {
+	// Creation time of the DLL forwarded to, measured in number of seconds
+	// since Epoch.
+	//
+	// offset: 0x0000 (4 bytes)
+	Date uint32
+	// Offset of DLL name, relative to the start of the bound import table.
+	//
+	// offset: 0x0004 (2 bytes)
+	NameOffset uint16
+	// Reserved.
+	//
+	// offset: 0x0006 (2 bytes)
+	Reserved uint16
+}
+
+// ~~~ [ 13 - Delay Import Descriptor ] ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+
+// RawDelayImportDirectory is a delay-load import data directory (in raw
+// format). The last entry is zero to indicate the end of the delay-load import
+// table.
+//
+// ref: https://docs.microsoft.com/en-us/windows/desktop/debug/pe-format#delay-load-directory-table
+type RawDelayImportDirectory struct {
+	// Attributes; bit 0 is set if addresses are relative (relative to image
+	// base), and clear if addresses are absolute (set by Visual C++ 6.0).
+	//
+	// offset: 0x0000 (4 bytes)
+	Attributes uint32
+	// Address of the DLL name.
+	//
+	// offset: 0x0004 (4 bytes)
+	NameRelAddr uint32
+	// Address of the module handle of the DLL.
+	//
+	// offset: 0x0008 (4 bytes)
+	ModuleHandleRelAddr uint32
+	// Address of delay-load import address table (IAT).
+	//
+	// offset: 0x000C (4 bytes)
+	IATRelAddr uint32
+	// Address of delay-load import name table (INT).
+	//
+	// offset: 0x0010 (4 bytes)
+	INTRelAddr uint32
+	// (optional) Address of bound delay-load import address table.
+	//
+	// offset: 0x0014 (4 bytes)
+	BoundIATRelAddr uint32
+	// (optional) Address of unload delay-load import address table.
+	//
+	// offset: 0x0018 (4 bytes)
+	UnloadIATRelAddr uint32
+	// Creation time of the bound DLL, measured in number of seconds since
+	// Epoch; zero if not bound.
+	//
+	// offset: 0x001C (4 bytes)
+	Date uint32
+}
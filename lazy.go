@@ -0,0 +1,151 @@
+package pe
+
+import (
+	"github.com/mewmew/pe/enum"
+	"github.com/pkg/errors"
+)
+
+// --- [ Data directory contents ] ---------------------------------------------
+
+// Indices of data directories.
+const (
+	dataDirExport      = 0
+	dataDirImport      = 1
+	dataDirResource    = 2
+	dataDirCert        = 4
+	dataDirBaseReloc   = 5
+	dataDirDebug       = 6
+	dataDirBoundImport = 11
+	dataDirIAT         = 12
+	dataDirDelayImport = 13
+)
+
+// Exports returns the export table of the PE file, or nil if not present. The
+// export table is parsed on first use.
+func (file *File) Exports() (*ExportTable, error) {
+	if err := file.loadDataDir(dataDirExport); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return file.Exps, nil
+}
+
+// Imports returns the import table of the PE file. The import table is parsed
+// on first use.
+func (file *File) Imports() ([]ImportEntry, error) {
+	if err := file.loadDataDir(dataDirImport); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return file.Imps, nil
+}
+
+// Resources returns the root directory of the resource tree of the PE file; or
+// nil if not present. The resource table is parsed on first use.
+func (file *File) Resources() (*ResourceDirectory, error) {
+	if err := file.loadDataDir(dataDirResource); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return file.Rsrcs, nil
+}
+
+// BaseRelocs returns the base relocation blocks of the PE file. The base
+// relocation table is parsed on first use.
+func (file *File) BaseRelocs() ([]BaseRelocBlock, error) {
+	if err := file.loadDataDir(dataDirBaseReloc); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return file.BaseRelocBlocks, nil
+}
+
+// Debug returns the debug data of the PE file. The debug directory is parsed
+// on first use.
+func (file *File) Debug() ([]DebugData, error) {
+	if err := file.loadDataDir(dataDirDebug); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return file.DbgData, nil
+}
+
+// DebugDirs returns the debug directories of the PE file, without parsing
+// their debug data.
+func (file *File) DebugDirs() ([]DebugDirectory, error) {
+	if dataDirDebug >= len(file.DataDirs) || file.DataDirs[dataDirDebug].RelAddr == 0 {
+		return nil, nil
+	}
+	dbgDirs, err := file.parseDebugDirs(file.DataDirs[dataDirDebug])
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse debug directories")
+	}
+	return dbgDirs, nil
+}
+
+// CodeView returns the CodeView debug data of the PE file, or nil if not
+// present in a supported format (i.e. "NB10" or "RSDS"). Only the CodeView
+// debug data is parsed; other debug data is ignored.
+func (file *File) CodeView() (*DebugCodeView, error) {
+	dbgDirs, err := file.DebugDirs()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for _, dbgDir := range dbgDirs {
+		if dbgDir.Type != enum.DebugTypeCodeView {
+			continue
+		}
+		if file.mapped && dbgDir.RelAddr == 0 {
+			// Debug data not mapped into memory is absent from mapped images.
+			continue
+		}
+		buf, err := file.readDebugData(dbgDir)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read CodeView debug data")
+		}
+		dbgCodeView, err := parseDebugCodeViewInfo(dbgDir, buf)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if dbgCodeView == nil {
+			// Skip CodeView formats not yet supported.
+			continue
+		}
+		return dbgCodeView, nil
+	}
+	return nil, nil
+}
+
+// BoundImports returns the bound import table of the PE file. The bound import
+// table is parsed on first use.
+func (file *File) BoundImports() ([]BoundImportDirectory, error) {
+	if err := file.loadDataDir(dataDirBoundImport); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return file.BoundImps, nil
+}
+
+// DelayImports returns the delay-load import table of the PE file. The delay
+// import descriptors are parsed on first use.
+func (file *File) DelayImports() ([]DelayImportEntry, error) {
+	if err := file.loadDataDir(dataDirDelayImport); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return file.DelayImps, nil
+}
+
+// loadDataDir parses the contents of the data directory with the given index,
+// unless already parsed. Parse errors are not cached; the data directory is
+// parsed anew on the next use.
+func (file *File) loadDataDir(idx int) error {
+	if idx >= len(file.DataDirs) || file.parsedDirs&(1<<uint(idx)) != 0 {
+		return nil
+	}
+	if err := file.parseDataDir(idx); err != nil {
+		return errors.Wrapf(err, "unable to parse contents of data directory %d", idx)
+	}
+	return nil
+}
+
+// markParsed records the contents of the data directory with the given index
+// as parsed.
+func (file *File) markParsed(idx int) {
+	if idx < maxDataDirs {
+		file.parsedDirs |= 1 << uint(idx)
+	}
+}
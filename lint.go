@@ -0,0 +1,331 @@
+package pe
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/mewmew/pe/enum"
+)
+
+// Severity specifies the severity of an anomaly.
+type Severity uint8
+
+// Anomaly severities.
+const (
+	// Unusual but valid structure.
+	SeverityInfo Severity = iota + 1
+	// Suspicious structure, commonly found in packed or malicious files.
+	SeverityWarning
+	// Malformed structure, violating the PE format.
+	SeverityError
+)
+
+// String returns the string representation of the severity.
+func (severity Severity) String() string {
+	switch severity {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// Anomaly is a suspicious or malformed structure of a PE file.
+type Anomaly struct {
+	// Severity of the anomaly.
+	Severity Severity
+	// File offset of the structure or field exhibiting the anomaly.
+	Offset int64
+	// Description of the anomaly.
+	Desc string
+}
+
+// String returns the string representation of the anomaly.
+func (a Anomaly) String() string {
+	return fmt.Sprintf("%v at offset 0x%X: %s", a.Severity, a.Offset, a.Desc)
+}
+
+// Lint returns the anomalies of the PE file, sorted by file offset. The
+// warnings recorded while parsing are included, as are the warnings of data
+// directories not yet parsed.
+//
+// Lint has no side effects; the data directories are parsed on a copy of the
+// PE file, so that the anomalies do not depend on which data directories were
+// previously accessed.
+func (file *File) Lint() []Anomaly {
+	if file.FileHdr == nil || file.OptHdr == nil || file.Size() < 0x40 {
+		return append([]Anomaly(nil), file.Warnings...)
+	}
+	l := &linter{file: file}
+	l.lintHeaders()
+	l.lintSections()
+	l.lintEntryPoint()
+	// Parse the contents of every data directory on a shallow copy of the PE
+	// file, starting from the warnings recorded while parsing headers.
+	dup := *file
+	n := file.nhdrWarnings
+	if n > len(file.Warnings) {
+		n = len(file.Warnings)
+	}
+	dup.Warnings = file.Warnings[:n:n]
+	dup.parsedDirs = 0
+	for idx := range dup.DataDirs {
+		if err := dup.parseDataDir(idx); err != nil {
+			l.addf(SeverityError, file.dataDirOffset(idx), "unable to parse contents of data directory %d; %v", idx, err)
+		}
+	}
+	l.lintImports(dup.Imps)
+	l.anomalies = append(l.anomalies, dup.Warnings...)
+	sort.SliceStable(l.anomalies, func(i, j int) bool {
+		return l.anomalies[i].Offset < l.anomalies[j].Offset
+	})
+	return l.anomalies
+}
+
+// warnf records a parse warning at the specified file offset.
+func (file *File) warnf(offset int64, format string, args ...interface{}) {
+	warning := Anomaly{
+		Severity: SeverityWarning,
+		Offset:   offset,
+		Desc:     fmt.Sprintf(format, args...),
+	}
+	file.Warnings = append(file.Warnings, warning)
+}
+
+// linter records the anomalies of a PE file.
+type linter struct {
+	// PE file.
+	file *File
+	// Anomalies recorded.
+	anomalies []Anomaly
+}
+
+// addf records an anomaly of the given severity at the specified file offset.
+func (l *linter) addf(severity Severity, offset int64, format string, args ...interface{}) {
+	anomaly := Anomaly{
+		Severity: severity,
+		Offset:   offset,
+		Desc:     fmt.Sprintf(format, args...),
+	}
+	l.anomalies = append(l.anomalies, anomaly)
+}
+
+// Offsets of optional header fields, relative to the start of the optional
+// header.
+const (
+	optEntryRelAddrOffset = 16
+	optSectionAlignOffset = 32
+	optFileAlignOffset    = 36
+	optImageSizeOffset    = 56
+	optHeadersSizeOffset  = 60
+	optChecksumOffset     = 64
+)
+
+// optHdrOffset returns the file offset of the optional header, as parsed.
+func (file *File) optHdrOffset() int64 {
+	return file.optHdrOff
+}
+
+// ndataDirsOffset returns the file offset of the NumberOfRvaAndSizes field of
+// the optional header.
+func (file *File) ndataDirsOffset() int64 {
+	if file.OptHdr.Magic == magic64 {
+		return file.optHdrOffset() + 108
+	}
+	return file.optHdrOffset() + 92
+}
+
+// dataDirOffset returns the file offset of the data directory with the given
+// index.
+func (file *File) dataDirOffset(idx int) int64 {
+	return file.ndataDirsOffset() + 4 + int64(idx)*8
+}
+
+// sectHdrOffset returns the file offset of the section header with the given
+// index.
+func (file *File) sectHdrOffset(idx int) int64 {
+	// The section table is located directly after the optional header,
+	// regardless of the number of data directories.
+	return file.optHdrOffset() + int64(file.FileHdr.OptHdrSize) + int64(idx)*40
+}
+
+// lintHeaders records anomalies of the file and optional headers.
+func (l *linter) lintHeaders() {
+	file := l.file
+	optHdr := file.OptHdr
+	optOffset := l.file.optHdrOffset()
+	// Alignment.
+	if !isPowerOfTwo(optHdr.FileAlign) {
+		l.addf(SeverityError, optOffset+optFileAlignOffset, "file alignment 0x%X not a power of two", optHdr.FileAlign)
+	} else if optHdr.FileAlign < 0x200 || optHdr.FileAlign > 0x10000 {
+		l.addf(SeverityWarning, optOffset+optFileAlignOffset, "file alignment 0x%X outside of range [0x200, 0x10000]", optHdr.FileAlign)
+	}
+	if !isPowerOfTwo(optHdr.SectionAlign) {
+		l.addf(SeverityError, optOffset+optSectionAlignOffset, "section alignment 0x%X not a power of two", optHdr.SectionAlign)
+	} else if optHdr.SectionAlign < optHdr.FileAlign {
+		l.addf(SeverityWarning, optOffset+optSectionAlignOffset, "section alignment 0x%X less than file alignment 0x%X", optHdr.SectionAlign, optHdr.FileAlign)
+	}
+	// Size of image.
+	if optHdr.SectionAlign != 0 && optHdr.ImageSize%optHdr.SectionAlign != 0 {
+		l.addf(SeverityWarning, optOffset+optImageSizeOffset, "size of image 0x%X not aligned to section alignment 0x%X", optHdr.ImageSize, optHdr.SectionAlign)
+	}
+	var imageEnd uint64
+	for _, sectHdr := range file.SectHdrs {
+		imageEnd = maxUint64(imageEnd, uint64(sectHdr.RelAddr)+uint64(virtualSize(sectHdr)))
+	}
+	if uint64(optHdr.ImageSize) < imageEnd {
+		l.addf(SeverityError, optOffset+optImageSizeOffset, "size of image 0x%X less than end of last section 0x%X", optHdr.ImageSize, imageEnd)
+	}
+	// Size of headers.
+	hdrEnd := uint64(l.file.sectHdrOffset(len(file.SectHdrs)))
+	switch {
+	case uint64(optHdr.HeadersSize) < hdrEnd:
+		l.addf(SeverityError, optOffset+optHeadersSizeOffset, "size of headers 0x%X less than end of section table 0x%X", optHdr.HeadersSize, hdrEnd)
+	case optHdr.FileAlign != 0 && optHdr.HeadersSize%optHdr.FileAlign != 0:
+		l.addf(SeverityWarning, optOffset+optHeadersSizeOffset, "size of headers 0x%X not aligned to file alignment 0x%X", optHdr.HeadersSize, optHdr.FileAlign)
+	}
+	for i, sectHdr := range file.SectHdrs {
+		if sectHdr.DataSize != 0 && sectHdr.DataOffset != 0 && sectHdr.DataOffset < optHdr.HeadersSize {
+			l.addf(SeverityWarning, l.file.sectHdrOffset(i), "raw data of section %q at offset 0x%X overlaps headers (size of headers 0x%X)", sectHdr.Name, sectHdr.DataOffset, optHdr.HeadersSize)
+		}
+	}
+	// The number of data directories is checked while parsing.
+}
+
+// lintSections records anomalies of the section headers.
+func (l *linter) lintSections() {
+	file := l.file
+	names := make(map[string]bool)
+	for i, sectHdr := range file.SectHdrs {
+		offset := l.file.sectHdrOffset(i)
+		// Duplicate section names.
+		if names[sectHdr.Name] {
+			l.addf(SeverityWarning, offset, "duplicate section name %q", sectHdr.Name)
+		}
+		names[sectHdr.Name] = true
+		// Zero-sized sections.
+		if sectHdr.VirtualSize == 0 && sectHdr.DataSize != 0 {
+			l.addf(SeverityInfo, offset, "section %q with zero virtual size has 0x%X bytes of raw data", sectHdr.Name, sectHdr.DataSize)
+		}
+		if sectHdr.DataSize == 0 && sectHdr.DataOffset != 0 {
+			l.addf(SeverityInfo, offset, "section %q with zero raw data size has raw data offset 0x%X", sectHdr.Name, sectHdr.DataOffset)
+		}
+		// Raw data past end of file; not applicable to mapped images.
+		if !file.mapped && sectHdr.DataSize != 0 && uint64(sectHdr.DataOffset)+uint64(sectHdr.DataSize) > uint64(file.Size()) {
+			l.addf(SeverityError, offset, "raw data of section %q [0x%X, 0x%X) extends past end of file 0x%X", sectHdr.Name, sectHdr.DataOffset, uint64(sectHdr.DataOffset)+uint64(sectHdr.DataSize), file.Size())
+		}
+		// Alignment.
+		if file.OptHdr.SectionAlign != 0 && sectHdr.RelAddr%file.OptHdr.SectionAlign != 0 {
+			l.addf(SeverityWarning, offset, "relative address 0x%X of section %q not aligned to section alignment 0x%X", sectHdr.RelAddr, sectHdr.Name, file.OptHdr.SectionAlign)
+		}
+		// Overlapping sections.
+		for j := 0; j < i; j++ {
+			prev := file.SectHdrs[j]
+			if overlaps(prev.RelAddr, virtualSize(prev), sectHdr.RelAddr, virtualSize(sectHdr)) {
+				l.addf(SeverityError, offset, "section %q overlaps section %q in memory", sectHdr.Name, prev.Name)
+			}
+			if overlaps(prev.DataOffset, prev.DataSize, sectHdr.DataOffset, sectHdr.DataSize) {
+				l.addf(SeverityWarning, offset, "raw data of section %q overlaps raw data of section %q", sectHdr.Name, prev.Name)
+			}
+		}
+	}
+}
+
+// lintEntryPoint records anomalies of the entry point.
+func (l *linter) lintEntryPoint() {
+	file := l.file
+	ep := file.OptHdr.EntryRelAddr
+	offset := l.file.optHdrOffset() + optEntryRelAddrOffset
+	if ep == 0 {
+		// No entry point (e.g. resource-only DLL).
+		return
+	}
+	sectHdr := file.sectionAt(ep)
+	if sectHdr == nil {
+		if ep < file.OptHdr.HeadersSize {
+			l.addf(SeverityWarning, offset, "entry point 0x%X located in headers", ep)
+		} else {
+			l.addf(SeverityWarning, offset, "entry point 0x%X outside of sections", ep)
+		}
+		return
+	}
+	if sectHdr.Flags&enum.SectionFlagMemWrite != 0 {
+		l.addf(SeverityWarning, offset, "entry point 0x%X located in writable section %q", ep, sectHdr.Name)
+	}
+	if sectHdr.Flags&(enum.SectionFlagMemExecute|enum.SectionFlagContainsCode) == 0 {
+		l.addf(SeverityWarning, offset, "entry point 0x%X located in non-executable section %q", ep, sectHdr.Name)
+	}
+}
+
+// lintImports records anomalies of the import table, given the parsed import
+// entries.
+func (l *linter) lintImports(imps []ImportEntry) {
+	file := l.file
+	for _, idx := range []int{1, 12} {
+		if idx >= len(file.DataDirs) {
+			continue
+		}
+		dataDir := file.DataDirs[idx]
+		if dataDir.RelAddr == 0 {
+			continue
+		}
+		name := "import table"
+		if idx == 12 {
+			name = "import address table"
+		}
+		switch {
+		case dataDir.RelAddr < file.OptHdr.HeadersSize:
+			l.addf(SeverityWarning, l.file.dataDirOffset(idx), "%s at 0x%X located in headers", name, dataDir.RelAddr)
+		case file.sectionAt(dataDir.RelAddr) == nil:
+			l.addf(SeverityWarning, l.file.dataDirOffset(idx), "%s at 0x%X outside of sections", name, dataDir.RelAddr)
+		}
+	}
+	for _, imp := range imps {
+		for _, relAddr := range []uint32{imp.ImpDir.INTRelAddr, imp.ImpDir.IATRelAddr} {
+			if relAddr != 0 && relAddr < file.OptHdr.HeadersSize {
+				l.addf(SeverityWarning, l.file.dataDirOffset(1), "import table of %q at 0x%X located in headers", imp.ImpDir.Name, relAddr)
+			}
+		}
+	}
+}
+
+// sectionAt returns the section containing the given relative address, or nil
+// if not present.
+func (file *File) sectionAt(relAddr uint32) *SectionHeader {
+	for i := range file.SectHdrs {
+		sectHdr := &file.SectHdrs[i]
+		if sectHdr.RelAddr <= relAddr && uint64(relAddr) < uint64(sectHdr.RelAddr)+uint64(virtualSize(*sectHdr)) {
+			return sectHdr
+		}
+	}
+	return nil
+}
+
+// ### [ Helper functions ] ####################################################
+
+// virtualSize returns the size in memory of the given section; the size of raw
+// data is used if the virtual size is zero.
+func virtualSize(sectHdr SectionHeader) uint32 {
+	if sectHdr.VirtualSize == 0 {
+		return sectHdr.DataSize
+	}
+	return sectHdr.VirtualSize
+}
+
+// overlaps reports whether the non-empty ranges [start1, start1+n1) and
+// [start2, start2+n2) overlap.
+func overlaps(start1, n1, start2, n2 uint32) bool {
+	if n1 == 0 || n2 == 0 {
+		return false
+	}
+	return uint64(start1) < uint64(start2)+uint64(n2) && uint64(start2) < uint64(start1)+uint64(n1)
+}
+
+// isPowerOfTwo reports whether x is a power of two.
+func isPowerOfTwo(x uint32) bool {
+	return x != 0 && x&(x-1) == 0
+}
@@ -0,0 +1,52 @@
+package pe
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestLintDeterministic(t *testing.T) {
+	golden := []struct {
+		path string
+	}{
+		{path: "testdata/gcc-386-mingw-exec"},
+		{path: "testdata/gcc-386-mingw-no-symbols-exec"},
+	}
+	for _, g := range golden {
+		content, err := ioutil.ReadFile(g.path)
+		if err != nil {
+			t.Errorf("%q: unable to read file; %v", g.path, err)
+			continue
+		}
+		// Eagerly parsed file.
+		file, err := ParseBytes(content)
+		if err != nil {
+			t.Errorf("%q: unable to parse file; %+v", g.path, err)
+			continue
+		}
+		want := file.Lint()
+		// Lazily parsed file, before and after parsing data directories.
+		file, err = NewFile(bytes.NewReader(content), int64(len(content)))
+		if err != nil {
+			t.Errorf("%q: unable to parse file; %+v", g.path, err)
+			continue
+		}
+		warnings := append([]Anomaly(nil), file.Warnings...)
+		got := file.Lint()
+		if !reflect.DeepEqual(file.Warnings, warnings) {
+			t.Errorf("%q: warnings modified by Lint; expected %v, got %v", g.path, warnings, file.Warnings)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: anomalies mismatch of file read on demand; expected %v, got %v", g.path, want, got)
+		}
+		if _, err := file.Imports(); err != nil {
+			t.Errorf("%q: unable to parse imports; %+v", g.path, err)
+			continue
+		}
+		if got := file.Lint(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: anomalies mismatch after parsing imports; expected %v, got %v", g.path, want, got)
+		}
+	}
+}
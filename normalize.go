@@ -0,0 +1,325 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/mewmew/pe/enum"
+	"github.com/pkg/errors"
+)
+
+// --- [ Normalize ] -----------------------------------------------------------
+
+// NormalizedField is a field of a PE file canonicalized by Normalize.
+type NormalizedField struct {
+	// Name of the field (e.g. "FileHeader.Date").
+	Name string
+	// File offset of the field.
+	Offset int64
+	// Original contents of the field.
+	Old []byte
+	// Canonical contents of the field.
+	New []byte
+}
+
+// String returns the string representation of the normalized field.
+func (field NormalizedField) String() string {
+	return fmt.Sprintf("%s at offset 0x%X: %X -> %X", field.Name, field.Offset, field.Old, field.New)
+}
+
+// Normalize canonicalizes the non-deterministic fields of the PE file, so that
+// the output of reproducible builds may be compared byte by byte. The creation
+// time of the COFF file header, the export directory, the import directories,
+// the resource directories and the debug directories is cleared, as is the
+// creation time, GUID and age of CodeView debug information and the Rich
+// header; the checksum is recomputed.
+//
+// The creation time of import directories of bound imports is kept, as it is
+// used by the loader to validate the bound import address tables.
+//
+// The fields changed are returned, sorted by file offset. Parsed contents of
+// data directories are updated accordingly. The changes take effect when
+// writing the PE file; see WriteTo.
+func (file *File) Normalize() ([]NormalizedField, error) {
+	if file.mapped {
+		return nil, errors.New("unable to normalize mapped image; unmap first")
+	}
+	if file.FileHdr == nil || file.OptHdr == nil {
+		return nil, errors.New("unable to normalize PE file; missing file header or optional header")
+	}
+	n := &normalizer{file: file}
+	n.normalizeFileHeader()
+	n.normalizeExports()
+	n.normalizeImports()
+	if err := n.normalizeResources(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	n.normalizeDebug()
+	if start, end, ok := file.richHeaderRange(); ok {
+		n.clear("RichHeader", start, end-start)
+	}
+	n.normalizeParsed()
+	if err := n.normalizeChecksum(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	sort.SliceStable(n.fields, func(i, j int) bool {
+		return n.fields[i].Offset < n.fields[j].Offset
+	})
+	return n.fields, nil
+}
+
+// normalizer records the fields of a PE file canonicalized by Normalize.
+type normalizer struct {
+	// PE file.
+	file *File
+	// Fields changed.
+	fields []NormalizedField
+	// Import directories with creation time cleared, by index.
+	imps map[int]bool
+}
+
+// add records the field at the given file offset as changed, unless the
+// original contents are already canonical.
+func (n *normalizer) add(name string, offset int64, old, canonical []byte) bool {
+	if bytes.Equal(old, canonical) {
+		return false
+	}
+	field := NormalizedField{
+		Name:   name,
+		Offset: offset,
+		Old:    old,
+		New:    canonical,
+	}
+	n.fields = append(n.fields, field)
+	return true
+}
+
+// clear clears the size bytes of the field at the given file offset.
+func (n *normalizer) clear(name string, offset, size int64) bool {
+	old := make([]byte, size)
+	if _, err := n.file.ReadAt(old, offset); err != nil {
+		// Skip fields truncated by the end of file.
+		return false
+	}
+	if !n.add(name, offset, old, make([]byte, size)) {
+		return false
+	}
+	n.file.clearedRanges = append(n.file.clearedRanges, contentRange{offset: offset, n: size})
+	return true
+}
+
+// clearImage clears the size bytes of the field at the given relative address.
+func (n *normalizer) clearImage(name string, relAddr, size uint32) bool {
+	offset, ok := n.file.fileOffset(relAddr, size)
+	if !ok {
+		return false
+	}
+	return n.clear(name, int64(offset), int64(size))
+}
+
+// normalizeFileHeader clears the creation time of the COFF file header.
+func (n *normalizer) normalizeFileHeader() {
+	// TimeDateStamp of the COFF file header.
+	offset := n.file.optHdrOffset() - 20 + 4
+	old := make([]byte, 4)
+	if _, err := n.file.ReadAt(old, offset); err != nil {
+		// Skip fields truncated by the end of file.
+		return
+	}
+	n.add("FileHeader.Date", offset, old, make([]byte, 4))
+	n.file.FileHdr.Date = parseDateFromEpoch(0)
+}
+
+// normalizeExports clears the creation time of the export directory.
+func (n *normalizer) normalizeExports() {
+	if dataDirExport >= len(n.file.DataDirs) || n.file.DataDirs[dataDirExport].RelAddr == 0 {
+		return
+	}
+	// TimeDateStamp of the export directory.
+	n.clearImage("ExportDirectory.Date", n.file.DataDirs[dataDirExport].RelAddr+4, 4)
+}
+
+// normalizeImports clears the creation time of the import directories, except
+// for bound imports.
+func (n *normalizer) normalizeImports() {
+	n.imps = make(map[int]bool)
+	if dataDirImport >= len(n.file.DataDirs) || n.file.DataDirs[dataDirImport].RelAddr == 0 {
+		return
+	}
+	const impDirSize = 20
+	relAddr := n.file.DataDirs[dataDirImport].RelAddr
+	for i := 0; ; i++ {
+		buf, err := n.file.ReadImage(relAddr+uint32(i)*impDirSize, impDirSize)
+		if err != nil || bytes.Equal(buf, make([]byte, impDirSize)) {
+			// Last entry of table is zero.
+			break
+		}
+		intRelAddr := binary.LittleEndian.Uint32(buf[0:])
+		date := binary.LittleEndian.Uint32(buf[4:])
+		// A creation time of -1 indicates bound imports of the bound import
+		// table; otherwise, the import address table of bound imports without
+		// import name table cannot be unbound.
+		if date == 0xFFFFFFFF || intRelAddr == 0 {
+			continue
+		}
+		if n.clearImage(fmt.Sprintf("ImportDirectory[%d].Date", i), relAddr+uint32(i)*impDirSize+4, 4) {
+			n.imps[i] = true
+		}
+	}
+}
+
+// normalizeResources clears the creation time of the resource directories.
+func (n *normalizer) normalizeResources() error {
+	if dataDirResource >= len(n.file.DataDirs) || n.file.DataDirs[dataDirResource].RelAddr == 0 {
+		return nil
+	}
+	visited := make(map[uint32]bool)
+	if err := n.normalizeResourceDir(n.file.DataDirs[dataDirResource].RelAddr, 0, 1, visited); err != nil {
+		return errors.Wrap(err, "unable to normalize resource directories")
+	}
+	return nil
+}
+
+// normalizeResourceDir clears the creation time of the resource directory at
+// the given offset, relative to the root resource directory, and of its
+// subdirectories.
+func (n *normalizer) normalizeResourceDir(rootRelAddr, offset uint32, depth int, visited map[uint32]bool) error {
+	visited[offset] = true
+	// TimeDateStamp of the resource directory.
+	n.clearImage(fmt.Sprintf("ResourceDirectory(0x%X).Date", offset), rootRelAddr+offset+4, 4)
+	const rawSize = 16
+	buf, err := n.file.ReadImage(rootRelAddr+offset+12, 4)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	const entrySize = 8
+	nentries := int64(binary.LittleEndian.Uint16(buf[0:])) + int64(binary.LittleEndian.Uint16(buf[2:]))
+	entriesBuf, err := n.file.ReadImage(rootRelAddr+offset+rawSize, nentries*entrySize)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	const highBit = 0x80000000
+	for i := int64(0); i < nentries; i++ {
+		dataOffset := binary.LittleEndian.Uint32(entriesBuf[i*entrySize+4:])
+		if dataOffset&highBit == 0 {
+			continue
+		}
+		subdirOffset := dataOffset &^ highBit
+		if depth >= maxResourceDepth || visited[subdirOffset] {
+			continue
+		}
+		if err := n.normalizeResourceDir(rootRelAddr, subdirOffset, depth+1, visited); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// normalizeDebug clears the creation time of the debug directories, and the
+// creation time, GUID and age of CodeView debug information.
+func (n *normalizer) normalizeDebug() {
+	if dataDirDebug >= len(n.file.DataDirs) || n.file.DataDirs[dataDirDebug].RelAddr == 0 {
+		return
+	}
+	dataDir := n.file.DataDirs[dataDirDebug]
+	const dbgDirSize = 28
+	for i := uint32(0); i+dbgDirSize <= dataDir.Size; i += dbgDirSize {
+		buf, err := n.file.ReadImage(dataDir.RelAddr+i, dbgDirSize)
+		if err != nil {
+			break
+		}
+		// TimeDateStamp of the debug directory.
+		idx := i / dbgDirSize
+		n.clearImage(fmt.Sprintf("DebugDirectory[%d].Date", idx), dataDir.RelAddr+i+4, 4)
+		typ := binary.LittleEndian.Uint32(buf[12:])
+		size := binary.LittleEndian.Uint32(buf[16:])
+		offset := int64(binary.LittleEndian.Uint32(buf[24:]))
+		if enum.DebugType(typ) != enum.DebugTypeCodeView || offset == 0 || size < 16 {
+			continue
+		}
+		sig := make([]byte, 4)
+		if _, err := n.file.ReadAt(sig, offset); err != nil {
+			continue
+		}
+		switch string(sig) {
+		case "NB10":
+			n.clear("CodeViewInfo.Date", offset+8, 4)
+			n.clear("CodeViewInfo.Age", offset+12, 4)
+		case "RSDS":
+			if size >= 24 {
+				n.clear("CodeViewInfo.GUID", offset+4, 16)
+				n.clear("CodeViewInfo.Age", offset+20, 4)
+			}
+		}
+	}
+}
+
+// normalizeParsed updates the parsed contents of data directories with the
+// canonicalized fields.
+func (n *normalizer) normalizeParsed() {
+	zero := parseDateFromEpoch(0)
+	if n.file.Exps != nil {
+		n.file.Exps.ExpDir.Date = zero
+	}
+	for i := range n.file.Imps {
+		if n.imps[i] {
+			n.file.Imps[i].ImpDir.Date = zero
+		}
+	}
+	if n.file.Rsrcs != nil {
+		normalizeResourceDates(n.file.Rsrcs)
+	}
+	for _, dbgData := range n.file.DbgData {
+		switch dbg := dbgData.(type) {
+		case *DebugCodeView:
+			dbg.DbgDir.Date = zero
+			switch info := dbg.CodeViewInfo.(type) {
+			case *CodeViewNB10:
+				info.Date = zero
+				info.Age = 0
+			case *CodeViewRSDS:
+				info.GUID = GUID{}
+				info.Age = 0
+			}
+		case *DebugFPO:
+			dbg.DbgDir.Date = zero
+		case *DebugMisc:
+			dbg.DbgDir.Date = zero
+		}
+	}
+}
+
+// normalizeChecksum recomputes the checksum of the PE file.
+func (n *normalizer) normalizeChecksum() error {
+	file := n.file
+	offset := file.optHdrOffset() + optChecksumOffset
+	old := make([]byte, 4)
+	if _, err := file.ReadAt(old, offset); err != nil {
+		// Skip fields truncated by the end of file.
+		return nil
+	}
+	file.fixChecksum = true
+	buf, err := file.encode()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	canonical := buf[offset : offset+4]
+	n.add("OptHeader.Checksum", offset, old, canonical)
+	file.OptHdr.Checksum = binary.LittleEndian.Uint32(canonical)
+	return nil
+}
+
+// ### [ Helper functions ] ####################################################
+
+// normalizeResourceDates clears the creation time of the given resource
+// directory and its subdirectories.
+func normalizeResourceDates(dir *ResourceDirectory) {
+	dir.Date = parseDateFromEpoch(0)
+	for _, entry := range dir.Entries {
+		if entry.Dir != nil {
+			normalizeResourceDates(entry.Dir)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/mewmew/pe/enum"
+)
+
+func TestNormalize(t *testing.T) {
+	const path = "testdata/gcc-386-mingw-no-symbols-exec"
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%q: unable to read file; %v", path, err)
+	}
+	// Add a Rich header and CodeView debug information to the executable.
+	file, err := ParseBytes(content)
+	if err != nil {
+		t.Fatalf("%q: unable to parse file; %+v", path, err)
+	}
+	const (
+		richOffset  = 0x40
+		dbgDirSize  = 28
+		rsdsSize    = 24 + len("test.pdb\x00")
+		dateOffset  = 4
+		guidOffset  = dbgDirSize + 4
+		ageOffset   = dbgDirSize + 20
+		fileHdrDate = 4
+	)
+	if peOffset := file.optHdrOffset() - 24; peOffset < richOffset+0x20 {
+		t.Fatalf("%q: no room for Rich header before PE header at 0x%X", path, peOffset)
+	}
+	sectHdr, err := file.AddSection(".debug", enum.SectionFlagContainsInitializedData|enum.SectionFlagMemRead, make([]byte, dbgDirSize+rsdsSize))
+	if err != nil {
+		t.Fatalf("%q: unable to add section; %+v", path, err)
+	}
+	data := file.sectSrcs[len(file.sectSrcs)-1].data
+	binary.LittleEndian.PutUint32(data[12:], uint32(enum.DebugTypeCodeView))
+	binary.LittleEndian.PutUint32(data[16:], uint32(rsdsSize))
+	binary.LittleEndian.PutUint32(data[20:], sectHdr.RelAddr+dbgDirSize)
+	binary.LittleEndian.PutUint32(data[24:], sectHdr.DataOffset+dbgDirSize)
+	copy(data[dbgDirSize:], "RSDS")
+	copy(data[dbgDirSize+24:], "test.pdb")
+	file.DataDirs[dataDirDebug] = DataDirectory{RelAddr: sectHdr.RelAddr, Size: dbgDirSize}
+	buf := &bytes.Buffer{}
+	if _, err := file.WriteTo(buf); err != nil {
+		t.Fatalf("%q: unable to write file; %+v", path, err)
+	}
+	base := buf.Bytes()
+	imps, err := file.Imports()
+	if err != nil {
+		t.Fatalf("%q: unable to parse imports; %+v", path, err)
+	}
+	// build returns the contents of a build of the executable, with the given
+	// seed used for non-deterministic fields.
+	build := func(seed uint32) []byte {
+		b := append([]byte(nil), base...)
+		binary.LittleEndian.PutUint32(b[file.optHdrOffset()-20+fileHdrDate:], 0x5F000000+seed)
+		for i := range imps {
+			offset, _ := file.fileOffset(file.DataDirs[dataDirImport].RelAddr+uint32(i)*20+4, 4)
+			binary.LittleEndian.PutUint32(b[offset:], 0x5F000000+seed)
+		}
+		dbg := b[sectHdr.DataOffset:]
+		binary.LittleEndian.PutUint32(dbg[dateOffset:], 0x5F000000+seed)
+		for i := 0; i < 16; i++ {
+			dbg[guidOffset+i] = byte(seed) + byte(i)
+		}
+		binary.LittleEndian.PutUint32(dbg[ageOffset:], seed)
+		// Rich header of a single entry, encrypted using the seed as key.
+		rich := b[richOffset:]
+		binary.LittleEndian.PutUint32(rich[0:], 0x536E6144^seed) // "DanS"
+		for i := 4; i < 16; i += 4 {
+			binary.LittleEndian.PutUint32(rich[i:], seed)
+		}
+		binary.LittleEndian.PutUint32(rich[16:], 0x00FF0001^seed)
+		binary.LittleEndian.PutUint32(rich[20:], 1^seed)
+		copy(rich[24:], "Rich")
+		binary.LittleEndian.PutUint32(rich[28:], seed)
+		offset := file.optHdrOffset() + optChecksumOffset
+		binary.LittleEndian.PutUint32(b[offset:], checksum(b, offset))
+		return b
+	}
+	var want []byte
+	var wantFields []NormalizedField
+	for _, seed := range []uint32{0x1234, 0xABCD} {
+		name := fmt.Sprintf("seed 0x%X", seed)
+		b := build(seed)
+		file, err := ParseBytes(b)
+		if err != nil {
+			t.Errorf("%s: unable to parse file; %+v", name, err)
+			continue
+		}
+		fields, err := file.Normalize()
+		if err != nil {
+			t.Errorf("%s: unable to normalize file; %+v", name, err)
+			continue
+		}
+		buf := &bytes.Buffer{}
+		if _, err := file.WriteTo(buf); err != nil {
+			t.Errorf("%s: unable to write file; %+v", name, err)
+			continue
+		}
+		// The field report lists every non-deterministic field, with the
+		// original contents of the build.
+		var names []string
+		for _, field := range fields {
+			names = append(names, field.Name)
+			if !bytes.Equal(field.Old, b[field.Offset:field.Offset+int64(len(field.Old))]) {
+				t.Errorf("%s: original contents mismatch of field %q; expected % X, got % X", name, field.Name, b[field.Offset:field.Offset+int64(len(field.Old))], field.Old)
+			}
+		}
+		wantNames := []string{"RichHeader", "FileHeader.Date", "OptHeader.Checksum"}
+		for i := range imps {
+			wantNames = append(wantNames, fmt.Sprintf("ImportDirectory[%d].Date", i))
+		}
+		wantNames = append(wantNames, "DebugDirectory[0].Date", "CodeViewInfo.GUID", "CodeViewInfo.Age")
+		if !sameStrings(names, wantNames) {
+			t.Errorf("%s: normalized fields mismatch; expected %q, got %q", name, wantNames, names)
+		}
+		// Parsed contents are updated.
+		dbgData, err := file.Debug()
+		if err != nil || len(dbgData) != 1 {
+			t.Errorf("%s: unable to locate debug data; %v", name, err)
+		} else if dbg, ok := dbgData[0].(*DebugCodeView); !ok {
+			t.Errorf("%s: debug data type mismatch; expected *DebugCodeView, got %T", name, dbgData[0])
+		} else if info, ok := dbg.CodeViewInfo.(*CodeViewRSDS); !ok || info.GUID != (GUID{}) || info.Age != 0 || !dbg.DbgDir.Date.Equal(parseDateFromEpoch(0)) {
+			t.Errorf("%s: CodeView debug information not normalized; got %+v", name, dbg.CodeViewInfo)
+		}
+		// The outputs of both builds and the canonical fields are identical.
+		if want == nil {
+			want, wantFields = buf.Bytes(), fields
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("%s: contents mismatch of normalized builds", name)
+		}
+		if len(fields) != len(wantFields) {
+			continue
+		}
+		for i, field := range fields {
+			if field.Name != wantFields[i].Name || field.Offset != wantFields[i].Offset || !reflect.DeepEqual(field.New, wantFields[i].New) {
+				t.Errorf("%s: normalized field %d mismatch; expected %v, got %v", name, i, wantFields[i], field)
+			}
+		}
+	}
+}
+
+// sameStrings reports whether the given lists contain the same strings,
+// regardless of order.
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	m := make(map[string]int)
+	for _, s := range a {
+		m[s]++
+	}
+	for _, s := range b {
+		m[s]--
+		if m[s] < 0 {
+			return false
+		}
+	}
+	return true
+}
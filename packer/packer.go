@@ -0,0 +1,346 @@
+// Package packer detects packers and protectors of PE files.
+//
+// Detection combines section names and entry point signatures of known
+// packers, user-provided signatures in PEiD format, and generic indicators of
+// packing such as a minimal import table and high-entropy sections.
+package packer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mewmew/pe"
+	"github.com/mewmew/pe/enum"
+)
+
+// Detector detects packers and protectors of PE files.
+type Detector struct {
+	// Signatures matched in addition to the built-in rules (e.g. loaded from a
+	// PEiD signature database).
+	Signatures []Signature
+	// Entropy threshold in bits per byte above which a section is considered
+	// compressed or encrypted; DefaultEntropyThreshold if zero.
+	EntropyThreshold float64
+	// Warnings recorded while loading signature databases (e.g. skipped
+	// malformed signatures).
+	Warnings []error
+}
+
+// DefaultEntropyThreshold is the default entropy threshold in bits per byte of
+// detectors.
+const DefaultEntropyThreshold = 7.0
+
+// NewDetector returns a new detector using the built-in rules.
+func NewDetector() *Detector {
+	return &Detector{
+		EntropyThreshold: DefaultEntropyThreshold,
+	}
+}
+
+// EvidenceKind specifies the kind of evidence of a detection.
+type EvidenceKind uint8
+
+// Evidence kinds.
+const (
+	// Section name characteristic of a packer.
+	EvidenceSectionName EvidenceKind = iota + 1
+	// Byte signature matched at the entry point.
+	EvidenceEPSignature
+	// Byte signature matched elsewhere in the file.
+	EvidenceSignature
+	// Import table of minimal shape (e.g. only LoadLibrary and GetProcAddress).
+	EvidenceImports
+	// Section with high entropy.
+	EvidenceEntropy
+	// Entry point located in unusual section (e.g. last or writable section).
+	EvidenceEntryPoint
+)
+
+// String returns the string representation of the evidence kind.
+func (kind EvidenceKind) String() string {
+	switch kind {
+	case EvidenceSectionName:
+		return "section name"
+	case EvidenceEPSignature:
+		return "entry point signature"
+	case EvidenceSignature:
+		return "signature"
+	case EvidenceImports:
+		return "imports"
+	case EvidenceEntropy:
+		return "entropy"
+	case EvidenceEntryPoint:
+		return "entry point"
+	default:
+		return "unknown"
+	}
+}
+
+// Evidence is a piece of evidence of a detection.
+type Evidence struct {
+	// Evidence kind.
+	Kind EvidenceKind
+	// Human-readable description (e.g. `section "UPX0"`).
+	Desc string
+}
+
+// String returns the string representation of the evidence.
+func (e Evidence) String() string {
+	return fmt.Sprintf("%v: %s", e.Kind, e.Desc)
+}
+
+// Match is a detected packer or protector.
+type Match struct {
+	// Name of the packer or protector (e.g. "UPX"), or of the matched
+	// signature.
+	Name string
+	// Evidence of the detection.
+	Evidence []Evidence
+}
+
+// Result is the result of packer detection.
+type Result struct {
+	// Detected packers and protectors.
+	Matches []Match
+	// Generic indicators of packing, regardless of packer.
+	Indicators []Evidence
+}
+
+// Packed reports whether the PE file is likely packed; either a packer was
+// detected or at least two generic indicators of packing are present. Note that
+// user signatures may identify compilers rather than packers.
+func (result *Result) Packed() bool {
+	return len(result.Matches) > 0 || len(result.Indicators) >= 2
+}
+
+// Detect detects the packers and protectors of the given PE file.
+func (d *Detector) Detect(file *pe.File) *Result {
+	result := &Result{}
+	ep := entryPointData(file, d.maxSigLen())
+	// Built-in rules.
+	for _, rule := range rules {
+		var evidence []Evidence
+		for _, sectHdr := range file.SectHdrs {
+			for _, sectName := range rule.sectNames {
+				if sectHdr.Name == sectName {
+					evidence = append(evidence, Evidence{Kind: EvidenceSectionName, Desc: fmt.Sprintf("section %q", sectHdr.Name)})
+				}
+			}
+		}
+		for _, sig := range rule.sigs {
+			if sig.Match(ep) {
+				evidence = append(evidence, Evidence{Kind: EvidenceEPSignature, Desc: "matched at entry point"})
+				break
+			}
+		}
+		if len(evidence) > 0 {
+			result.Matches = append(result.Matches, Match{Name: rule.name, Evidence: evidence})
+		}
+	}
+	// User signatures.
+	var fileSigs []Signature
+	for _, sig := range d.Signatures {
+		switch {
+		case sig.EPOnly:
+			if sig.Match(ep) {
+				evidence := Evidence{Kind: EvidenceEPSignature, Desc: "matched at entry point"}
+				result.Matches = append(result.Matches, Match{Name: sig.Name, Evidence: []Evidence{evidence}})
+			}
+		case sig.SectionStartOnly:
+			if sectName, offset, ok := matchSectionStart(sig, file); ok {
+				evidence := Evidence{Kind: EvidenceSignature, Desc: fmt.Sprintf("matched at start of section %q (file offset 0x%X)", sectName, offset)}
+				result.Matches = append(result.Matches, Match{Name: sig.Name, Evidence: []Evidence{evidence}})
+			}
+		default:
+			fileSigs = append(fileSigs, sig)
+		}
+	}
+	// Locate the remaining signatures in a single pass over the file.
+	for i, offset := range indexFile(fileSigs, file) {
+		if offset == -1 {
+			continue
+		}
+		evidence := Evidence{Kind: EvidenceSignature, Desc: fmt.Sprintf("matched at file offset 0x%X", offset)}
+		result.Matches = append(result.Matches, Match{Name: fileSigs[i].Name, Evidence: []Evidence{evidence}})
+	}
+	// Generic indicators.
+	result.Indicators = append(result.Indicators, importIndicators(file)...)
+	result.Indicators = append(result.Indicators, d.entropyIndicators(file)...)
+	result.Indicators = append(result.Indicators, entryPointIndicators(file)...)
+	return result
+}
+
+// maxSigLen returns the maximum length of entry point signatures.
+func (d *Detector) maxSigLen() int {
+	n := 64
+	for _, sig := range d.Signatures {
+		if sig.EPOnly && len(sig.Pattern) > n {
+			n = len(sig.Pattern)
+		}
+	}
+	return n
+}
+
+// entryPointData returns up to n bytes of the in-memory image at the entry
+// point of the given PE file.
+func entryPointData(file *pe.File, n int) []byte {
+	if file.OptHdr == nil || file.OptHdr.EntryRelAddr >= file.OptHdr.ImageSize {
+		return nil
+	}
+	if max := int(file.OptHdr.ImageSize - file.OptHdr.EntryRelAddr); n > max {
+		n = max
+	}
+	data, err := file.ReadImage(file.OptHdr.EntryRelAddr, int64(n))
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// indexFile returns the file offset of the first match of each signature in
+// the contents of the given PE file, or -1 if not present. The contents are
+// read once in chunks, to support large files opened with pe.NewFile.
+func indexFile(sigs []Signature, file *pe.File) []int64 {
+	offsets := make([]int64, len(sigs))
+	if len(sigs) == 0 {
+		return offsets
+	}
+	maxLen := 0
+	for i, sig := range sigs {
+		offsets[i] = -1
+		if len(sig.Pattern) > maxLen {
+			maxLen = len(sig.Pattern)
+		}
+	}
+	const chunkSize = 1 << 20
+	// Consecutive chunks overlap, to locate matches crossing chunk boundaries.
+	overlap := int64(maxLen - 1)
+	buf := make([]byte, chunkSize+overlap)
+	remaining := len(sigs)
+	for offset := int64(0); offset < file.Size() && remaining > 0; offset += chunkSize {
+		n, _ := file.ReadAt(buf, offset)
+		for i, sig := range sigs {
+			if offsets[i] != -1 {
+				continue
+			}
+			if pos := sig.Index(buf[:n]); pos != -1 {
+				offsets[i] = offset + int64(pos)
+				remaining--
+			}
+		}
+	}
+	return offsets
+}
+
+// matchSectionStart reports whether the signature matches the start of the raw
+// data of a section of the given PE file, and returns the name and file offset
+// of the first such section.
+func matchSectionStart(sig Signature, file *pe.File) (string, int64, bool) {
+	buf := make([]byte, len(sig.Pattern))
+	for _, sectHdr := range file.SectHdrs {
+		if sectHdr.DataSize == 0 {
+			continue
+		}
+		offset := int64(sectHdr.DataOffset)
+		n, _ := file.ReadAt(buf, offset)
+		if sig.Match(buf[:n]) {
+			return sectHdr.Name, offset, true
+		}
+	}
+	return "", 0, false
+}
+
+// importIndicators returns indicators of packing based on the shape of the
+// import table; packers typically import a handful of functions to locate the
+// imports of the unpacked executable at runtime.
+func importIndicators(file *pe.File) []Evidence {
+	imps, err := file.Imports()
+	if err != nil {
+		return []Evidence{{Kind: EvidenceImports, Desc: "malformed import table"}}
+	}
+	nsyms := 0
+	loader := false
+	for _, imp := range imps {
+		ints := imp.INTs
+		if len(ints) == 0 {
+			ints = imp.IATs
+		}
+		nsyms += len(ints)
+		for _, intEntry := range ints {
+			if intEntry.IsOrdinal {
+				continue
+			}
+			switch intEntry.NameEntry.Name {
+			case "GetProcAddress", "LoadLibraryA", "LoadLibraryW", "LoadLibraryExA", "LoadLibraryExW", "GetModuleHandleA", "GetModuleHandleW":
+				loader = true
+			}
+		}
+	}
+	switch {
+	case len(imps) == 0:
+		return []Evidence{{Kind: EvidenceImports, Desc: "no imports"}}
+	case nsyms <= 2*len(imps) && loader && nsyms < 20:
+		// At most two symbols per DLL, including dynamic loading functions.
+		return []Evidence{{Kind: EvidenceImports, Desc: fmt.Sprintf("%d symbols imported from %d DLLs, including dynamic loading functions", nsyms, len(imps))}}
+	}
+	return nil
+}
+
+// entropyIndicators returns indicators of packing based on the entropy of
+// sections.
+func (d *Detector) entropyIndicators(file *pe.File) []Evidence {
+	threshold := d.EntropyThreshold
+	if threshold == 0 {
+		threshold = DefaultEntropyThreshold
+	}
+	var indicators []Evidence
+	for i, stats := range file.SectionStats() {
+		// Ignore small sections, which have unreliable entropy.
+		if stats.Size < 512 {
+			continue
+		}
+		if stats.Entropy > threshold {
+			sectHdr := file.SectHdrs[i]
+			// Resources are commonly compressed (e.g. PNG icons), as are
+			// discardable debug sections (e.g. compressed DWARF).
+			if sectHdr.Name == ".rsrc" || sectHdr.Flags&enum.SectionFlagMemDiscardable != 0 {
+				continue
+			}
+			indicators = append(indicators, Evidence{Kind: EvidenceEntropy, Desc: fmt.Sprintf("section %q with entropy %.2f", sectHdr.Name, stats.Entropy)})
+		}
+	}
+	return indicators
+}
+
+// entryPointIndicators returns indicators of packing based on the section
+// containing the entry point.
+func entryPointIndicators(file *pe.File) []Evidence {
+	if file.OptHdr == nil || file.OptHdr.EntryRelAddr == 0 || len(file.SectHdrs) == 0 {
+		return nil
+	}
+	ep := file.OptHdr.EntryRelAddr
+	for i, sectHdr := range file.SectHdrs {
+		size := sectHdr.VirtualSize
+		if size == 0 {
+			size = sectHdr.DataSize
+		}
+		if !(sectHdr.RelAddr <= ep && uint64(ep) < uint64(sectHdr.RelAddr)+uint64(size)) {
+			continue
+		}
+		var reasons []string
+		if sectHdr.Flags&enum.SectionFlagMemWrite != 0 {
+			reasons = append(reasons, "writable")
+		}
+		if sectHdr.Flags&enum.SectionFlagMemExecute == 0 && sectHdr.Flags&enum.SectionFlagContainsCode == 0 {
+			reasons = append(reasons, "non-executable")
+		}
+		if i == len(file.SectHdrs)-1 && i > 0 {
+			reasons = append(reasons, "last")
+		}
+		if len(reasons) == 0 {
+			return nil
+		}
+		return []Evidence{{Kind: EvidenceEntryPoint, Desc: fmt.Sprintf("entry point in %s section %q", strings.Join(reasons, ", "), sectHdr.Name)}}
+	}
+	return []Evidence{{Kind: EvidenceEntryPoint, Desc: "entry point outside of sections"}}
+}
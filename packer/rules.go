@@ -0,0 +1,89 @@
+package packer
+
+import "fmt"
+
+// rule is a built-in detection rule of a packer or protector.
+type rule struct {
+	// Packer name.
+	name string
+	// Section names characteristic of the packer.
+	sectNames []string
+	// Entry point signatures of the packer, specified as byte patterns.
+	epSigs []string
+	// Parsed entry point signatures.
+	sigs []Signature
+}
+
+func init() {
+	// Parse entry point signatures of built-in rules.
+	for i := range rules {
+		rule := &rules[i]
+		for _, pattern := range rule.epSigs {
+			sig, err := ParseSignature(rule.name, pattern, true)
+			if err != nil {
+				panic(fmt.Errorf("invalid built-in signature of %s; %v", rule.name, err))
+			}
+			rule.sigs = append(rule.sigs, sig)
+		}
+	}
+}
+
+// rules specifies the built-in detection rules.
+var rules = []rule{
+	{
+		name:      "UPX",
+		sectNames: []string{"UPX0", "UPX1", "UPX2", "UPX!"},
+		epSigs: []string{
+			// PE32: pushad; mov esi, ...; lea edi, [esi-...]
+			"60 BE ?? ?? ?? ?? 8D BE ?? ?? ?? ??",
+			// PE32+: push rbx; push rsi; push rdi; push rbp; lea rsi, ...; lea rdi, [rsi-...]
+			"53 56 57 55 48 8D 35 ?? ?? ?? ?? 48 8D BE ?? ?? ?? ??",
+			// DLL: cmp byte [esp+8], 1; jnz ...; pushad; mov esi, ...
+			"80 7C 24 08 01 0F 85 ?? ?? ?? ?? 60 BE ?? ?? ?? ??",
+		},
+	},
+	{
+		name:      "ASPack",
+		sectNames: []string{".aspack", ".adata", "ASPack"},
+		epSigs: []string{
+			// pushad; call $+8; jmp ...
+			"60 E8 03 00 00 00 E9 EB 04 5D 45 55 C3 E8 01",
+			"60 E8 00 00 00 00 5D 81 ED ?? ?? ?? ?? B8 ?? ?? ?? ?? 03 C5",
+		},
+	},
+	{
+		name:      "MPRESS",
+		sectNames: []string{".MPRESS1", ".MPRESS2"},
+		epSigs: []string{
+			// pushad; call $+5; pop eax; add eax, ...
+			"60 E8 00 00 00 00 58 05 ?? ?? ?? ?? 8B 30 03 F0",
+			// PE32+: push rdi; push rsi; ...; lea rax, ...; mov rsi, [rax]; add rsi, rax
+			"57 56 53 51 52 41 50 48 8D 05 ?? ?? ?? ?? 48 8B 30 48 03 F0",
+		},
+	},
+	{
+		name:      "Themida",
+		sectNames: []string{".themida", ".winlice", "Themida", "WinLicen"},
+		epSigs: []string{
+			// mov eax, 0; pushad; or eax, eax; jz ...
+			"B8 00 00 00 00 60 0B C0 74 68 E8 00 00 00 00 58 05",
+		},
+	},
+	{
+		name:      "VMProtect",
+		sectNames: []string{".vmp0", ".vmp1", ".vmp2"},
+	},
+	{
+		name:      "PECompact",
+		sectNames: []string{"PEC2", "PEC2TO", "PEC2MO", "PECompact2", "pec1", "pec2"},
+		epSigs: []string{
+			// mov eax, ...; push eax; push dword fs:[0]; mov fs:[0], esp; xor eax, eax; mov [eax], ecx
+			"B8 ?? ?? ?? ?? 50 64 FF 35 00 00 00 00 64 89 25 00 00 00 00 33 C0 89 08 50 45 43 6F 6D 70 61 63 74 32",
+			"EB 06 68 ?? ?? ?? ?? C3 9C 60 E8 02 00 00 00",
+		},
+	},
+	{
+		name:      "Enigma",
+		sectNames: []string{".enigma1", ".enigma2"},
+	},
+}
@@ -0,0 +1,202 @@
+package packer
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Signature is a byte signature of a packer or compiler, as stored in PEiD
+// signature databases.
+type Signature struct {
+	// Name of the signature (e.g. "UPX 2.90 -> Markus Oberhumer").
+	Name string
+	// Byte pattern of the signature. Bits of each byte are only compared where
+	// the corresponding bits of Mask are set.
+	Pattern []byte
+	// Mask of the pattern; wildcard nibbles ("?") are zero.
+	Mask []byte
+	// Specifies whether the signature is only matched at the entry point, or
+	// anywhere in the file.
+	EPOnly bool
+	// Specifies whether the signature is only matched at the start of the raw
+	// data of sections; ignored if EPOnly is set.
+	SectionStartOnly bool
+}
+
+// Match reports whether the signature matches the start of data.
+func (sig Signature) Match(data []byte) bool {
+	if len(data) < len(sig.Pattern) {
+		return false
+	}
+	for i, b := range sig.Pattern {
+		if data[i]&sig.Mask[i] != b&sig.Mask[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Index returns the offset of the first match of the signature in data, or -1
+// if not present.
+func (sig Signature) Index(data []byte) int {
+	if len(sig.Pattern) == 0 {
+		return -1
+	}
+	// Locate candidates using the longest literal prefix of the pattern.
+	n := 0
+	for n < len(sig.Mask) && sig.Mask[n] == 0xFF {
+		n++
+	}
+	prefix := sig.Pattern[:n]
+	for offset := 0; offset+len(sig.Pattern) <= len(data); offset++ {
+		if n > 0 {
+			pos := bytes.Index(data[offset:], prefix)
+			if pos == -1 {
+				return -1
+			}
+			offset += pos
+		}
+		if sig.Match(data[offset:]) {
+			return offset
+		}
+	}
+	return -1
+}
+
+// ParseSignature parses the given byte pattern of a signature, specified as
+// space-separated hexadecimal bytes where "?" denotes a wildcard nibble (e.g.
+// "60 BE ?? ?? ?? ?? 8D BE").
+func ParseSignature(name, pattern string, epOnly bool) (Signature, error) {
+	sig := Signature{
+		Name:   name,
+		EPOnly: epOnly,
+	}
+	for _, field := range strings.Fields(pattern) {
+		if len(field) != 2 {
+			return Signature{}, errors.Errorf("invalid byte %q of signature %q; expected two hexadecimal digits", field, name)
+		}
+		var b, mask byte
+		for _, c := range field {
+			b <<= 4
+			mask <<= 4
+			if c == '?' {
+				continue
+			}
+			x, err := strconv.ParseUint(string(c), 16, 8)
+			if err != nil {
+				return Signature{}, errors.Errorf("invalid byte %q of signature %q; expected two hexadecimal digits", field, name)
+			}
+			b |= byte(x)
+			mask |= 0xF
+		}
+		sig.Pattern = append(sig.Pattern, b)
+		sig.Mask = append(sig.Mask, mask)
+	}
+	if len(sig.Pattern) == 0 {
+		return Signature{}, errors.Errorf("invalid signature %q; empty byte pattern", name)
+	}
+	return sig, nil
+}
+
+// ParseUserDB parses the given signature database in PEiD userdb.txt format.
+// Malformed entries are skipped, and reported in the returned list of
+// warnings.
+//
+// Example:
+//
+//	; comment
+//	[UPX 2.90 -> Markus Oberhumer]
+//	signature = 60 BE ?? ?? ?? ?? 8D BE ?? ?? ?? ?? 57 83 CD FF
+//	ep_only = true
+func ParseUserDB(r io.Reader) ([]Signature, []error, error) {
+	var sigs []Signature
+	var warnings []error
+	var name, pattern string
+	epOnly, sectionStartOnly := false, false
+	// First error of the current entry, if malformed.
+	var entryErr error
+	lineNum := 0
+	// flush records the current entry, if any.
+	flush := func() {
+		defer func() {
+			name, pattern, epOnly, sectionStartOnly, entryErr = "", "", false, false, nil
+		}()
+		if entryErr != nil {
+			warnings = append(warnings, errors.Wrapf(entryErr, "skipping signature %q ending at line %d", name, lineNum))
+			return
+		}
+		if len(name) == 0 && len(pattern) == 0 {
+			return
+		}
+		sig, err := ParseSignature(name, pattern, epOnly)
+		if err != nil {
+			warnings = append(warnings, errors.Wrapf(err, "skipping invalid signature ending at line %d", lineNum))
+			return
+		}
+		sig.SectionStartOnly = sectionStartOnly
+		sigs = append(sigs, sig)
+	}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		lineNum++
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case len(line) == 0, strings.HasPrefix(line, ";"):
+			// Skip empty lines and comments.
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			flush()
+			name = line[1 : len(line)-1]
+		default:
+			pos := strings.Index(line, "=")
+			if pos == -1 {
+				if entryErr == nil {
+					entryErr = errors.Errorf("invalid line %d of signature database; expected key = value, got %q", lineNum, line)
+				}
+				continue
+			}
+			key := strings.ToLower(strings.TrimSpace(line[:pos]))
+			val := strings.TrimSpace(line[pos+1:])
+			switch key {
+			case "signature":
+				pattern = val
+			case "ep_only":
+				epOnly = strings.EqualFold(val, "true")
+			case "section_start_only":
+				sectionStartOnly = strings.EqualFold(val, "true")
+			default:
+				// Ignore unknown keys.
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	flush()
+	return sigs, warnings, nil
+}
+
+// LoadUserDB adds the signatures of the given signature database in PEiD
+// userdb.txt format to the detector. Malformed entries are skipped, and
+// recorded in the warnings of the detector.
+func (d *Detector) LoadUserDB(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+	sigs, warnings, err := ParseUserDB(f)
+	if err != nil {
+		return errors.Wrapf(err, "unable to parse signature database %q", path)
+	}
+	for _, warning := range warnings {
+		d.Warnings = append(d.Warnings, errors.Wrapf(warning, "signature database %q", path))
+	}
+	d.Signatures = append(d.Signatures, sigs...)
+	return nil
+}
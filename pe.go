@@ -7,9 +7,9 @@ package pe
 import (
 	"bytes"
 	"encoding/binary"
-	"fmt"
 	"io"
 	"io/ioutil"
+	"unicode/utf16"
 
 	"github.com/mewmew/pe/enum"
 	"github.com/mewmew/pe/internal/pe"
@@ -36,7 +36,63 @@ func Parse(r io.Reader) (*File, error) {
 
 // ParseBytes parses the given PE file, reading from content.
 func ParseBytes(content []byte) (*File, error) {
-	return parse(content)
+	file := &File{
+		Content: content,
+	}
+	return parse(file, bytes.NewReader(content))
+}
+
+// NewFile parses the given PE file of size bytes, reading from r.
+//
+// Contrary to Parse, the file contents are not loaded into memory, and only the
+// headers are parsed up front; the contents of each data directory are read
+// from r and parsed on first use (e.g. by Imports or Exports), and section data
+// is read on demand (see SectionReader). Content of the returned file is nil.
+func NewFile(r io.ReaderAt, size int64) (*File, error) {
+	file := &File{
+		r:    r,
+		size: size,
+	}
+	if err := parseHeaders(file, io.NewSectionReader(r, 0, size)); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return file, nil
+}
+
+// ParseMapped parses the given in-memory image of a PE file, as mapped by the
+// Windows loader (e.g. a module recovered from a process dump).
+//
+// Contrary to the on-disk layout, sections are located by relative address
+// rather than file offset, and import address tables contain the resolved
+// addresses of imported symbols (see ImportEntry.IATAddrs).
+//
+// Only the headers are parsed up front, as parts of the image may be paged out
+// or overwritten in memory; the contents of each data directory are parsed on
+// first use (e.g. by Imports or Exports).
+func ParseMapped(image []byte) (*File, error) {
+	file := &File{
+		Content: image,
+		mapped:  true,
+	}
+	if err := parseHeaders(file, bytes.NewReader(image)); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return file, nil
+}
+
+// NewMappedFile parses the given in-memory image of a PE file of size bytes,
+// reading from r. The image is read on demand, as with NewFile; see
+// ParseMapped for details on the mapped layout.
+func NewMappedFile(r io.ReaderAt, size int64) (*File, error) {
+	file := &File{
+		r:      r,
+		size:   size,
+		mapped: true,
+	}
+	if err := parseHeaders(file, io.NewSectionReader(r, 0, size)); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return file, nil
 }
 
 // reader is the interface that groups the basic Read, ReadAt and Seek methods.
@@ -49,44 +105,70 @@ type reader interface {
 // PE signature.
 var signature = []byte("PE\x00\x00")
 
-// parse parses the given PE file, reading from content.
-func parse(content []byte) (*File, error) {
-	file := &File{
-		Content: content,
+// parse parses the given PE file, reading from r.
+func parse(file *File, r reader) (*File, error) {
+	if err := parseHeaders(file, r); err != nil {
+		return nil, errors.WithStack(err)
 	}
-	r := bytes.NewReader(content)
+	// Parse contents of data directories.
+	if err := file.parseDataDirsContent(r); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return file, nil
+}
+
+// parseHeaders parses the headers of the given PE file, reading from r.
+func parseHeaders(file *File, r reader) error {
 	// Parse COFF file header.
 	fileHdr, err := parseFileHeader(r)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return errors.WithStack(err)
 	}
 	file.FileHdr = fileHdr
 	// Parse optional header.
+	optHdrOffset, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	file.optHdrOff = optHdrOffset
 	optHdr, err := parseOptHeader(r)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return errors.WithStack(err)
 	}
 	file.OptHdr = optHdr
 	// Parse data directories.
 	dataDirs, err := file.parseDataDirs(r)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return errors.WithStack(err)
 	}
 	file.DataDirs = dataDirs
 	// Parse section headers.
 	//
+	// The section table is located directly after the optional header, the
+	// size of which is specified by the file header; regardless of the number
+	// of data directories.
+	//
 	// After parsing the section headers, we may read data using relative
 	// addresses (relative to image base).
+	sectTableOffset := optHdrOffset + int64(fileHdr.OptHdrSize)
+	if dataDirsEnd, err := r.Seek(0, io.SeekCurrent); err == nil && dataDirsEnd > sectTableOffset {
+		file.warnf(optHdrOffset, "data directories (end offset 0x%X) extend past optional header size %d", dataDirsEnd, fileHdr.OptHdrSize)
+	}
+	if _, err := r.Seek(sectTableOffset, io.SeekStart); err != nil {
+		return errors.WithStack(err)
+	}
 	sectHdrs, err := file.parseSectionHdrs(r)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return errors.WithStack(err)
 	}
 	file.SectHdrs = sectHdrs
-	// Parse contents of data directories.
-	if err := file.parseDataDirsContent(r); err != nil {
-		return nil, errors.WithStack(err)
-	}
-	return file, nil
+	file.sectTableLen = len(sectHdrs)
+	file.checkDataDirs()
+	// Record end offset of the headers and section contents as parsed, to
+	// locate the overlay when writing.
+	file.origEnd = int64(file.layoutEnd())
+	file.nhdrWarnings = len(file.Warnings)
+	return nil
 }
 
 // parseFileHeader parses the COFF file header of the given PE file.
@@ -150,107 +232,271 @@ func parseOptHeader(r reader) (*OptHeader, error) {
 	}
 }
 
+// maxDataDirs specifies the maximum number of data directories used by the
+// Windows loader.
+const maxDataDirs = 16
+
 // parseDataDirs parses the data directories of the given PE file.
+//
+// At most 16 data directories are parsed, as done by the Windows loader. Data
+// directories truncated by the end of file are omitted.
 func (file *File) parseDataDirs(r reader) ([]DataDirectory, error) {
-	dataDirs := make([]DataDirectory, file.OptHdr.NDataDirs)
-	for idx := range dataDirs {
-		if err := binary.Read(r, binary.LittleEndian, &dataDirs[idx]); err != nil {
+	offset, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	n := file.OptHdr.NDataDirs
+	if n > maxDataDirs {
+		file.warnf(offset-4, "number of data directories %d exceeds %d; using %d", n, maxDataDirs, maxDataDirs)
+		n = maxDataDirs
+	}
+	var dataDirs []DataDirectory
+	for idx := 0; idx < int(n); idx++ {
+		var dataDir DataDirectory
+		if err := binary.Read(r, binary.LittleEndian, &dataDir); err != nil {
+			if isEOF(err) {
+				file.warnf(offset+int64(idx)*8, "data directories truncated by end of file; parsed %d of %d", idx, n)
+				break
+			}
 			return nil, errors.WithStack(err)
 		}
+		dataDirs = append(dataDirs, dataDir)
 	}
 	return dataDirs, nil
 }
 
 // parseSectionHdrs parses the section headers of the given PE file.
+//
+// Section headers truncated by the end of file are omitted.
 func (file *File) parseSectionHdrs(r reader) ([]SectionHeader, error) {
+	offset, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 	var sectHdrs []SectionHeader
 	for i := 0; i < int(file.FileHdr.NSections); i++ {
 		var raw pe.RawSectionHeader
 		if err := binary.Read(r, binary.LittleEndian, &raw); err != nil {
+			if isEOF(err) {
+				file.warnf(offset+int64(i)*40, "section table truncated by end of file; parsed %d of %d section headers", i, file.FileHdr.NSections)
+				break
+			}
 			return nil, errors.WithStack(err)
 		}
+		file.sectSrcs = append(file.sectSrcs, sectionSource{orig: &raw})
 		sectHdrs = append(sectHdrs, goSectionHeader(raw))
 	}
 	return sectHdrs, nil
 }
 
+// checkDataDirs records warnings for malformed data directories, as located by
+// the headers. The warnings are recorded while parsing headers, so that they
+// are independent of which data directories have their contents parsed.
+func (file *File) checkDataDirs() {
+	zero := DataDirectory{}
+	for idx, dataDir := range file.DataDirs {
+		switch {
+		case dataDir == zero:
+			// Skip empty data directories.
+		case idx == 15:
+			file.warnf(file.dataDirOffset(idx), "reserved data directory %d is non-zero; skipped", idx)
+		case !file.dataDirInSections(idx):
+			file.warnf(file.dataDirOffset(idx), "data directory %d at relative address 0x%08X outside of sections; skipped", idx, dataDir.RelAddr)
+		}
+	}
+}
+
+// dataDirInSections reports whether the contents of the data directory with the
+// given index may be parsed, based on its location. Data directories not
+// located within sections are skipped (e.g. when the section table is
+// truncated). The import tables are read from the in-memory image, and may be
+// located in the headers; the certificate table is located by file offset.
+func (file *File) dataDirInSections(idx int) bool {
+	switch idx {
+	case 1, 4, 11, 12:
+		return true
+	default:
+		return file.sectionAt(file.DataDirs[idx].RelAddr) != nil
+	}
+}
+
 // parseDataDirsContent parses the contents of the data directories.
 func (file *File) parseDataDirsContent(r reader) error {
-	for idx, dataDir := range file.DataDirs {
-		zero := DataDirectory{}
-		if dataDir == zero {
-			continue
+	for idx := range file.DataDirs {
+		if err := file.parseDataDir(idx); err != nil {
+			return errors.WithStack(err)
 		}
-		switch idx {
-		case 0:
-			// Export Table
-			panic(fmt.Errorf("support for data directory index %d not yet implemented", idx))
-		case 1:
-			// Import Table
-			imps, err := file.parseImports(dataDir)
-			if err != nil {
-				return errors.WithStack(err)
-			}
-			file.Imps = imps
-		case 2:
-			// Resource Table
-			resources, err := file.parseResources(dataDir)
-			if err != nil {
-				return errors.WithStack(err)
+	}
+	return nil
+}
+
+// parseDataDir parses the contents of the data directory with the given index,
+// and records it as parsed.
+func (file *File) parseDataDir(idx int) error {
+	dataDir := file.DataDirs[idx]
+	zero := DataDirectory{}
+	if dataDir == zero {
+		file.markParsed(idx)
+		return nil
+	}
+	// Skip data directories not located within sections; reported by
+	// checkDataDirs.
+	if !file.dataDirInSections(idx) {
+		file.markParsed(idx)
+		return nil
+	}
+	switch idx {
+	case 0:
+		// Export Table
+		exps, err := file.parseExports(dataDir)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		file.Exps = exps
+	case 1:
+		// Import Table
+		imps, err := file.parseImports(dataDir)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		file.Imps = imps
+	case 2:
+		// Resource Table
+		resources, err := file.parseResources(dataDir)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		file.Rsrcs = resources
+	case 3:
+		// Exception Table
+		//
+		// not yet supported; skipped.
+	case 4:
+		// Certificate Table
+		//
+		// not yet supported; skipped.
+	case 5:
+		// Base Relocation Table
+		baseRelocBlocks, err := file.parseBaseRelocBlocks(dataDir)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		file.BaseRelocBlocks = baseRelocBlocks
+	case 6:
+		// Debug data
+		dbgData, err := file.parseDebugData(dataDir)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		file.DbgData = dbgData
+	case 7:
+		// Architecture
+		//
+		// not yet supported; skipped.
+	case 8:
+		// Global Pointer Register
+		//
+		// not yet supported; skipped.
+	case 9:
+		// TLS Table
+		//
+		// not yet supported; skipped.
+	case 10:
+		// Load Config Table
+		//
+		// not yet supported; skipped.
+	case 11:
+		// Bound Import Table
+		boundImps, err := file.parseBoundImports(dataDir)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		file.BoundImps = boundImps
+	case 12:
+		// Import Address Table
+		// already handled when parsing import table.
+	case 13:
+		// Delay Import Descriptor
+		delayImps, err := file.parseDelayImports(dataDir)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		file.DelayImps = delayImps
+	case 14:
+		// CLR Header
+		//
+		// not yet supported; skipped.
+	case 15:
+		// Reserved
+		//
+		// skipped; reported by checkDataDirs if non-zero.
+	default:
+		// Ignore data directories beyond the 16 defined by the PE format, as
+		// done by the Windows loader.
+	}
+	file.markParsed(idx)
+	return nil
+}
+
+// --- [ 0 - Export Table ] ----------------------------------------------------
+
+// parseExports parses the export table of the given data directory.
+func (file *File) parseExports(dataDir DataDirectory) (*ExportTable, error) {
+	// Parse export data directory.
+	const rawSize = 40
+	buf, err := file.ReadImage(dataDir.RelAddr, rawSize)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	r := bytes.NewReader(buf)
+	var raw pe.RawExportDirectory
+	if err := binary.Read(r, binary.LittleEndian, &raw); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	expDir := file.goExportDirectory(raw)
+	exps := &ExportTable{
+		ExpDir: expDir,
+	}
+	// Parse export address table.
+	if expDir.NFuncs > 0 {
+		eatBuf, err := file.ReadImage(expDir.FuncsRelAddr, int64(expDir.NFuncs)*4)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for i := uint32(0); i < expDir.NFuncs; i++ {
+			relAddr := binary.LittleEndian.Uint32(eatBuf[i*4:])
+			eat := EATEntry{
+				Ordinal: uint16(expDir.OrdinalBase + i),
+				RelAddr: relAddr,
 			}
-			file.Resources = resources
-		case 3:
-			// Exception Table
-			panic(fmt.Errorf("support for data directory index %d not yet implemented", idx))
-		case 4:
-			// Certificate Table
-			panic(fmt.Errorf("support for data directory index %d not yet implemented", idx))
-		case 5:
-			// Base Relocation Table
-			baseRelocBlocks, err := file.parseBaseRelocBlocks(dataDir)
-			if err != nil {
-				return errors.WithStack(err)
+			// Exported symbols located within the export data directory are
+			// forwarders.
+			if dataDir.RelAddr <= relAddr && relAddr < dataDir.RelAddr+dataDir.Size {
+				eat.Forwarder = file.parseCString(file.OptHdr.ImageBase + uint64(relAddr))
 			}
-			file.BaseRelocBlocks = baseRelocBlocks
-		case 6:
-			// Debug data
-			dbgData, err := file.parseDebugData(dataDir)
-			if err != nil {
-				return errors.WithStack(err)
+			exps.EATs = append(exps.EATs, eat)
+		}
+	}
+	// Parse export name pointer table and export ordinal table.
+	if expDir.NNames > 0 {
+		namesBuf, err := file.ReadImage(expDir.NamesRelAddr, int64(expDir.NNames)*4)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		ordinalsBuf, err := file.ReadImage(expDir.OrdinalsRelAddr, int64(expDir.NNames)*2)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for i := uint32(0); i < expDir.NNames; i++ {
+			nameRelAddr := binary.LittleEndian.Uint32(namesBuf[i*4:])
+			name := ExportName{
+				Name:     file.parseCString(file.OptHdr.ImageBase + uint64(nameRelAddr)),
+				EATIndex: binary.LittleEndian.Uint16(ordinalsBuf[i*2:]),
 			}
-			file.DbgData = dbgData
-		case 7:
-			// Architecture
-			panic(fmt.Errorf("support for data directory index %d not yet implemented", idx))
-		case 8:
-			// Global Pointer Register
-			panic(fmt.Errorf("support for data directory index %d not yet implemented", idx))
-		case 9:
-			// TLS Table
-			panic(fmt.Errorf("support for data directory index %d not yet implemented", idx))
-		case 10:
-			// Load Config Table
-			panic(fmt.Errorf("support for data directory index %d not yet implemented", idx))
-		case 11:
-			// Bound Import Table
-			panic(fmt.Errorf("support for data directory index %d not yet implemented", idx))
-		case 12:
-			// Import Address Table
-			// already handled when parsing import table.
-		case 13:
-			// Delay Import Descriptor
-			panic(fmt.Errorf("support for data directory index %d not yet implemented", idx))
-		case 14:
-			// CLR Header
-			panic(fmt.Errorf("support for data directory index %d not yet implemented", idx))
-		case 15:
-			// Reserved
-			panic(fmt.Errorf("support for data directory index %d not yet implemented", idx))
-		default:
-			panic(fmt.Errorf("invalid data directory index; expected < 16, got %d", idx))
+			exps.Names = append(exps.Names, name)
 		}
 	}
-	return nil
+	return exps, nil
 }
 
 // --- [ 1 - Import Table ] ----------------------------------------------------
@@ -273,17 +519,22 @@ func (file *File) parseImports(dataDir DataDirectory) ([]ImportEntry, error) {
 }
 
 // parseImportDirs parses the import data directories.
+//
+// The size of the data directory is ignored, as done by the Windows loader;
+// import directories are read until the zero terminator or the end of the
+// image.
 func (file *File) parseImportDirs(dataDir DataDirectory) ([]ImportDirectory, error) {
-	addr := file.OptHdr.ImageBase + uint64(dataDir.RelAddr)
-	buf := file.ReadData(addr, int64(dataDir.Size))
-	r := bytes.NewReader(buf)
+	const rawSize = 20
 	var impDirs []ImportDirectory
-	for {
+	for relAddr := dataDir.RelAddr; ; relAddr += rawSize {
+		buf, err := file.ReadImage(relAddr, rawSize)
+		if err != nil {
+			// Missing terminator.
+			break
+		}
+		r := bytes.NewReader(buf)
 		var raw pe.RawImportDirectory
 		if err := binary.Read(r, binary.LittleEndian, &raw); err != nil {
-			if errors.Cause(err) == io.EOF {
-				break
-			}
 			return nil, errors.WithStack(err)
 		}
 		zero := pe.RawImportDirectory{}
@@ -311,6 +562,16 @@ func (file *File) parseImportEntry(impDir ImportDirectory) (ImportEntry, error)
 		}
 		imp.INTs = ints
 	}
+	if file.mapped {
+		// The IAT of a mapped image contains the resolved addresses of imported
+		// symbols.
+		iatAddrs, err := file.parseIATAddrs(impDir.IATRelAddr)
+		if err != nil {
+			return ImportEntry{}, errors.WithStack(err)
+		}
+		imp.IATAddrs = iatAddrs
+		return imp, nil
+	}
 	// Parse import address table (IAT is identical in structure to INT).
 	iats, err := file.parseINTs(impDir.IATRelAddr)
 	if err != nil {
@@ -324,14 +585,18 @@ func (file *File) parseImportEntry(impDir ImportDirectory) (ImportEntry, error)
 // relative address.
 func (file *File) parseINTs(intRelAddr uint32) ([]INTEntry, error) {
 	var ints []INTEntry
-	addr := file.OptHdr.ImageBase + uint64(intRelAddr)
+	relAddr := intRelAddr
 loop:
 	for {
 		switch file.OptHdr.Magic {
 		case magic32:
 			// PE32 (32-bit).
 			const rawSize = 4
-			buf := file.ReadData(addr, rawSize)
+			buf, err := file.ReadImage(relAddr, rawSize)
+			if err != nil {
+				// Missing terminator.
+				break loop
+			}
 			r := bytes.NewReader(buf)
 			var raw pe.RawINTEntry32
 			if err := binary.Read(r, binary.LittleEndian, &raw); err != nil {
@@ -341,13 +606,17 @@ loop:
 				// Last entry of table is zero.
 				break loop
 			}
-			addr += rawSize
+			relAddr += rawSize
 			intEntry := file.goINTEntry32(raw)
 			ints = append(ints, intEntry)
 		case magic64:
 			// PE32+ (64-bit).
 			const rawSize = 8
-			buf := file.ReadData(addr, rawSize)
+			buf, err := file.ReadImage(relAddr, rawSize)
+			if err != nil {
+				// Missing terminator.
+				break loop
+			}
 			r := bytes.NewReader(buf)
 			var raw pe.RawINTEntry64
 			if err := binary.Read(r, binary.LittleEndian, &raw); err != nil {
@@ -357,7 +626,7 @@ loop:
 				// Last entry of table is zero.
 				break loop
 			}
-			addr += rawSize
+			relAddr += rawSize
 			intEntry := file.goINTEntry64(raw)
 			ints = append(ints, intEntry)
 		default:
@@ -367,78 +636,151 @@ loop:
 	return ints, nil
 }
 
+// parseIATAddrs parses the resolved addresses of the import address table
+// located at the given relative address (relative to image base) of a mapped
+// image.
+func (file *File) parseIATAddrs(iatRelAddr uint32) ([]uint64, error) {
+	var ptrSize uint32
+	switch file.OptHdr.Magic {
+	case magic32:
+		ptrSize = 4
+	case magic64:
+		ptrSize = 8
+	default:
+		return nil, errors.Errorf("invalid optional header magic number; expected 0x%04X or 0x%04X, got 0x%04X", magic32, magic64, file.OptHdr.Magic)
+	}
+	var addrs []uint64
+	for relAddr := iatRelAddr; ; relAddr += ptrSize {
+		buf, err := file.ReadImage(relAddr, int64(ptrSize))
+		if err != nil {
+			// Missing terminator.
+			break
+		}
+		var addr uint64
+		if ptrSize == 4 {
+			addr = uint64(binary.LittleEndian.Uint32(buf))
+		} else {
+			addr = binary.LittleEndian.Uint64(buf)
+		}
+		if addr == 0 {
+			// Last entry of table is zero.
+			break
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
 // --- [ 2 - Resource ] --------------------------------------------------------
 
-// parseResources parses the resources of the given data directory.
-func (file *File) parseResources(dataDir DataDirectory) ([]Resource, error) {
+// maxResourceDepth specifies the maximum depth of resource directories parsed;
+// the resource tree has three levels of directories (type, name and language).
+const maxResourceDepth = 3
 
-	// []ResourceDirectoryEntry
-	// []ResourceDirectoryString
-	// []ResourceDirecotryData
+// parseResources parses the resource tree of the given data directory.
+func (file *File) parseResources(dataDir DataDirectory) (*ResourceDirectory, error) {
+	visited := make(map[uint32]bool)
+	return file.parseResourceDir(dataDir.RelAddr, 0, 1, visited)
+}
 
-	dbgDirs, err := file.parseDebugDirs(dataDir)
+// parseResourceDir parses the resource directory at the given offset, relative
+// to the root resource directory. Directories visited are recorded, to detect
+// cycles in malformed resource trees.
+func (file *File) parseResourceDir(rootRelAddr, offset uint32, depth int, visited map[uint32]bool) (*ResourceDirectory, error) {
+	visited[offset] = true
+	const rawSize = 16
+	buf, err := file.ReadImage(rootRelAddr+offset, rawSize)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	var dbgData []DebugData
-	for _, dbgDir := range dbgDirs {
-		buf := file.readDebugData(dbgDir)
-		switch dbgDir.Type {
-		case enum.DebugTypeCodeView:
-			dbgCodeView, err := parseDebugCodeViewInfo(dbgDir, buf)
+	var raw pe.RawResourceDirectoryHeader
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &raw); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	dir := goResourceDirectory(raw)
+	// Parse resource directory entries.
+	const entrySize = 8
+	n := int64(raw.NNamedEntries) + int64(raw.NIDEntries)
+	entriesBuf, err := file.ReadImage(rootRelAddr+offset+rawSize, n*entrySize)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	const highBit = 0x80000000
+	for i := int64(0); i < n; i++ {
+		nameOffsetOrID := binary.LittleEndian.Uint32(entriesBuf[i*entrySize:])
+		dataOffset := binary.LittleEndian.Uint32(entriesBuf[i*entrySize+4:])
+		var entry ResourceDirectoryEntry
+		if nameOffsetOrID&highBit != 0 {
+			name, err := file.parseResourceString(rootRelAddr + nameOffsetOrID&^highBit)
 			if err != nil {
 				return nil, errors.WithStack(err)
 			}
-			dbgData = append(dbgData, dbgCodeView)
-		case enum.DebugTypeFPO:
-			dbgFPO, err := parseDebugFPO(dbgDir, buf)
+			entry.Name = name
+		} else {
+			entry.ID = nameOffsetOrID
+		}
+		if dataOffset&highBit != 0 {
+			subdirOffset := dataOffset &^ highBit
+			if depth >= maxResourceDepth || visited[subdirOffset] {
+				file.warnf(file.dataDirOffset(dataDirResource), "resource directory at offset 0x%X exceeds depth %d or forms a cycle; skipped", subdirOffset, maxResourceDepth)
+				continue
+			}
+			subdir, err := file.parseResourceDir(rootRelAddr, subdirOffset, depth+1, visited)
 			if err != nil {
 				return nil, errors.WithStack(err)
 			}
-			dbgData = append(dbgData, dbgFPO)
-		case enum.DebugTypeMisc:
-			// Miscellaneous debug data format is application specific; store raw
-			// content.
-			dbgMisc := &DebugMisc{
-				DbgDir:  dbgDir,
-				Content: buf,
+			entry.Dir = subdir
+		} else {
+			data, err := file.parseResourceData(rootRelAddr + dataOffset)
+			if err != nil {
+				return nil, errors.WithStack(err)
 			}
-			dbgData = append(dbgData, dbgMisc)
-		default:
-			panic(fmt.Errorf("support for debug data type %q not yet implemented", dbgDir.Type))
+			entry.Data = data
 		}
+		dir.Entries = append(dir.Entries, entry)
 	}
-	return dbgData, nil
+	return dir, nil
 }
 
-// parseDebugDirs parses the debug data directories.
-func (file *File) parseDebugDirs(dataDir DataDirectory) ([]DebugDirectory, error) {
-	addr := file.OptHdr.ImageBase + uint64(dataDir.RelAddr)
-	buf := file.ReadData(addr, int64(dataDir.Size))
-	r := bytes.NewReader(buf)
-	var dbgDirs []DebugDirectory
-	for {
-		var raw pe.RawDebugDirectory
-		if err := binary.Read(r, binary.LittleEndian, &raw); err != nil {
-			if errors.Cause(err) == io.EOF {
-				break
-			}
-			return nil, errors.WithStack(err)
-		}
-		dbgDirs = append(dbgDirs, goDebugDirectory(raw))
+// parseResourceString parses the resource directory string at the given
+// relative address.
+func (file *File) parseResourceString(relAddr uint32) (string, error) {
+	buf, err := file.ReadImage(relAddr, 2)
+	if err != nil {
+		return "", errors.WithStack(err)
 	}
-	return dbgDirs, nil
+	n := binary.LittleEndian.Uint16(buf)
+	buf, err = file.ReadImage(relAddr+2, int64(n)*2)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	u := make([]uint16, n)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(buf[i*2:])
+	}
+	return string(utf16.Decode(u)), nil
 }
 
-// readDebugData reads the debug data of the given debug data directory.
-func (file *File) readDebugData(dbgDir DebugDirectory) []byte {
-	if dbgDir.RelAddr != 0 {
-		addr := file.OptHdr.ImageBase + uint64(dbgDir.RelAddr)
-		return file.ReadData(addr, int64(dbgDir.Size))
+// parseResourceData parses the resource data entry at the given relative
+// address, and reads the contents of its resource data.
+func (file *File) parseResourceData(relAddr uint32) (*ResourceData, error) {
+	const rawSize = 16
+	buf, err := file.ReadImage(relAddr, rawSize)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var raw pe.RawResourceDataEntry
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &raw); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	data := goResourceData(raw)
+	content, err := file.ReadImage(raw.DataRelAddr, int64(raw.Size))
+	if err != nil {
+		file.warnf(file.dataDirOffset(dataDirResource), "resource data at relative address 0x%08X (%d bytes) outside of image; skipped", raw.DataRelAddr, raw.Size)
+		return data, nil
 	}
-	start := dbgDir.Offset
-	end := start + dbgDir.Size
-	return file.Content[start:end]
+	data.Content = content
+	return data, nil
 }
 
 // --- [ 5 - Base Relocation Table ] -------------------------------------------
@@ -447,7 +789,10 @@ func (file *File) readDebugData(dbgDir DebugDirectory) []byte {
 // directory.
 func (file *File) parseBaseRelocBlocks(dataDir DataDirectory) ([]BaseRelocBlock, error) {
 	addr := file.OptHdr.ImageBase + uint64(dataDir.RelAddr)
-	buf := file.ReadData(addr, int64(dataDir.Size))
+	buf, err := file.readData(addr, int64(dataDir.Size))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 	r := bytes.NewReader(buf)
 	var blocks []BaseRelocBlock
 	for {
@@ -500,13 +845,30 @@ func (file *File) parseDebugData(dataDir DataDirectory) ([]DebugData, error) {
 	}
 	var dbgData []DebugData
 	for _, dbgDir := range dbgDirs {
-		buf := file.readDebugData(dbgDir)
+		if file.mapped && dbgDir.RelAddr == 0 {
+			// Debug data not mapped into memory is absent from mapped images.
+			continue
+		}
+		buf, err := file.readDebugData(dbgDir)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
 		switch dbgDir.Type {
 		case enum.DebugTypeCodeView:
 			dbgCodeView, err := parseDebugCodeViewInfo(dbgDir, buf)
 			if err != nil {
 				return nil, errors.WithStack(err)
 			}
+			if dbgCodeView == nil {
+				// CodeView format not yet supported (e.g. NB09, NB11 or MTOC);
+				// store raw content.
+				dbgMisc := &DebugMisc{
+					DbgDir:  dbgDir,
+					Content: buf,
+				}
+				dbgData = append(dbgData, dbgMisc)
+				break
+			}
 			dbgData = append(dbgData, dbgCodeView)
 		case enum.DebugTypeFPO:
 			dbgFPO, err := parseDebugFPO(dbgDir, buf)
@@ -514,16 +876,15 @@ func (file *File) parseDebugData(dataDir DataDirectory) ([]DebugData, error) {
 				return nil, errors.WithStack(err)
 			}
 			dbgData = append(dbgData, dbgFPO)
-		case enum.DebugTypeMisc:
-			// Miscellaneous debug data format is application specific; store raw
-			// content.
+		default:
+			// Miscellaneous debug data format is application specific, as is
+			// the format of debug data types not yet supported (e.g. POGO and
+			// REPRO); store raw content.
 			dbgMisc := &DebugMisc{
 				DbgDir:  dbgDir,
 				Content: buf,
 			}
 			dbgData = append(dbgData, dbgMisc)
-		default:
-			panic(fmt.Errorf("support for debug data type %q not yet implemented", dbgDir.Type))
 		}
 	}
 	return dbgData, nil
@@ -532,7 +893,10 @@ func (file *File) parseDebugData(dataDir DataDirectory) ([]DebugData, error) {
 // parseDebugDirs parses the debug data directories.
 func (file *File) parseDebugDirs(dataDir DataDirectory) ([]DebugDirectory, error) {
 	addr := file.OptHdr.ImageBase + uint64(dataDir.RelAddr)
-	buf := file.ReadData(addr, int64(dataDir.Size))
+	buf, err := file.readData(addr, int64(dataDir.Size))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 	r := bytes.NewReader(buf)
 	var dbgDirs []DebugDirectory
 	for {
@@ -549,34 +913,58 @@ func (file *File) parseDebugDirs(dataDir DataDirectory) ([]DebugDirectory, error
 }
 
 // readDebugData reads the debug data of the given debug data directory.
-func (file *File) readDebugData(dbgDir DebugDirectory) []byte {
+func (file *File) readDebugData(dbgDir DebugDirectory) ([]byte, error) {
 	if dbgDir.RelAddr != 0 {
-		addr := file.OptHdr.ImageBase + uint64(dbgDir.RelAddr)
-		return file.ReadData(addr, int64(dbgDir.Size))
+		buf, err := file.ReadImage(dbgDir.RelAddr, int64(dbgDir.Size))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return buf, nil
 	}
-	start := dbgDir.Offset
-	end := start + dbgDir.Size
-	return file.Content[start:end]
+	return file.fileRange(dbgDir.Offset, dbgDir.Size), nil
 }
 
 // ~~~ [ CodeView ] ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 
 // parseDebugCodeViewInfo parses the CodeView debug data of the given debug data
-// directory contents.
+// directory contents. The format of the CodeView debug information is
+// identified by its signature; "NB10" (PDB 2.0) or "RSDS" (PDB 7.0). Nil is
+// returned for other formats.
 func parseDebugCodeViewInfo(dbgDir DebugDirectory, buf []byte) (*DebugCodeView, error) {
-	var raw pe.RawCodeViewInfo
-	r := bytes.NewReader(buf)
-	if err := binary.Read(r, binary.LittleEndian, &raw); err != nil {
-		return nil, errors.WithStack(err)
+	if len(buf) < 4 {
+		// Signature truncated.
+		return nil, nil
 	}
-	b, err := ioutil.ReadAll(r)
-	if err != nil {
-		return nil, errors.WithStack(err)
+	r := bytes.NewReader(buf)
+	var info CodeViewInfo
+	switch sig := string(buf[:4]); sig {
+	case "NB10":
+		var raw pe.RawCodeViewInfoNB10
+		if err := binary.Read(r, binary.LittleEndian, &raw); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		info = goCodeViewNB10(raw, parseCString(b))
+	case "RSDS":
+		var raw pe.RawCodeViewInfoRSDS
+		if err := binary.Read(r, binary.LittleEndian, &raw); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		info = goCodeViewRSDS(raw, parseCString(b))
+	default:
+		// Skip CodeView formats not yet supported.
+		return nil, nil
 	}
-	pdbPath := parseCString(b)
 	dbgCodeView := &DebugCodeView{
 		DbgDir:       dbgDir,
-		CodeViewInfo: goCodeViewInfo(raw, pdbPath),
+		CodeViewInfo: info,
 	}
 	return dbgCodeView, nil
 }
@@ -604,3 +992,98 @@ func parseDebugFPO(dbgDir DebugDirectory, buf []byte) (*DebugFPO, error) {
 	}
 	return dbgFPO, nil
 }
+
+// --- [ 11 - Bound Import Table ] ---------------------------------------------
+
+// parseBoundImports parses the bound import table of the given data directory.
+func (file *File) parseBoundImports(dataDir DataDirectory) ([]BoundImportDirectory, error) {
+	// The bound import table is typically located within the headers, outside
+	// of any section; thus read from the in-memory image.
+	buf, err := file.ReadImage(dataDir.RelAddr, int64(dataDir.Size))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	r := bytes.NewReader(buf)
+	var boundImps []BoundImportDirectory
+	for {
+		var raw pe.RawBoundImportDirectory
+		if err := binary.Read(r, binary.LittleEndian, &raw); err != nil {
+			if errors.Cause(err) == io.EOF {
+				break
+			}
+			return nil, errors.WithStack(err)
+		}
+		zero := pe.RawBoundImportDirectory{}
+		if raw == zero {
+			// Last entry of table is zero.
+			break
+		}
+		boundImp := BoundImportDirectory{
+			Date: parseDateFromEpoch(raw.Date),
+			Name: parseBoundImportName(buf, raw.NameOffset),
+		}
+		for i := 0; i < int(raw.NForwarderRefs); i++ {
+			var rawRef pe.RawBoundForwarderRef
+			if err := binary.Read(r, binary.LittleEndian, &rawRef); err != nil {
+				return nil, errors.WithStack(err)
+			}
+			ref := BoundForwarderRef{
+				Date: parseDateFromEpoch(rawRef.Date),
+				Name: parseBoundImportName(buf, rawRef.NameOffset),
+			}
+			boundImp.ForwarderRefs = append(boundImp.ForwarderRefs, ref)
+		}
+		boundImps = append(boundImps, boundImp)
+	}
+	return boundImps, nil
+}
+
+// parseBoundImportName parses the DLL name at the given offset of the bound
+// import table.
+func parseBoundImportName(buf []byte, offset uint16) string {
+	if int(offset) >= len(buf) {
+		return ""
+	}
+	return parseCString(buf[offset:])
+}
+
+// --- [ 13 - Delay Import Descriptor ] ----------------------------------------
+
+// parseDelayImports parses the delay-load import table of the given data
+// directory.
+func (file *File) parseDelayImports(dataDir DataDirectory) ([]DelayImportEntry, error) {
+	addr := file.OptHdr.ImageBase + uint64(dataDir.RelAddr)
+	buf, err := file.readData(addr, int64(dataDir.Size))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	r := bytes.NewReader(buf)
+	var delayImps []DelayImportEntry
+	for {
+		var raw pe.RawDelayImportDirectory
+		if err := binary.Read(r, binary.LittleEndian, &raw); err != nil {
+			if errors.Cause(err) == io.EOF {
+				break
+			}
+			return nil, errors.WithStack(err)
+		}
+		zero := pe.RawDelayImportDirectory{}
+		if raw == zero {
+			// Last entry of table is zero.
+			break
+		}
+		delayImpDir := file.goDelayImportDirectory(raw)
+		delayImp := DelayImportEntry{
+			DelayImpDir: delayImpDir,
+		}
+		if delayImpDir.INTRelAddr != 0 {
+			ints, err := file.parseINTs(delayImpDir.INTRelAddr)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			delayImp.INTs = ints
+		}
+		delayImps = append(delayImps, delayImp)
+	}
+	return delayImps, nil
+}
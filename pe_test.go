@@ -0,0 +1,72 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseWarnings(t *testing.T) {
+	const path = "testdata/gcc-386-mingw-exec"
+	orig, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%q: unable to read file; %v", path, err)
+	}
+	file, err := ParseBytes(orig)
+	if err != nil {
+		t.Fatalf("%q: unable to parse file; %+v", path, err)
+	}
+	// reserved patches the reserved data directory to be non-zero.
+	reserved := func(content []byte) {
+		binary.LittleEndian.PutUint32(content[file.dataDirOffset(15):], 0x1000)
+	}
+	golden := []struct {
+		name  string
+		patch func(content []byte)
+		// Expected warnings; as substrings.
+		want []string
+	}{
+		// The TLS table is not yet supported, and skipped silently.
+		{name: "unchanged"},
+		{name: "reserved", patch: reserved, want: []string{"reserved data directory 15 is non-zero"}},
+	}
+	for _, g := range golden {
+		content := append([]byte(nil), orig...)
+		if g.patch != nil {
+			g.patch(content)
+		}
+		eager, err := ParseBytes(content)
+		if err != nil {
+			t.Errorf("%s: unable to parse file; %+v", g.name, err)
+			continue
+		}
+		lazy, err := NewFile(bytes.NewReader(content), int64(len(content)))
+		if err != nil {
+			t.Errorf("%s: unable to parse file; %+v", g.name, err)
+			continue
+		}
+		// Warnings of the headers are recorded before parsing data directories.
+		if !reflect.DeepEqual(lazy.Warnings, eager.Warnings) {
+			t.Errorf("%s: warnings mismatch of file read on demand; expected %v, got %v", g.name, eager.Warnings, lazy.Warnings)
+		}
+		if _, err := lazy.Imports(); err != nil {
+			t.Errorf("%s: unable to parse imports; %+v", g.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(lazy.Warnings, eager.Warnings) {
+			t.Errorf("%s: warnings mismatch after parsing imports; expected %v, got %v", g.name, eager.Warnings, lazy.Warnings)
+		}
+		if len(eager.Warnings) != len(g.want) {
+			t.Errorf("%s: number of warnings mismatch; expected %d, got %d (%v)", g.name, len(g.want), len(eager.Warnings), eager.Warnings)
+			continue
+		}
+		for i, want := range g.want {
+			if got := eager.Warnings[i].String(); !strings.Contains(got, want) {
+				t.Errorf("%s: warning %d mismatch; expected %q, got %q", g.name, i, want, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,596 @@
+// Package pediff compares PE files structurally.
+//
+// Two PE files are compared field by field; the MS-DOS header, file header,
+// optional header, data directories and overlay, the section headers and
+// section contents, the imported and exported symbols, the resource tree, the
+// debug directories and the base relocations. Changes are reported in
+// human-readable or JSON format.
+package pediff
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mewmew/pe"
+	"github.com/mewmew/pe/enum"
+	"github.com/pkg/errors"
+)
+
+// Kind specifies the kind of a change.
+type Kind uint8
+
+// Change kinds.
+const (
+	// Structure present only in the new PE file.
+	KindAdded Kind = iota + 1
+	// Structure present only in the old PE file.
+	KindRemoved
+	// Field changed between the old and the new PE file.
+	KindChanged
+)
+
+// String returns the string representation of the change kind.
+func (kind Kind) String() string {
+	switch kind {
+	case KindAdded:
+		return "added"
+	case KindRemoved:
+		return "removed"
+	case KindChanged:
+		return "changed"
+	default:
+		return "unknown"
+	}
+}
+
+// Report is a structural diff between two PE files.
+type Report struct {
+	// Changes from the old to the new PE file, grouped by category.
+	Changes []Change
+}
+
+// Change is a difference between two PE files.
+type Change struct {
+	// Kind of change.
+	Kind Kind
+	// Category of the changed structure ("header", "section", "import",
+	// "delay import", "export", "resource", "debug" or "reloc").
+	Category string
+	// Path of the changed structure or field within its category (e.g.
+	// "OptHeader.ImageSize" or "kernel32.dll!CreateFileW").
+	Path string
+	// Old value; empty if added.
+	Old string
+	// New value; empty if removed.
+	New string
+}
+
+// String returns the string representation of the change.
+func (change Change) String() string {
+	switch change.Kind {
+	case KindAdded:
+		return joinNonEmpty("+ "+change.Path, change.New)
+	case KindRemoved:
+		return joinNonEmpty("- "+change.Path, change.Old)
+	default:
+		return fmt.Sprintf("~ %s: %s -> %s", change.Path, change.Old, change.New)
+	}
+}
+
+// Compare compares the given old and new PE files. The contents of data
+// directories are parsed on first use.
+//
+// Sections are matched by name, imported symbols by DLL name (ignoring case)
+// and symbol name or ordinal, exported symbols by name (or ordinal if
+// unnamed), resources by type, name and language, debug directories by type,
+// and base relocation blocks by page. Relative addresses of exported symbols
+// are not compared, as they change with most code changes.
+func Compare(oldFile, newFile *pe.File) (*Report, error) {
+	if oldFile.FileHdr == nil || oldFile.OptHdr == nil || newFile.FileHdr == nil || newFile.OptHdr == nil {
+		return nil, errors.New("unable to compare PE files; missing file header or optional header")
+	}
+	d := &differ{}
+	d.compareRecords("header", headerRecords(oldFile), headerRecords(newFile))
+	d.compareRecords("section", sectionRecords(oldFile), sectionRecords(newFile))
+	steps := []struct {
+		category string
+		records  func(file *pe.File) ([]record, error)
+	}{
+		{category: "import", records: importRecords},
+		{category: "delay import", records: delayImportRecords},
+		{category: "export", records: exportRecords},
+		{category: "resource", records: resourceRecords},
+		{category: "debug", records: debugRecords},
+		{category: "reloc", records: relocRecords},
+	}
+	for _, step := range steps {
+		oldRecords, err := step.records(oldFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to compare %s; old file", step.category)
+		}
+		newRecords, err := step.records(newFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to compare %s; new file", step.category)
+		}
+		d.compareRecords(step.category, oldRecords, newRecords)
+	}
+	return &Report{Changes: d.changes}, nil
+}
+
+// record is a structure compared field by field, identified by key.
+type record struct {
+	// Key of the structure within its category.
+	key string
+	// Fields of the structure.
+	fields []field
+}
+
+// field is a named field of a structure.
+type field struct {
+	// Field name.
+	name string
+	// Field value.
+	value string
+}
+
+// summary returns a summary of the fields of the record.
+func (r record) summary() string {
+	var values []string
+	for _, f := range r.fields {
+		values = append(values, fmt.Sprintf("%s=%s", f.name, f.value))
+	}
+	return strings.Join(values, " ")
+}
+
+// differ records the changes between two PE files.
+type differ struct {
+	// Changes recorded.
+	changes []Change
+}
+
+// compareRecords records the changes between the given old and new records of
+// a category. Records present in both are compared field by field; records are
+// reported in order of the old records followed by added records.
+func (d *differ) compareRecords(category string, oldRecords, newRecords []record) {
+	newIndex := make(map[string]int)
+	for i, r := range newRecords {
+		newIndex[r.key] = i
+	}
+	oldKeys := make(map[string]bool)
+	for _, oldRecord := range oldRecords {
+		oldKeys[oldRecord.key] = true
+		i, ok := newIndex[oldRecord.key]
+		if !ok {
+			d.changes = append(d.changes, Change{Kind: KindRemoved, Category: category, Path: oldRecord.key, Old: oldRecord.summary()})
+			continue
+		}
+		d.compareFields(category, oldRecord.key, oldRecord.fields, newRecords[i].fields)
+	}
+	for _, newRecord := range newRecords {
+		if !oldKeys[newRecord.key] {
+			d.changes = append(d.changes, Change{Kind: KindAdded, Category: category, Path: newRecord.key, New: newRecord.summary()})
+		}
+	}
+}
+
+// compareFields records the changed fields of the record with the given key.
+func (d *differ) compareFields(category, key string, oldFields, newFields []field) {
+	newValues := make(map[string]string)
+	for _, f := range newFields {
+		newValues[f.name] = f.value
+	}
+	oldNames := make(map[string]bool)
+	for _, oldField := range oldFields {
+		oldNames[oldField.name] = true
+		path := key + "." + oldField.name
+		newValue, ok := newValues[oldField.name]
+		switch {
+		case !ok:
+			d.changes = append(d.changes, Change{Kind: KindRemoved, Category: category, Path: path, Old: oldField.value})
+		case newValue != oldField.value:
+			d.changes = append(d.changes, Change{Kind: KindChanged, Category: category, Path: path, Old: oldField.value, New: newValue})
+		}
+	}
+	for _, newField := range newFields {
+		if !oldNames[newField.name] {
+			path := key + "." + newField.name
+			d.changes = append(d.changes, Change{Kind: KindAdded, Category: category, Path: path, New: newField.value})
+		}
+	}
+}
+
+// --- [ Headers ] -------------------------------------------------------------
+
+// Names of data directories, indexed by data directory index.
+var dataDirNames = [...]string{
+	"Export",
+	"Import",
+	"Resource",
+	"Exception",
+	"Certificate",
+	"BaseReloc",
+	"Debug",
+	"Architecture",
+	"GlobalPtr",
+	"TLS",
+	"LoadConfig",
+	"BoundImport",
+	"IAT",
+	"DelayImport",
+	"CLR",
+	"Reserved",
+}
+
+// headerRecords returns the records of the MS-DOS header, file header,
+// optional header, data directories and overlay of the PE file.
+func headerRecords(file *pe.File) []record {
+	// The MS-DOS header, MS-DOS stub and Rich header precede the PE header,
+	// located at the file offset specified by e_lfanew.
+	dosHdr := record{key: "DOSHeader"}
+	buf := make([]byte, 4)
+	if _, err := file.ReadAt(buf, 0x3C); err == nil {
+		peOffset := binary.LittleEndian.Uint32(buf)
+		if int64(peOffset) > file.Size() {
+			peOffset = uint32(file.Size())
+		}
+		stub := make([]byte, peOffset)
+		n, _ := file.ReadAt(stub, 0)
+		dosHdr.fields = []field{
+			{name: "PEOffset", value: formatValue(peOffset)},
+			{name: "SHA256", value: hashHex(stub[:n])},
+		}
+	}
+	dataDirs := record{key: "DataDirs"}
+	for i, dataDir := range file.DataDirs {
+		name := strconv.Itoa(i)
+		if i < len(dataDirNames) {
+			name = dataDirNames[i]
+		}
+		value := fmt.Sprintf("0x%08X (0x%X bytes)", dataDir.RelAddr, dataDir.Size)
+		dataDirs.fields = append(dataDirs.fields, field{name: name, value: value})
+	}
+	records := []record{
+		dosHdr,
+		{key: "FileHeader", fields: structFields(*file.FileHdr)},
+		{key: "OptHeader", fields: structFields(*file.OptHdr)},
+		dataDirs,
+	}
+	if offset, ok := file.OverlayOffset(); ok {
+		overlay := make([]byte, file.Size()-offset)
+		n, _ := file.ReadAt(overlay, offset)
+		fields := []field{
+			{name: "Offset", value: formatValue(uint64(offset))},
+			{name: "Size", value: formatValue(uint64(len(overlay)))},
+			{name: "SHA256", value: hashHex(overlay[:n])},
+		}
+		records = append(records, record{key: "Overlay", fields: fields})
+	}
+	return records
+}
+
+// --- [ Sections ] ------------------------------------------------------------
+
+// sectionRecords returns the records of the section headers of the PE file,
+// including the SHA-256 hash of the raw contents of each section.
+func sectionRecords(file *pe.File) []record {
+	var records []record
+	seen := make(map[string]int)
+	for _, sectHdr := range file.SectHdrs {
+		key := uniqueKey(seen, strconv.Quote(sectHdr.Name))
+		fields := structFields(sectHdr)[1:] // skip Name
+		fields = append(fields, field{name: "SHA256", value: hashHex(file.SectionData(sectHdr))})
+		records = append(records, record{key: key, fields: fields})
+	}
+	return records
+}
+
+// --- [ Imports ] -------------------------------------------------------------
+
+// importRecords returns the records of the symbols imported by the PE file.
+func importRecords(file *pe.File) ([]record, error) {
+	imps, err := file.Imports()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var records []record
+	for _, imp := range imps {
+		syms := imp.INTs
+		if len(syms) == 0 {
+			// Import name table not present; use import address table.
+			syms = imp.IATs
+		}
+		for _, sym := range syms {
+			records = append(records, record{key: symbolKey(imp.ImpDir.Name, sym)})
+		}
+	}
+	return records, nil
+}
+
+// delayImportRecords returns the records of the symbols delay-load imported by
+// the PE file.
+func delayImportRecords(file *pe.File) ([]record, error) {
+	delayImps, err := file.DelayImports()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var records []record
+	for _, imp := range delayImps {
+		for _, sym := range imp.INTs {
+			records = append(records, record{key: symbolKey(imp.DelayImpDir.Name, sym)})
+		}
+	}
+	return records, nil
+}
+
+// symbolKey returns the key of the given symbol imported from the specified
+// DLL (e.g. "kernel32.dll!CreateFileW" or "ws2_32.dll!#23").
+func symbolKey(dllName string, sym pe.INTEntry) string {
+	if sym.IsOrdinal {
+		return fmt.Sprintf("%s!#%d", strings.ToLower(dllName), sym.Ordinal)
+	}
+	return fmt.Sprintf("%s!%s", strings.ToLower(dllName), sym.NameEntry.Name)
+}
+
+// --- [ Exports ] -------------------------------------------------------------
+
+// exportRecords returns the records of the symbols exported by the PE file;
+// by name, followed by the symbols exported by ordinal only.
+func exportRecords(file *pe.File) ([]record, error) {
+	exps, err := file.Exports()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if exps == nil {
+		return nil, nil
+	}
+	var records []record
+	named := make(map[uint16]bool)
+	for _, name := range exps.Names {
+		if int(name.EATIndex) >= len(exps.EATs) {
+			continue
+		}
+		named[name.EATIndex] = true
+		records = append(records, record{key: name.Name, fields: exportFields(exps.EATs[name.EATIndex])})
+	}
+	for i, eat := range exps.EATs {
+		if named[uint16(i)] || (eat.RelAddr == 0 && len(eat.Forwarder) == 0) {
+			continue
+		}
+		key := fmt.Sprintf("#%d", eat.Ordinal)
+		records = append(records, record{key: key, fields: exportFields(eat)})
+	}
+	return records, nil
+}
+
+// exportFields returns the fields of the given export address table entry.
+func exportFields(eat pe.EATEntry) []field {
+	fields := []field{{name: "Ordinal", value: strconv.Itoa(int(eat.Ordinal))}}
+	if len(eat.Forwarder) > 0 {
+		fields = append(fields, field{name: "Forwarder", value: eat.Forwarder})
+	}
+	return fields
+}
+
+// --- [ Resources ] -----------------------------------------------------------
+
+// Names of standard resource types, indexed by resource type ID.
+var resourceTypeNames = map[uint32]string{
+	1:  "RT_CURSOR",
+	2:  "RT_BITMAP",
+	3:  "RT_ICON",
+	4:  "RT_MENU",
+	5:  "RT_DIALOG",
+	6:  "RT_STRING",
+	7:  "RT_FONTDIR",
+	8:  "RT_FONT",
+	9:  "RT_ACCELERATOR",
+	10: "RT_RCDATA",
+	11: "RT_MESSAGETABLE",
+	12: "RT_GROUP_CURSOR",
+	14: "RT_GROUP_ICON",
+	16: "RT_VERSION",
+	17: "RT_DLGINCLUDE",
+	19: "RT_PLUGPLAY",
+	20: "RT_VXD",
+	21: "RT_ANICURSOR",
+	22: "RT_ANIICON",
+	23: "RT_HTML",
+	24: "RT_MANIFEST",
+}
+
+// resourceRecords returns the records of the resource data of the PE file,
+// identified by resource type, name and language (e.g. "RT_VERSION/1/1033").
+func resourceRecords(file *pe.File) ([]record, error) {
+	root, err := file.Resources()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if root == nil {
+		return nil, nil
+	}
+	var records []record
+	var walk func(dir *pe.ResourceDirectory, path []string)
+	walk = func(dir *pe.ResourceDirectory, path []string) {
+		for _, entry := range dir.Entries {
+			var name string
+			switch {
+			case len(entry.Name) > 0:
+				name = strconv.Quote(entry.Name)
+			case len(path) == 0 && len(resourceTypeNames[entry.ID]) > 0:
+				name = resourceTypeNames[entry.ID]
+			default:
+				name = strconv.Itoa(int(entry.ID))
+			}
+			entryPath := append(path[:len(path):len(path)], name)
+			switch {
+			case entry.Dir != nil:
+				walk(entry.Dir, entryPath)
+			case entry.Data != nil:
+				fields := []field{
+					{name: "Size", value: strconv.Itoa(len(entry.Data.Content))},
+					{name: "CodePage", value: strconv.Itoa(int(entry.Data.CodePage))},
+					{name: "SHA256", value: hashHex(entry.Data.Content)},
+				}
+				records = append(records, record{key: strings.Join(entryPath, "/"), fields: fields})
+			}
+		}
+	}
+	walk(root, nil)
+	return records, nil
+}
+
+// --- [ Debug ] ---------------------------------------------------------------
+
+// debugRecords returns the records of the debug directories of the PE file,
+// identified by debug type, including the SHA-256 hash of the debug data and
+// the PDB path and symbol server key of CodeView debug information.
+func debugRecords(file *pe.File) ([]record, error) {
+	dbgDirs, err := file.DebugDirs()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var records []record
+	seen := make(map[string]int)
+	for _, dbgDir := range dbgDirs {
+		key := uniqueKey(seen, dbgDir.Type.String())
+		fields := []field{
+			{name: "Date", value: formatValue(dbgDir.Date)},
+			{name: "Version", value: fmt.Sprintf("%d.%d", dbgDir.MajorVer, dbgDir.MinorVer)},
+			{name: "Size", value: strconv.Itoa(int(dbgDir.Size))},
+			{name: "SHA256", value: hashHex(debugContent(file, dbgDir))},
+		}
+		if dbgDir.Type == enum.DebugTypeCodeView && seen[key] == 1 {
+			fields = append(fields, codeViewFields(file)...)
+		}
+		records = append(records, record{key: key, fields: fields})
+	}
+	return records, nil
+}
+
+// codeViewFields returns the fields of the CodeView debug information of the
+// PE file; the PDB path and symbol server key.
+func codeViewFields(file *pe.File) []field {
+	dbgCodeView, err := file.CodeView()
+	if err != nil || dbgCodeView == nil {
+		// Unsupported CodeView formats are compared by hash only.
+		return nil
+	}
+	return []field{
+		{name: "PDB", value: strconv.Quote(dbgCodeView.PDB())},
+		{name: "SymbolServerKey", value: dbgCodeView.SymbolServerKey()},
+	}
+}
+
+// debugContent returns the debug data of the given debug directory; or nil if
+// not present.
+func debugContent(file *pe.File, dbgDir pe.DebugDirectory) []byte {
+	if dbgDir.RelAddr != 0 {
+		if buf, err := file.ReadImage(dbgDir.RelAddr, int64(dbgDir.Size)); err == nil {
+			return buf
+		}
+	}
+	if dbgDir.Offset == 0 || file.Mapped() {
+		return nil
+	}
+	buf := make([]byte, dbgDir.Size)
+	n, _ := file.ReadAt(buf, int64(dbgDir.Offset))
+	return buf[:n]
+}
+
+// --- [ Relocations ] ---------------------------------------------------------
+
+// relocRecords returns the records of the base relocation blocks of the PE
+// file, identified by page.
+func relocRecords(file *pe.File) ([]record, error) {
+	blocks, err := file.BaseRelocs()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var records []record
+	seen := make(map[string]int)
+	for _, block := range blocks {
+		key := uniqueKey(seen, fmt.Sprintf("0x%08X", block.PageRelAddr))
+		h := sha256.New()
+		for _, entry := range block.Entries {
+			fmt.Fprintf(h, "%d:%d\n", entry.Type, entry.Offset)
+		}
+		fields := []field{
+			{name: "Entries", value: strconv.Itoa(len(block.Entries))},
+			{name: "SHA256", value: fmt.Sprintf("%x", h.Sum(nil))},
+		}
+		records = append(records, record{key: key, fields: fields})
+	}
+	return records, nil
+}
+
+// ### [ Helper functions ] ####################################################
+
+// structFields returns the fields of the given struct, with values formatted
+// by formatValue.
+func structFields(v interface{}) []field {
+	rv := reflect.ValueOf(v)
+	var fields []field
+	for i := 0; i < rv.NumField(); i++ {
+		f := field{
+			name:  rv.Type().Field(i).Name,
+			value: formatValue(rv.Field(i).Interface()),
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// formatValue returns the string representation of the given field value.
+// Integers are formatted in hexadecimal, followed by their name if a named
+// enum value; times are formatted in UTC.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case string:
+		return strconv.Quote(v)
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		s := fmt.Sprintf("0x%X", rv.Uint())
+		if stringer, ok := v.(fmt.Stringer); ok {
+			// Values without name are formatted as "Type(value)" by stringer.
+			if name := stringer.String(); !strings.Contains(name, "(") {
+				s += " (" + name + ")"
+			}
+		}
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// hashHex returns the SHA-256 hash of the given data in hexadecimal format.
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%x", sum[:])
+}
+
+// uniqueKey returns the given key, suffixed by its number of occurrences if
+// seen before (e.g. ".text#2").
+func uniqueKey(seen map[string]int, key string) string {
+	seen[key]++
+	if n := seen[key]; n > 1 {
+		return fmt.Sprintf("%s#%d", key, n)
+	}
+	return key
+}
+
+// joinNonEmpty joins the given prefix and value with ": ", unless value is
+// empty.
+func joinNonEmpty(prefix, value string) string {
+	if len(value) == 0 {
+		return prefix
+	}
+	return prefix + ": " + value
+}
@@ -0,0 +1,110 @@
+package pediff
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/mewmew/pe"
+)
+
+func TestCompare(t *testing.T) {
+	golden := []struct {
+		oldPath string
+		newPath string
+		// Path to the expected report in human-readable and JSON format.
+		textPath string
+		jsonPath string
+	}{
+		{
+			oldPath:  "../testdata/gcc-386-mingw-exec",
+			newPath:  "../testdata/gcc-386-mingw-no-symbols-exec",
+			textPath: "testdata/exec-no-symbols.txt",
+			jsonPath: "testdata/exec-no-symbols.json",
+		},
+		{
+			oldPath:  "../testdata/gcc-386-mingw-no-symbols-exec",
+			newPath:  "../testdata/gcc-386-mingw-exec",
+			textPath: "testdata/no-symbols-exec.txt",
+			jsonPath: "testdata/no-symbols-exec.json",
+		},
+	}
+	for _, g := range golden {
+		report, err := compareFiles(g.oldPath, g.newPath)
+		if err != nil {
+			t.Errorf("%q -> %q: unable to compare files; %+v", g.oldPath, g.newPath, err)
+			continue
+		}
+		text := &bytes.Buffer{}
+		if err := report.WriteText(text); err != nil {
+			t.Errorf("%q -> %q: unable to write report; %+v", g.oldPath, g.newPath, err)
+			continue
+		}
+		checkGolden(t, g.textPath, text.Bytes())
+		jsonBuf := &bytes.Buffer{}
+		if err := report.WriteJSON(jsonBuf); err != nil {
+			t.Errorf("%q -> %q: unable to write report; %+v", g.oldPath, g.newPath, err)
+			continue
+		}
+		checkGolden(t, g.jsonPath, jsonBuf.Bytes())
+	}
+}
+
+func TestCompareIdentical(t *testing.T) {
+	const path = "../testdata/gcc-386-mingw-exec"
+	report, err := compareFiles(path, path)
+	if err != nil {
+		t.Fatalf("%q: unable to compare files; %+v", path, err)
+	}
+	if len(report.Changes) != 0 {
+		t.Errorf("%q: number of changes mismatch; expected 0, got %d", path, len(report.Changes))
+	}
+	golden := []struct {
+		write func(buf *bytes.Buffer) error
+		want  string
+	}{
+		{
+			write: func(buf *bytes.Buffer) error { return report.WriteText(buf) },
+			want:  "no differences\n",
+		},
+		{
+			write: func(buf *bytes.Buffer) error { return report.WriteJSON(buf) },
+			want:  "{\n\t\"changes\": []\n}\n",
+		},
+	}
+	for _, g := range golden {
+		buf := &bytes.Buffer{}
+		if err := g.write(buf); err != nil {
+			t.Errorf("%q: unable to write report; %+v", path, err)
+			continue
+		}
+		if got := buf.String(); got != g.want {
+			t.Errorf("%q: report mismatch; expected %q, got %q", path, g.want, got)
+		}
+	}
+}
+
+// compareFiles compares the given PE files.
+func compareFiles(oldPath, newPath string) (*Report, error) {
+	oldFile, err := pe.ParseFile(oldPath)
+	if err != nil {
+		return nil, err
+	}
+	newFile, err := pe.ParseFile(newPath)
+	if err != nil {
+		return nil, err
+	}
+	return Compare(oldFile, newFile)
+}
+
+// checkGolden checks the given output against the contents of the golden file.
+func checkGolden(t *testing.T, goldenPath string, got []byte) {
+	want, err := ioutil.ReadFile(goldenPath)
+	if err != nil {
+		t.Errorf("%q: unable to read golden file; %v", goldenPath, err)
+		return
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%q: output mismatch; expected\n%s\ngot\n%s", goldenPath, want, got)
+	}
+}
@@ -0,0 +1,69 @@
+package pediff
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// WriteText writes the report to w in human-readable format. Changes are
+// grouped by category; added, removed and changed structures are prefixed by
+// "+", "-" and "~" respectively.
+func (report *Report) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if len(report.Changes) == 0 {
+		fmt.Fprintln(bw, "no differences")
+	}
+	category := ""
+	for _, change := range report.Changes {
+		if change.Category != category {
+			category = change.Category
+			fmt.Fprintf(bw, "[%s]\n", category)
+		}
+		fmt.Fprintf(bw, "\t%s\n", change)
+	}
+	if err := bw.Flush(); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// WriteJSON writes the report to w in JSON format.
+func (report *Report) WriteJSON(w io.Writer) error {
+	jr := jsonReport{
+		Changes: make([]jsonChange, 0, len(report.Changes)),
+	}
+	for _, change := range report.Changes {
+		jc := jsonChange{
+			Kind:     change.Kind.String(),
+			Category: change.Category,
+			Path:     change.Path,
+			Old:      change.Old,
+			New:      change.New,
+		}
+		jr.Changes = append(jr.Changes, jc)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(jr); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// jsonReport is the JSON representation of a structural diff.
+type jsonReport struct {
+	Changes []jsonChange `json:"changes"`
+}
+
+// jsonChange is the JSON representation of a change.
+type jsonChange struct {
+	Kind     string `json:"kind"`
+	Category string `json:"category"`
+	Path     string `json:"path"`
+	Old      string `json:"old,omitempty"`
+	New      string `json:"new,omitempty"`
+}
@@ -110,8 +110,141 @@ func goSectionHeader(raw pe.RawSectionHeader) SectionHeader {
 	}
 }
 
+// rawFileHeader converts the file header into a corresponding raw version.
+func rawFileHeader(hdr *FileHeader) *pe.RawFileHeader {
+	return &pe.RawFileHeader{
+		Machine:           hdr.Machine,
+		NSections:         hdr.NSections,
+		Date:              uint32(hdr.Date.Unix()),
+		SymbolTableOffset: hdr.SymbolTableOffset,
+		NSymbols:          hdr.NSymbols,
+		OptHdrSize:        hdr.OptHdrSize,
+		Characteristics:   hdr.Characteristics,
+	}
+}
+
+// rawOptHeader32 converts the optional header into a corresponding raw 32-bit
+// version.
+func rawOptHeader32(hdr *OptHeader) *pe.RawOptHeader32 {
+	return &pe.RawOptHeader32{
+		MajorLinkerVer:        hdr.MajorLinkerVer,
+		MinorLinkerVer:        hdr.MinorLinkerVer,
+		CodeSize:              hdr.CodeSize,
+		InitializedDataSize:   hdr.InitializedDataSize,
+		UninitializedDataSize: hdr.UninitializedDataSize,
+		EntryRelAddr:          hdr.EntryRelAddr,
+		CodeBase:              hdr.CodeBase,
+		DataBase:              hdr.DataBase,
+		ImageBase:             uint32(hdr.ImageBase),
+		SectionAlign:          hdr.SectionAlign,
+		FileAlign:             hdr.FileAlign,
+		MajorOSVer:            hdr.MajorOSVer,
+		MinorOSVer:            hdr.MinorOSVer,
+		MajorImageVer:         hdr.MajorImageVer,
+		MinorImageVer:         hdr.MinorImageVer,
+		MajorSubsystemVer:     hdr.MajorSubsystemVer,
+		MinorSubsystemVer:     hdr.MinorSubsystemVer,
+		Win32Ver:              hdr.Win32Ver,
+		ImageSize:             hdr.ImageSize,
+		HeadersSize:           hdr.HeadersSize,
+		Checksum:              hdr.Checksum,
+		Subsystem:             hdr.Subsystem,
+		DLLCharacteristics:    hdr.DLLCharacteristics,
+		ReservedStackSize:     uint32(hdr.ReservedStackSize),
+		InitialStackSize:      uint32(hdr.InitialStackSize),
+		ReservedHeapSize:      uint32(hdr.ReservedHeapSize),
+		InitialHeapSize:       uint32(hdr.InitialHeapSize),
+		LoaderFlags:           hdr.LoaderFlags,
+		NDataDirs:             hdr.NDataDirs,
+	}
+}
+
+// rawOptHeader64 converts the optional header into a corresponding raw 64-bit
+// version.
+func rawOptHeader64(hdr *OptHeader) *pe.RawOptHeader64 {
+	return &pe.RawOptHeader64{
+		MajorLinkerVer:        hdr.MajorLinkerVer,
+		MinorLinkerVer:        hdr.MinorLinkerVer,
+		CodeSize:              hdr.CodeSize,
+		InitializedDataSize:   hdr.InitializedDataSize,
+		UninitializedDataSize: hdr.UninitializedDataSize,
+		EntryRelAddr:          hdr.EntryRelAddr,
+		CodeBase:              hdr.CodeBase,
+		ImageBase:             hdr.ImageBase,
+		SectionAlign:          hdr.SectionAlign,
+		FileAlign:             hdr.FileAlign,
+		MajorOSVer:            hdr.MajorOSVer,
+		MinorOSVer:            hdr.MinorOSVer,
+		MajorImageVer:         hdr.MajorImageVer,
+		MinorImageVer:         hdr.MinorImageVer,
+		MajorSubsystemVer:     hdr.MajorSubsystemVer,
+		MinorSubsystemVer:     hdr.MinorSubsystemVer,
+		Win32Ver:              hdr.Win32Ver,
+		ImageSize:             hdr.ImageSize,
+		HeadersSize:           hdr.HeadersSize,
+		Checksum:              hdr.Checksum,
+		Subsystem:             hdr.Subsystem,
+		DLLCharacteristics:    hdr.DLLCharacteristics,
+		ReservedStackSize:     hdr.ReservedStackSize,
+		InitialStackSize:      hdr.InitialStackSize,
+		ReservedHeapSize:      hdr.ReservedHeapSize,
+		InitialHeapSize:       hdr.InitialHeapSize,
+		LoaderFlags:           hdr.LoaderFlags,
+		NDataDirs:             hdr.NDataDirs,
+	}
+}
+
+// rawSectionHeader converts the section header into a corresponding raw
+// version. The raw name of the original section header is used if it has the
+// same Go name, to preserve any bytes following the NULL-terminator.
+func rawSectionHeader(sectHdr SectionHeader, orig *pe.RawSectionHeader) pe.RawSectionHeader {
+	var name [8]byte
+	if orig != nil && parseCString(orig.Name[:]) == sectHdr.Name {
+		name = orig.Name
+	} else {
+		copy(name[:], sectHdr.Name)
+	}
+	return pe.RawSectionHeader{
+		Name:           name,
+		VirtualSize:    sectHdr.VirtualSize,
+		RelAddr:        sectHdr.RelAddr,
+		DataSize:       sectHdr.DataSize,
+		DataOffset:     sectHdr.DataOffset,
+		RelocsOffset:   sectHdr.RelocsOffset,
+		LineNumsOffset: sectHdr.LineNumsOffset,
+		NRelocs:        sectHdr.NRelocs,
+		NLineNums:      sectHdr.NLineNums,
+		Flags:          sectHdr.Flags,
+	}
+}
+
 // --- [ Data directories ] ----------------------------------------------------
 
+// ~~~ [ 0 - Export Table ] ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+
+// goExportDirectory converts the raw export data directory into a corresponding
+// Go version.
+func (file *File) goExportDirectory(raw pe.RawExportDirectory) ExportDirectory {
+	var name string
+	if raw.NameRelAddr != 0 {
+		nameAddr := file.OptHdr.ImageBase + uint64(raw.NameRelAddr)
+		name = file.parseCString(nameAddr)
+	}
+	return ExportDirectory{
+		Characteristics: raw.Characteristics,
+		Date:            parseDateFromEpoch(raw.Date),
+		MajorVer:        raw.MajorVer,
+		MinorVer:        raw.MinorVer,
+		Name:            name,
+		OrdinalBase:     raw.OrdinalBase,
+		NFuncs:          raw.NFuncs,
+		NNames:          raw.NNames,
+		FuncsRelAddr:    raw.FuncsRelAddr,
+		NamesRelAddr:    raw.NamesRelAddr,
+		OrdinalsRelAddr: raw.OrdinalsRelAddr,
+	}
+}
+
 // ~~~ [ 1 - Import Table ] ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 
 // goImportDirectory converts the raw import data directory into a corresponding
@@ -178,8 +311,11 @@ func (file *File) goINTEntry64(raw pe.RawINTEntry64) INTEntry {
 func (file *File) parseNameEntry(addr uint64) NameEntry {
 	// Parse hint.
 	const hintSize = 2
-	buf := file.ReadData(addr, hintSize)
-	hint := binary.LittleEndian.Uint16(buf)
+	var hint uint16
+	relAddr := uint32(addr - file.OptHdr.ImageBase)
+	if buf, err := file.ReadImage(relAddr, hintSize); err == nil {
+		hint = binary.LittleEndian.Uint16(buf)
+	}
 	addr += hintSize
 	// Parse name.
 	name := file.parseCString(addr)
@@ -189,6 +325,31 @@ func (file *File) parseNameEntry(addr uint64) NameEntry {
 	}
 }
 
+// ~~~ [ 2 - Resource Table ] ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+
+// goResourceDirectory converts the raw resource directory header into a
+// corresponding Go version.
+func goResourceDirectory(raw pe.RawResourceDirectoryHeader) *ResourceDirectory {
+	return &ResourceDirectory{
+		Characteristics: raw.Characteristics,
+		Date:            parseDateFromEpoch(raw.Date),
+		MajorVer:        raw.MajorVer,
+		MinorVer:        raw.MinorVer,
+		NNamedEntries:   raw.NNamedEntries,
+		NIDEntries:      raw.NIDEntries,
+	}
+}
+
+// goResourceData converts the raw resource data entry into a corresponding Go
+// version.
+func goResourceData(raw pe.RawResourceDataEntry) *ResourceData {
+	return &ResourceData{
+		DataRelAddr: raw.DataRelAddr,
+		CodePage:    raw.CodePage,
+		Reserved:    raw.Reserved,
+	}
+}
+
 // ~~~ [ 5 - Base Relocation Table ] ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 
 // goBaseRelocEntry converts the raw base relocation entry into a corresponding
@@ -222,10 +383,10 @@ func goDebugDirectory(raw pe.RawDebugDirectory) DebugDirectory {
 	}
 }
 
-// goCodeViewInfo converts the raw CodeView debug info into a corresponding Go
-// version.
-func goCodeViewInfo(raw pe.RawCodeViewInfo, pdbPath string) CodeViewInfo {
-	return CodeViewInfo{
+// goCodeViewNB10 converts the raw CodeView debug info in PDB 2.0 format into a
+// corresponding Go version.
+func goCodeViewNB10(raw pe.RawCodeViewInfoNB10, pdbPath string) *CodeViewNB10 {
+	return &CodeViewNB10{
 		Signature: raw.Signature,
 		Offset:    raw.Offset,
 		Date:      parseDateFromEpoch(raw.Date),
@@ -234,6 +395,17 @@ func goCodeViewInfo(raw pe.RawCodeViewInfo, pdbPath string) CodeViewInfo {
 	}
 }
 
+// goCodeViewRSDS converts the raw CodeView debug info in PDB 7.0 format into a
+// corresponding Go version.
+func goCodeViewRSDS(raw pe.RawCodeViewInfoRSDS, pdbPath string) *CodeViewRSDS {
+	return &CodeViewRSDS{
+		Signature: raw.Signature,
+		GUID:      GUID(raw.GUID),
+		Age:       raw.Age,
+		PDBPath:   pdbPath,
+	}
+}
+
 // goFPOData converts the raw FPO data into a corresponding Go version.
 func goFPOData(raw pe.RawFPOData) FPOData {
 	// TODO: use binary literal
@@ -261,3 +433,30 @@ func goFPOData(raw pe.RawFPOData) FPOData {
 	}
 	return fpo
 }
+
+// ~~~ [ 13 - Delay Import Descriptor ] ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+
+// goDelayImportDirectory converts the raw delay-load import data directory into
+// a corresponding Go version.
+func (file *File) goDelayImportDirectory(raw pe.RawDelayImportDirectory) DelayImportDirectory {
+	// Convert absolute addresses (used when bit 0 of attributes is clear) to
+	// relative addresses.
+	relAddr := func(addr uint32) uint32 {
+		if raw.Attributes&0x1 != 0 || addr == 0 {
+			return addr
+		}
+		return addr - uint32(file.OptHdr.ImageBase)
+	}
+	nameAddr := file.OptHdr.ImageBase + uint64(relAddr(raw.NameRelAddr))
+	name := file.parseCString(nameAddr)
+	return DelayImportDirectory{
+		Attributes:          raw.Attributes,
+		Name:                name,
+		ModuleHandleRelAddr: relAddr(raw.ModuleHandleRelAddr),
+		IATRelAddr:          relAddr(raw.IATRelAddr),
+		INTRelAddr:          relAddr(raw.INTRelAddr),
+		BoundIATRelAddr:     relAddr(raw.BoundIATRelAddr),
+		UnloadIATRelAddr:    relAddr(raw.UnloadIATRelAddr),
+		Date:                parseDateFromEpoch(raw.Date),
+	}
+}
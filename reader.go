@@ -0,0 +1,42 @@
+package pe
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Size returns the size of the PE file in bytes.
+func (file *File) Size() int64 {
+	if file.r != nil {
+		return file.size
+	}
+	return int64(len(file.Content))
+}
+
+// ReadAt reads len(p) bytes of the PE file into p, starting at the given file
+// offset. It implements the io.ReaderAt interface, regardless of whether the
+// file contents are loaded into memory.
+func (file *File) ReadAt(p []byte, off int64) (int, error) {
+	if file.r != nil {
+		return io.NewSectionReader(file.r, 0, file.size).ReadAt(p, off)
+	}
+	if off < 0 {
+		return 0, errors.Errorf("invalid file offset %d; expected >= 0", off)
+	}
+	if off >= int64(len(file.Content)) {
+		return 0, io.EOF
+	}
+	n := copy(p, file.Content[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// SectionReader returns a reader of the raw data of the given section, as
+// stored on file; or of the section contents in memory for mapped images. The
+// data is read on demand.
+func (file *File) SectionReader(sectHdr SectionHeader) *io.SectionReader {
+	return io.NewSectionReader(file, int64(file.sectionOffset(sectHdr)), int64(file.sectionSize(sectHdr)))
+}
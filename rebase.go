@@ -0,0 +1,418 @@
+package pe
+
+import (
+	"encoding/binary"
+
+	"github.com/mewmew/pe/enum"
+	"github.com/pkg/errors"
+)
+
+// --- [ Rebase ] --------------------------------------------------------------
+
+// Rebase relocates the PE file to the given image base, by applying the base
+// relocations of the file to its contents. The image base of the optional
+// header is updated accordingly.
+//
+// The file contents are modified in place; parse a copy of the contents to
+// keep the original intact. Files opened with NewFile have no contents in
+// memory to modify; use RebaseImage instead.
+func (file *File) Rebase(newBase uint64) error {
+	if file.Content == nil {
+		return errors.New("unable to rebase PE file; file contents not loaded into memory")
+	}
+	mem := func(relAddr uint32, n uint32) ([]byte, error) {
+		offset, ok := file.fileOffset(relAddr, n)
+		if !ok {
+			return nil, errors.Errorf("unable to locate file data at relative address 0x%08X (%d bytes)", relAddr, n)
+		}
+		return file.Content[offset : offset+n], nil
+	}
+	delta := newBase - file.OptHdr.ImageBase
+	if err := file.applyBaseRelocs(mem, delta); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := file.writeImageBase(file.Content, newBase); err != nil {
+		return errors.WithStack(err)
+	}
+	file.OptHdr.ImageBase = newBase
+	return nil
+}
+
+// RebaseImage relocates the in-memory image of the PE file (as returned by
+// Image) to the given image base, by applying the base relocations of the file
+// to the image. The current image base is read from the optional header of the
+// image, and updated accordingly.
+//
+// The image is modified in place.
+func (file *File) RebaseImage(image []byte, newBase uint64) error {
+	mem := func(relAddr uint32, n uint32) ([]byte, error) {
+		end := uint64(relAddr) + uint64(n)
+		if end > uint64(len(image)) {
+			return nil, errors.Errorf("unable to locate image data at relative address 0x%08X (%d bytes)", relAddr, n)
+		}
+		return image[relAddr:end], nil
+	}
+	oldBase, err := file.readImageBase(image)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	delta := newBase - oldBase
+	if err := file.applyBaseRelocs(mem, delta); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := file.writeImageBase(image, newBase); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// imageBaseOffset returns the offset of the image base field within the
+// headers of the PE file, and the size of the field in bytes.
+func (file *File) imageBaseOffset(hdr []byte) (offset, size uint32, err error) {
+	if len(hdr) < 0x40 {
+		return 0, 0, errors.Errorf("unable to locate optional header; headers too short (%d bytes)", len(hdr))
+	}
+	// Offset of PE signature.
+	offset = binary.LittleEndian.Uint32(hdr[0x3C:])
+	// Skip PE signature and COFF file header.
+	offset += 4 + 20
+	switch file.OptHdr.Magic {
+	case magic32:
+		offset, size = offset+28, 4
+	case magic64:
+		offset, size = offset+24, 8
+	default:
+		return 0, 0, errors.Errorf("invalid optional header magic number; expected 0x%04X or 0x%04X, got 0x%04X", magic32, magic64, file.OptHdr.Magic)
+	}
+	if uint64(offset)+uint64(size) > uint64(len(hdr)) {
+		return 0, 0, errors.Errorf("unable to locate image base at offset 0x%08X; headers too short (%d bytes)", offset, len(hdr))
+	}
+	return offset, size, nil
+}
+
+// readImageBase reads the image base of the optional header located within
+// hdr.
+func (file *File) readImageBase(hdr []byte) (uint64, error) {
+	offset, size, err := file.imageBaseOffset(hdr)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	if size == 4 {
+		return uint64(binary.LittleEndian.Uint32(hdr[offset:])), nil
+	}
+	return binary.LittleEndian.Uint64(hdr[offset:]), nil
+}
+
+// writeImageBase writes the image base of the optional header located within
+// hdr.
+func (file *File) writeImageBase(hdr []byte, imageBase uint64) error {
+	offset, size, err := file.imageBaseOffset(hdr)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if size == 4 {
+		binary.LittleEndian.PutUint32(hdr[offset:], uint32(imageBase))
+		return nil
+	}
+	binary.LittleEndian.PutUint64(hdr[offset:], imageBase)
+	return nil
+}
+
+// applyBaseRelocs applies the base relocations of the PE file, adding delta to
+// each relocated value. The mem function returns the n bytes of memory located
+// at the given relative address (relative to image base).
+func (file *File) applyBaseRelocs(mem func(relAddr, n uint32) ([]byte, error), delta uint64) error {
+	if delta == 0 {
+		return nil
+	}
+	blocks, err := file.BaseRelocs()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	r := &relocator{
+		machine: file.FileHdr.Machine,
+		mem:     mem,
+		delta:   delta,
+	}
+	for _, block := range blocks {
+		if err := r.applyBlock(block); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// relocator applies base relocations to memory.
+type relocator struct {
+	// Target CPU type.
+	machine enum.MachineType
+	// Returns the n bytes of memory located at the given relative address.
+	mem func(relAddr, n uint32) ([]byte, error)
+	// Difference between new and old image base.
+	delta uint64
+	// Memory of pending RISC-V HIGH20 relocation; or nil if not present.
+	high20 []byte
+}
+
+// applyBlock applies the base relocations of the given block.
+func (r *relocator) applyBlock(block BaseRelocBlock) error {
+	for i := 0; i < len(block.Entries); i++ {
+		entry := block.Entries[i]
+		relAddr := block.PageRelAddr + uint32(entry.Offset)
+		switch entry.Type {
+		case enum.BaseRelocTypeAbsolute:
+			// Padding; skip.
+		case enum.BaseRelocTypeHigh:
+			buf, err := r.mem(relAddr, 2)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			v := uint32(binary.LittleEndian.Uint16(buf)) << 16
+			v += uint32(r.delta)
+			binary.LittleEndian.PutUint16(buf, uint16(v>>16))
+		case enum.BaseRelocTypeLow:
+			buf, err := r.mem(relAddr, 2)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			v := binary.LittleEndian.Uint16(buf)
+			v += uint16(r.delta)
+			binary.LittleEndian.PutUint16(buf, v)
+		case enum.BaseRelocTypeHighLow:
+			buf, err := r.mem(relAddr, 4)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			v := binary.LittleEndian.Uint32(buf)
+			v += uint32(r.delta)
+			binary.LittleEndian.PutUint32(buf, v)
+		case enum.BaseRelocTypeHighAdj:
+			// The low 16 bits of the 32-bit value are stored in the slot of the
+			// following base relocation entry.
+			i++
+			if i >= len(block.Entries) {
+				return errors.Errorf("missing low 16 bits of HIGHADJ base relocation at relative address 0x%08X", relAddr)
+			}
+			next := block.Entries[i]
+			low := uint16(next.Type)<<12 | next.Offset
+			buf, err := r.mem(relAddr, 2)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			v := uint32(binary.LittleEndian.Uint16(buf)) << 16
+			v += uint32(int32(int16(low)))
+			v += uint32(r.delta)
+			v += 0x8000 // round high 16 bits.
+			binary.LittleEndian.PutUint16(buf, uint16(v>>16))
+		case enum.BaseRelocTypeDir64:
+			buf, err := r.mem(relAddr, 8)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			v := binary.LittleEndian.Uint64(buf)
+			v += r.delta
+			binary.LittleEndian.PutUint64(buf, v)
+		default:
+			if err := r.applyMachineSpecific(entry.Type, relAddr); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+	if r.high20 != nil {
+		// RISC-V HIGH20 base relocation not followed by LOW12I or LOW12S; relocate
+		// high 20 bits on their own, rounded to account for a sign-extended low
+		// part.
+		inst := binary.LittleEndian.Uint32(r.high20)
+		hi := inst&0xFFFFF000 + (uint32(r.delta)+0x800)&0xFFFFF000
+		binary.LittleEndian.PutUint32(r.high20, hi|inst&0x00000FFF)
+		r.high20 = nil
+	}
+	return nil
+}
+
+// applyMachineSpecific applies the machine specific base relocation of the
+// given type, located at the specified relative address.
+func (r *relocator) applyMachineSpecific(typ enum.BaseRelocType, relAddr uint32) error {
+	switch {
+	case typ == enum.BaseRelocTypeARMMov32 && isARM(r.machine):
+		// MOVW followed by MOVT (ARM encoding).
+		buf, err := r.mem(relAddr, 8)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		movw := binary.LittleEndian.Uint32(buf[0:])
+		movt := binary.LittleEndian.Uint32(buf[4:])
+		v := uint32(armImm16(movt))<<16 | uint32(armImm16(movw))
+		v += uint32(r.delta)
+		binary.LittleEndian.PutUint32(buf[0:], setARMImm16(movw, uint16(v)))
+		binary.LittleEndian.PutUint32(buf[4:], setARMImm16(movt, uint16(v>>16)))
+	case typ == enum.BaseRelocTypeThumbMov32 && isARM(r.machine):
+		// MOVW followed by MOVT (Thumb-2 encoding).
+		buf, err := r.mem(relAddr, 8)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		movw := thumbInst(buf[0:])
+		movt := thumbInst(buf[4:])
+		v := uint32(thumbImm16(movt))<<16 | uint32(thumbImm16(movw))
+		v += uint32(r.delta)
+		putThumbInst(buf[0:], setThumbImm16(movw, uint16(v)))
+		putThumbInst(buf[4:], setThumbImm16(movt, uint16(v>>16)))
+	case typ == enum.BaseRelocTypeMipsJmpAddr && isMIPS(r.machine):
+		buf, err := r.mem(relAddr, 4)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		inst := binary.LittleEndian.Uint32(buf)
+		target := (inst & 0x03FFFFFF) << 2
+		target += uint32(r.delta)
+		inst = inst&^0x03FFFFFF | (target>>2)&0x03FFFFFF
+		binary.LittleEndian.PutUint32(buf, inst)
+	case typ == enum.BaseRelocTypeRISCVHigh20 && isRISCV(r.machine):
+		// Relocated together with the following LOW12I or LOW12S base
+		// relocation.
+		buf, err := r.mem(relAddr, 4)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		r.high20 = buf
+	case (typ == enum.BaseRelocTypeRISCVLow12i || typ == enum.BaseRelocTypeRISCVLow12s) && isRISCV(r.machine):
+		if r.high20 == nil {
+			return errors.Errorf("missing HIGH20 base relocation preceding %v base relocation at relative address 0x%08X", typ, relAddr)
+		}
+		buf, err := r.mem(relAddr, 4)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		hiInst := binary.LittleEndian.Uint32(r.high20)
+		loInst := binary.LittleEndian.Uint32(buf)
+		var lo uint32
+		if typ == enum.BaseRelocTypeRISCVLow12i {
+			lo = riscvImmI(loInst)
+		} else {
+			lo = riscvImmS(loInst)
+		}
+		v := hiInst&0xFFFFF000 + lo
+		v += uint32(r.delta)
+		// Round high 20 bits, as the low 12 bits are sign-extended.
+		hiInst = (v+0x800)&0xFFFFF000 | hiInst&0x00000FFF
+		if typ == enum.BaseRelocTypeRISCVLow12i {
+			loInst = setRISCVImmI(loInst, v)
+		} else {
+			loInst = setRISCVImmS(loInst, v)
+		}
+		binary.LittleEndian.PutUint32(r.high20, hiInst)
+		binary.LittleEndian.PutUint32(buf, loInst)
+		r.high20 = nil
+	default:
+		return errors.Errorf("support for base relocation type %v of machine type %v not yet implemented", typ, r.machine)
+	}
+	return nil
+}
+
+// ### [ Helper functions ] ####################################################
+
+// isARM reports whether the given machine type is ARM or Thumb.
+func isARM(machine enum.MachineType) bool {
+	switch machine {
+	case enum.MachineTypeARM, enum.MachineTypeARMNT, enum.MachineTypeThumb:
+		return true
+	}
+	return false
+}
+
+// isMIPS reports whether the given machine type is MIPS.
+func isMIPS(machine enum.MachineType) bool {
+	switch machine {
+	case enum.MachineTypeMIPS16, enum.MachineTypeMIPSFPU, enum.MachineTypeMIPSFPU16, enum.MachineTypeR4000, enum.MachineTypeWCEMIPSv2:
+		return true
+	}
+	return false
+}
+
+// isRISCV reports whether the given machine type is RISC-V.
+func isRISCV(machine enum.MachineType) bool {
+	switch machine {
+	case enum.MachineTypeRISCV32, enum.MachineTypeRISCV64, enum.MachineTypeRISCV128:
+		return true
+	}
+	return false
+}
+
+// armImm16 returns the 16-bit immediate of the given ARM MOVW or MOVT
+// instruction.
+func armImm16(inst uint32) uint16 {
+	// imm4 : 4 bits (19:16)
+	// imm12: 12 bits (11:0)
+	return uint16(inst>>16&0xF<<12 | inst&0x0FFF)
+}
+
+// setARMImm16 sets the 16-bit immediate of the given ARM MOVW or MOVT
+// instruction.
+func setARMImm16(inst uint32, imm uint16) uint32 {
+	inst &^= 0x000F0FFF
+	return inst | uint32(imm)>>12<<16 | uint32(imm)&0x0FFF
+}
+
+// thumbInst returns the 32-bit Thumb-2 instruction stored in buf, with the
+// first halfword in the high 16 bits.
+func thumbInst(buf []byte) uint32 {
+	return uint32(binary.LittleEndian.Uint16(buf[0:]))<<16 | uint32(binary.LittleEndian.Uint16(buf[2:]))
+}
+
+// putThumbInst stores the 32-bit Thumb-2 instruction into buf, with the first
+// halfword in the high 16 bits.
+func putThumbInst(buf []byte, inst uint32) {
+	binary.LittleEndian.PutUint16(buf[0:], uint16(inst>>16))
+	binary.LittleEndian.PutUint16(buf[2:], uint16(inst))
+}
+
+// thumbImm16 returns the 16-bit immediate of the given Thumb-2 MOVW or MOVT
+// instruction.
+func thumbImm16(inst uint32) uint16 {
+	// imm4 : 4 bits (19:16)
+	// i    : 1 bit  (26)
+	// imm3 : 3 bits (14:12)
+	// imm8 : 8 bits (7:0)
+	imm4 := inst >> 16 & 0xF
+	i := inst >> 26 & 0x1
+	imm3 := inst >> 12 & 0x7
+	imm8 := inst & 0xFF
+	return uint16(imm4<<12 | i<<11 | imm3<<8 | imm8)
+}
+
+// setThumbImm16 sets the 16-bit immediate of the given Thumb-2 MOVW or MOVT
+// instruction.
+func setThumbImm16(inst uint32, imm uint16) uint32 {
+	inst &^= 0x040F70FF
+	v := uint32(imm)
+	return inst | v>>12&0xF<<16 | v>>11&0x1<<26 | v>>8&0x7<<12 | v&0xFF
+}
+
+// riscvImmI returns the sign-extended 12-bit immediate of the given RISC-V
+// I-type instruction.
+func riscvImmI(inst uint32) uint32 {
+	// imm[11:0] : 12 bits (31:20)
+	return uint32(int32(inst) >> 20)
+}
+
+// setRISCVImmI sets the low 12 bits of v as the immediate of the given RISC-V
+// I-type instruction.
+func setRISCVImmI(inst, v uint32) uint32 {
+	return inst&0x000FFFFF | v&0xFFF<<20
+}
+
+// riscvImmS returns the sign-extended 12-bit immediate of the given RISC-V
+// S-type instruction.
+func riscvImmS(inst uint32) uint32 {
+	// imm[11:5] : 7 bits (31:25)
+	// imm[4:0]  : 5 bits (11:7)
+	return uint32(int32(inst)>>25<<5) | inst>>7&0x1F
+}
+
+// setRISCVImmS sets the low 12 bits of v as the immediate of the given RISC-V
+// S-type instruction.
+func setRISCVImmS(inst, v uint32) uint32 {
+	inst &^= 0xFE000F80
+	return inst | v>>5&0x7F<<25 | v&0x1F<<7
+}
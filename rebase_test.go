@@ -0,0 +1,234 @@
+package pe
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mewmew/pe/enum"
+	"github.com/pkg/errors"
+)
+
+func TestThumbImm16(t *testing.T) {
+	golden := []struct {
+		// Thumb-2 instruction, with the first halfword in the high 16 bits.
+		inst uint32
+		imm  uint16
+	}{
+		// movw r0, #0x1234
+		{inst: 0xF2412034, imm: 0x1234},
+		// movw r3, #0xABCD
+		{inst: 0xF64A33CD, imm: 0xABCD},
+		// movt r3, #0xABCD
+		{inst: 0xF6CA33CD, imm: 0xABCD},
+		// movw r1, #0xFFFF
+		{inst: 0xF64F71FF, imm: 0xFFFF},
+		// movt r1, #0x0000
+		{inst: 0xF2C00100, imm: 0x0000},
+	}
+	for _, g := range golden {
+		got := thumbImm16(g.inst)
+		if got != g.imm {
+			t.Errorf("immediate mismatch of instruction 0x%08X; expected 0x%04X, got 0x%04X", g.inst, g.imm, got)
+		}
+		// Set immediate of instruction with cleared immediate.
+		inst := setThumbImm16(g.inst&^0x040F70FF, g.imm)
+		if inst != g.inst {
+			t.Errorf("instruction mismatch after setting immediate 0x%04X; expected 0x%08X, got 0x%08X", g.imm, g.inst, inst)
+		}
+	}
+}
+
+func TestApplyBaseRelocs(t *testing.T) {
+	golden := []struct {
+		name    string
+		machine enum.MachineType
+		entries []BaseRelocEntry
+		delta   uint64
+		// Memory before and after relocation.
+		mem  []byte
+		want []byte
+		// Expected error; empty if none.
+		err string
+	}{
+		// Thumb MOV32.
+		{
+			// movw r0, #0x5678; movt r0, #0x1234
+			// -> movw r0, #0xE678; movt r0, #0x2234
+			name:    "thumb-mov32",
+			machine: enum.MachineTypeARMNT,
+			entries: []BaseRelocEntry{{Type: enum.BaseRelocTypeThumbMov32, Offset: 0}},
+			delta:   0x10009000,
+			mem:     []byte{0x45, 0xF2, 0x78, 0x60, 0xC1, 0xF2, 0x34, 0x20},
+			want:    []byte{0x4E, 0xF2, 0x78, 0x60, 0xC2, 0xF2, 0x34, 0x20},
+		},
+		{
+			// movw r0, #0x5678; movt r0, #0x1234
+			// -> movw r0, #0x5E78; movt r0, #0x1234
+			name:    "thumb-mov32-i-bit",
+			machine: enum.MachineTypeARMNT,
+			entries: []BaseRelocEntry{{Type: enum.BaseRelocTypeThumbMov32, Offset: 0}},
+			delta:   0x800,
+			mem:     []byte{0x45, 0xF2, 0x78, 0x60, 0xC1, 0xF2, 0x34, 0x20},
+			want:    []byte{0x45, 0xF6, 0x78, 0x60, 0xC1, 0xF2, 0x34, 0x20},
+		},
+		{
+			// movw r1, #0xFFFF; movt r1, #0x0001
+			// -> movw r1, #0x0000; movt r1, #0x0002
+			name:    "thumb-mov32-carry",
+			machine: enum.MachineTypeARMNT,
+			entries: []BaseRelocEntry{{Type: enum.BaseRelocTypeThumbMov32, Offset: 0}},
+			delta:   0x1,
+			mem:     []byte{0x4F, 0xF6, 0xFF, 0x71, 0xC0, 0xF2, 0x01, 0x01},
+			want:    []byte{0x40, 0xF2, 0x00, 0x01, 0xC0, 0xF2, 0x02, 0x01},
+		},
+		// RISC-V HIGH20 followed by LOW12I.
+		{
+			// lui a0, 0x12345; addi a0, a0, 0x678
+			// -> lui a0, 0x12345; addi a0, a0, 0x778
+			name:    "riscv-low12i",
+			machine: enum.MachineTypeRISCV64,
+			entries: []BaseRelocEntry{
+				{Type: enum.BaseRelocTypeRISCVHigh20, Offset: 0},
+				{Type: enum.BaseRelocTypeRISCVLow12i, Offset: 4},
+			},
+			delta: 0x100,
+			mem:   []byte{0x37, 0x55, 0x34, 0x12, 0x13, 0x05, 0x85, 0x67},
+			want:  []byte{0x37, 0x55, 0x34, 0x12, 0x13, 0x05, 0x85, 0x77},
+		},
+		{
+			// lui a0, 0x12345; addi a0, a0, 0x678
+			// -> lui a0, 0x12346; addi a0, a0, -0x788
+			name:    "riscv-low12i-round-up",
+			machine: enum.MachineTypeRISCV64,
+			entries: []BaseRelocEntry{
+				{Type: enum.BaseRelocTypeRISCVHigh20, Offset: 0},
+				{Type: enum.BaseRelocTypeRISCVLow12i, Offset: 4},
+			},
+			delta: 0x200,
+			mem:   []byte{0x37, 0x55, 0x34, 0x12, 0x13, 0x05, 0x85, 0x67},
+			want:  []byte{0x37, 0x65, 0x34, 0x12, 0x13, 0x05, 0x85, 0x87},
+		},
+		{
+			// lui a0, 0x12346; addi a0, a0, -0x788
+			// -> lui a0, 0x12345; addi a0, a0, 0x678
+			name:    "riscv-low12i-round-down",
+			machine: enum.MachineTypeRISCV64,
+			entries: []BaseRelocEntry{
+				{Type: enum.BaseRelocTypeRISCVHigh20, Offset: 0},
+				{Type: enum.BaseRelocTypeRISCVLow12i, Offset: 4},
+			},
+			delta: 0xFFFFFFFFFFFFFE00, // -0x200
+			mem:   []byte{0x37, 0x65, 0x34, 0x12, 0x13, 0x05, 0x85, 0x87},
+			want:  []byte{0x37, 0x55, 0x34, 0x12, 0x13, 0x05, 0x85, 0x67},
+		},
+		// RISC-V HIGH20 followed by LOW12S.
+		{
+			// lui a0, 0x12345; sw a1, 0x678(a0)
+			// -> lui a0, 0x12346; sw a1, -0x788(a0)
+			name:    "riscv-low12s-round-up",
+			machine: enum.MachineTypeRISCV32,
+			entries: []BaseRelocEntry{
+				{Type: enum.BaseRelocTypeRISCVHigh20, Offset: 0},
+				{Type: enum.BaseRelocTypeRISCVLow12s, Offset: 4},
+			},
+			delta: 0x200,
+			mem:   []byte{0x37, 0x55, 0x34, 0x12, 0x23, 0x2C, 0xB5, 0x66},
+			want:  []byte{0x37, 0x65, 0x34, 0x12, 0x23, 0x2C, 0xB5, 0x86},
+		},
+		// RISC-V HIGH20 on its own.
+		{
+			// lui a0, 0x12345
+			// -> lui a0, 0x12347
+			name:    "riscv-high20",
+			machine: enum.MachineTypeRISCV64,
+			entries: []BaseRelocEntry{{Type: enum.BaseRelocTypeRISCVHigh20, Offset: 0}},
+			delta:   0x1900,
+			mem:     []byte{0x37, 0x55, 0x34, 0x12},
+			want:    []byte{0x37, 0x75, 0x34, 0x12},
+		},
+		{
+			name:    "riscv-low12i-missing-high20",
+			machine: enum.MachineTypeRISCV64,
+			entries: []BaseRelocEntry{{Type: enum.BaseRelocTypeRISCVLow12i, Offset: 0}},
+			delta:   0x100,
+			mem:     []byte{0x13, 0x05, 0x85, 0x67},
+			err:     "missing HIGH20 base relocation",
+		},
+		// HIGHADJ; the low 16 bits are stored in the slot of the following
+		// entry.
+		{
+			// 0x1234<<16 + 0x1234 -> 0x1235<<16 + 0x1234
+			name:    "highadj",
+			machine: enum.MachineTypeMIPSFPU,
+			entries: []BaseRelocEntry{
+				{Type: enum.BaseRelocTypeHighAdj, Offset: 0},
+				{Type: 0x1, Offset: 0x234},
+			},
+			delta: 0x10000,
+			mem:   []byte{0x34, 0x12},
+			want:  []byte{0x35, 0x12},
+		},
+		{
+			// 0x1234<<16 - 0x8000 + 0x10000 -> 0x1235<<16 - 0x8000
+			name:    "highadj-negative-low",
+			machine: enum.MachineTypeMIPSFPU,
+			entries: []BaseRelocEntry{
+				{Type: enum.BaseRelocTypeHighAdj, Offset: 0},
+				{Type: 0x8, Offset: 0x000},
+			},
+			delta: 0x10000,
+			mem:   []byte{0x34, 0x12},
+			want:  []byte{0x35, 0x12},
+		},
+		{
+			// 0x1234<<16 + 0x7000 + 0x1000 = 0x12348000; the high 16 bits are
+			// rounded to account for a sign-extended low part -> 0x1235
+			name:    "highadj-round-up",
+			machine: enum.MachineTypeMIPSFPU,
+			entries: []BaseRelocEntry{
+				{Type: enum.BaseRelocTypeHighAdj, Offset: 0},
+				{Type: 0x7, Offset: 0x000},
+			},
+			delta: 0x1000,
+			mem:   []byte{0x34, 0x12},
+			want:  []byte{0x35, 0x12},
+		},
+		{
+			name:    "highadj-missing-low",
+			machine: enum.MachineTypeMIPSFPU,
+			entries: []BaseRelocEntry{{Type: enum.BaseRelocTypeHighAdj, Offset: 0}},
+			delta:   0x10000,
+			mem:     []byte{0x34, 0x12},
+			err:     "missing low 16 bits of HIGHADJ",
+		},
+	}
+	for _, g := range golden {
+		mem := append([]byte(nil), g.mem...)
+		r := &relocator{
+			machine: g.machine,
+			mem: func(relAddr, n uint32) ([]byte, error) {
+				if uint64(relAddr)+uint64(n) > uint64(len(mem)) {
+					return nil, errors.Errorf("unable to read memory at relative address 0x%08X (%d bytes)", relAddr, n)
+				}
+				return mem[relAddr : relAddr+n], nil
+			},
+			delta: g.delta,
+		}
+		block := BaseRelocBlock{Entries: g.entries}
+		err := r.applyBlock(block)
+		if len(g.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), g.err) {
+				t.Errorf("%s: error mismatch; expected %q, got %v", g.name, g.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unable to apply base relocations; %+v", g.name, err)
+			continue
+		}
+		if !bytes.Equal(mem, g.want) {
+			t.Errorf("%s: memory mismatch; expected % X, got % X", g.name, g.want, mem)
+		}
+	}
+}
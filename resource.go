@@ -1,8 +1,19 @@
 package pe
 
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
 // --- [ Resource ] ------------------------------------------------------------
 
 // ResourceDirectory is a resource directory.
+//
+// The resource tree of a PE file has three levels of resource directories;
+// the entries of the root directory are identified by resource type, the
+// entries of second level directories by resource name, and the entries of
+// third level directories by language ID.
 type ResourceDirectory struct {
 	// Reserved.
 	Characteristics uint32
@@ -16,30 +27,196 @@ type ResourceDirectory struct {
 	NNamedEntries uint16
 	// Number of ID entries.
 	NIDEntries uint16
+	// Entries of the resource directory; named entries precede ID entries.
+	Entries []ResourceDirectoryEntry
+}
 
-	//Children []ResourceDirectoryEntry
-	//  IMAGE_RESOURCE_DIRECTORY_ENTRY DirectoryEntries[];
+// ResourceDirectoryEntry is an entry of a resource directory, identified by
+// name or ID.
+type ResourceDirectoryEntry struct {
+	// Name or ID of the entry.
+	ResourceID
+	// Subdirectory of the entry; or nil if the entry is a leaf.
+	Dir *ResourceDirectory
+	// Resource data of the entry; or nil if the entry is a subdirectory.
+	Data *ResourceData
 }
 
-/*
-type ResourceDirectoryEntry {
+// ResourceID identifies a resource directory entry (e.g. a resource type, name
+// or language); by name if present, and by ID otherwise.
+type ResourceID struct {
+	// Entry name; empty if identified by ID.
 	Name string
-	ID uint16
-
-    union {
-        struct {
-            DWORD NameOffset:31;
-            DWORD NameIsString:1;
-        };
-        DWORD   Name;
-        WORD    Id;
-    };
-    union {
-        DWORD   OffsetToData;
-        struct {
-            DWORD   OffsetToDirectory:31;
-            DWORD   DataIsDirectory:1;
-        };
-    };
-}
-*/
+	// Entry ID (used if Name is empty).
+	ID uint32
+}
+
+// match reports whether the resource ID matches the given resource ID. Names
+// are compared case-insensitively, as done by the Windows loader.
+func (id ResourceID) match(other ResourceID) bool {
+	if len(id.Name) > 0 || len(other.Name) > 0 {
+		return strings.EqualFold(id.Name, other.Name)
+	}
+	return id.ID == other.ID
+}
+
+// less reports whether the resource ID sorts before the given resource ID, as
+// required by the resource directory format; named entries precede ID entries,
+// names are sorted case-insensitively and IDs in ascending order.
+func (id ResourceID) less(other ResourceID) bool {
+	switch {
+	case len(id.Name) > 0 && len(other.Name) > 0:
+		return strings.ToUpper(id.Name) < strings.ToUpper(other.Name)
+	case len(id.Name) > 0:
+		return true
+	case len(other.Name) > 0:
+		return false
+	}
+	return id.ID < other.ID
+}
+
+// ResourceData is the data of a resource (leaf of the resource tree).
+type ResourceData struct {
+	// Relative address of resource data, as parsed; zero for added resources.
+	DataRelAddr uint32
+	// Code page used to decode code point values within the resource data.
+	CodePage uint32
+	// Reserved.
+	Reserved uint32
+	// Contents of resource data.
+	Content []byte
+}
+
+// Lookup returns the resource data of the given resource type, name and
+// language, located in the resource tree with the given root directory. The
+// boolean return value indicates success.
+func (dir *ResourceDirectory) Lookup(typ, name, lang ResourceID) (*ResourceData, bool) {
+	nameDir := dir.subdir(typ)
+	if nameDir == nil {
+		return nil, false
+	}
+	langDir := nameDir.subdir(name)
+	if langDir == nil {
+		return nil, false
+	}
+	entry := langDir.entry(lang)
+	if entry == nil || entry.Data == nil {
+		return nil, false
+	}
+	return entry.Data, true
+}
+
+// Set adds or replaces the contents of the resource data of the given resource
+// type, name and language, located in the resource tree with the given root
+// directory. The code page of replaced resource data is kept.
+func (dir *ResourceDirectory) Set(typ, name, lang ResourceID, content []byte) {
+	nameDir := dir.addSubdir(typ)
+	langDir := nameDir.addSubdir(name)
+	entry := langDir.addEntry(lang)
+	// Replace subdirectories at the language level of malformed resource
+	// trees.
+	entry.Dir = nil
+	if entry.Data == nil {
+		entry.Data = &ResourceData{}
+	}
+	entry.Data.DataRelAddr = 0
+	entry.Data.Content = content
+}
+
+// Delete deletes the resource data of the given resource type, name and
+// language, located in the resource tree with the given root directory.
+// Directories left empty are deleted. The boolean return value indicates
+// whether the resource was present.
+func (dir *ResourceDirectory) Delete(typ, name, lang ResourceID) bool {
+	nameDir := dir.subdir(typ)
+	if nameDir == nil {
+		return false
+	}
+	langDir := nameDir.subdir(name)
+	if langDir == nil {
+		return false
+	}
+	if !langDir.deleteEntry(lang) {
+		return false
+	}
+	if len(langDir.Entries) == 0 {
+		nameDir.deleteEntry(name)
+	}
+	if len(nameDir.Entries) == 0 {
+		dir.deleteEntry(typ)
+	}
+	return true
+}
+
+// entry returns the entry of the resource directory with the given resource
+// ID, or nil if not present.
+func (dir *ResourceDirectory) entry(id ResourceID) *ResourceDirectoryEntry {
+	for i := range dir.Entries {
+		if dir.Entries[i].match(id) {
+			return &dir.Entries[i]
+		}
+	}
+	return nil
+}
+
+// subdir returns the subdirectory of the entry of the resource directory with
+// the given resource ID, or nil if not present.
+func (dir *ResourceDirectory) subdir(id ResourceID) *ResourceDirectory {
+	entry := dir.entry(id)
+	if entry == nil {
+		return nil
+	}
+	return entry.Dir
+}
+
+// addEntry returns the entry of the resource directory with the given resource
+// ID, adding it in sorted order if not present.
+func (dir *ResourceDirectory) addEntry(id ResourceID) *ResourceDirectoryEntry {
+	if entry := dir.entry(id); entry != nil {
+		return entry
+	}
+	i := sort.Search(len(dir.Entries), func(i int) bool {
+		return !dir.Entries[i].less(id)
+	})
+	dir.Entries = append(dir.Entries, ResourceDirectoryEntry{})
+	copy(dir.Entries[i+1:], dir.Entries[i:])
+	dir.Entries[i] = ResourceDirectoryEntry{ResourceID: id}
+	if len(id.Name) > 0 {
+		dir.NNamedEntries++
+	} else {
+		dir.NIDEntries++
+	}
+	return &dir.Entries[i]
+}
+
+// addSubdir returns the subdirectory of the entry of the resource directory
+// with the given resource ID, adding the entry and subdirectory if not present.
+func (dir *ResourceDirectory) addSubdir(id ResourceID) *ResourceDirectory {
+	entry := dir.addEntry(id)
+	if entry.Dir == nil {
+		// Replace resource data at the type and name levels of malformed
+		// resource trees.
+		entry.Data = nil
+		entry.Dir = &ResourceDirectory{Date: parseDateFromEpoch(0)}
+	}
+	return entry.Dir
+}
+
+// deleteEntry deletes the entry of the resource directory with the given
+// resource ID. The boolean return value indicates whether the entry was
+// present.
+func (dir *ResourceDirectory) deleteEntry(id ResourceID) bool {
+	for i := range dir.Entries {
+		if !dir.Entries[i].match(id) {
+			continue
+		}
+		dir.Entries = append(dir.Entries[:i], dir.Entries[i+1:]...)
+		if len(id.Name) > 0 {
+			dir.NNamedEntries--
+		} else {
+			dir.NIDEntries--
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,317 @@
+package pe
+
+import (
+	"encoding/binary"
+	"sort"
+	"unicode/utf16"
+
+	"github.com/mewmew/pe/enum"
+	"github.com/pkg/errors"
+)
+
+// --- [ Resource table rebuilding ] -------------------------------------------
+
+// Name of sections holding rebuilt resource tables.
+const resourceSectName = ".rsrc"
+
+// RebuildResources rebuilds the resource table of the PE file from its
+// resource tree, as returned by Resources and modified using the Set and
+// Delete methods of the root directory.
+//
+// The resource table is rebuilt in place if located at the start of a section
+// dedicated to the resource table, with room for the rebuilt table or last in
+// the file (the section is then resized). A section is dedicated to the
+// resource table if it holds no other data directories, and no contents past
+// the end of the original resource table. Otherwise, the rebuilt table is
+// written over the original table if no larger, preserving the remaining
+// contents of the section; or rebuilt in a new section, in which case the
+// section of the original resource table is removed if dedicated to it. The
+// resource table data directory is updated, and the checksum is cleared.
+//
+// The changes take effect when writing the PE file; see WriteTo.
+func (file *File) RebuildResources() error {
+	if err := file.checkLayout(); err != nil {
+		return errors.WithStack(err)
+	}
+	if len(file.DataDirs) <= dataDirResource {
+		return errors.Errorf("unable to rebuild resources; expected at least %d data directories, got %d", dataDirResource+1, len(file.DataDirs))
+	}
+	root, err := file.Resources()
+	if err != nil {
+		return errors.Wrap(err, "unable to parse existing resources")
+	}
+	if root == nil || len(root.Entries) == 0 {
+		file.DataDirs[dataDirResource] = DataDirectory{}
+		file.OptHdr.Checksum = 0
+		return nil
+	}
+	// The size of the resource table is independent of its relative address.
+	size := uint32(len(buildResourceTable(root, 0)))
+	sectName := resourceSectName
+	flags := enum.SectionFlagContainsInitializedData | enum.SectionFlagMemRead
+	oldDir := file.DataDirs[dataDirResource]
+	idx := -1
+	for i, sectHdr := range file.SectHdrs {
+		if oldDir.RelAddr != 0 && sectHdr.RelAddr == oldDir.RelAddr {
+			idx = i
+			sectName, flags = sectHdr.Name, sectHdr.Flags
+			break
+		}
+	}
+	dedicated := false
+	if idx != -1 {
+		usedSize, err := file.sectionUsedSize(idx)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		dedicated = !file.hasOtherDataDirs(idx, dataDirResource) && oldDir.Size >= usedSize
+	}
+	switch {
+	case dedicated && file.fitsSection(idx, size):
+		// Rebuild in place.
+		sectHdr := &file.SectHdrs[idx]
+		sectAlign := file.OptHdr.SectionAlign
+		if size > sectHdr.VirtualSize || alignUp(size, sectAlign) == alignUp(virtualSize(*sectHdr), sectAlign) {
+			sectHdr.VirtualSize = size
+			file.regions = nil
+		}
+		file.sectSrcs[idx].data = buildResourceTable(root, sectHdr.RelAddr)
+	case dedicated && idx == len(file.SectHdrs)-1:
+		// Resize last section.
+		if err := file.ResizeLastSection(size); err != nil {
+			return errors.WithStack(err)
+		}
+		file.sectSrcs[idx].data = buildResourceTable(root, file.SectHdrs[idx].RelAddr)
+	case idx != -1 && size <= oldDir.Size && file.fitsSection(idx, size):
+		// Overwrite original table, preserving the remaining contents of the
+		// section.
+		data, err := file.sectionContents(idx)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		buf := make([]byte, maxUint32(uint32(len(data)), size))
+		copy(buf, data)
+		// Clear the remainder of the original table.
+		for i := size; i < minUint32(oldDir.Size, uint32(len(buf))); i++ {
+			buf[i] = 0
+		}
+		copy(buf, buildResourceTable(root, file.SectHdrs[idx].RelAddr))
+		file.sectSrcs[idx].data = buf
+	default:
+		// Rebuild in new section, replacing the original section if dedicated
+		// to the resource table.
+		if dedicated {
+			if err := file.RemoveSection(idx); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		relAddr := file.imageEnd()
+		sectHdr, err := file.AddSection(sectName, flags, buildResourceTable(root, relAddr))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if sectHdr.RelAddr != relAddr {
+			file.sectSrcs[len(file.sectSrcs)-1].data = buildResourceTable(root, sectHdr.RelAddr)
+		}
+		file.DataDirs[dataDirResource] = DataDirectory{RelAddr: sectHdr.RelAddr, Size: size}
+		return nil
+	}
+	file.DataDirs[dataDirResource] = DataDirectory{RelAddr: file.SectHdrs[idx].RelAddr, Size: size}
+	file.OptHdr.Checksum = 0
+	return nil
+}
+
+// fitsSection reports whether the section with the given index has room for n
+// bytes of contents, without changing the layout of sections.
+func (file *File) fitsSection(idx int, n uint32) bool {
+	sectHdr := file.SectHdrs[idx]
+	return n <= sectHdr.DataSize && n <= alignUp(virtualSize(sectHdr), file.OptHdr.SectionAlign)
+}
+
+// sectionUsedSize returns the size in bytes of the contents of the section with
+// the given index, excluding trailing zero bytes.
+func (file *File) sectionUsedSize(idx int) (uint32, error) {
+	data, err := file.sectionContents(idx)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	n := minUint32(uint32(len(data)), virtualSize(file.SectHdrs[idx]))
+	for n > 0 && data[n-1] == 0 {
+		n--
+	}
+	return n, nil
+}
+
+// hasOtherDataDirs reports whether data directories other than the given one
+// are located in the section with the given index.
+func (file *File) hasOtherDataDirs(idx, dataDirIdx int) bool {
+	sectHdr := file.SectHdrs[idx]
+	end := sectHdr.RelAddr + virtualSize(sectHdr)
+	for i, dataDir := range file.DataDirs {
+		// The certificate table is located by file offset.
+		if i == dataDirIdx || i == dataDirCert || dataDir.RelAddr == 0 {
+			continue
+		}
+		if sectHdr.RelAddr <= dataDir.RelAddr && dataDir.RelAddr < end {
+			return true
+		}
+	}
+	return false
+}
+
+// buildResourceTable builds a resource table of the resource tree with the
+// given root directory, located at the specified relative address.
+//
+// The resource table is laid out as resource directories (in breadth-first
+// order), resource data entries, resource directory strings and resource data;
+// resource data entries are aligned to 4 bytes, strings to 2 bytes and the
+// contents of resource data to 8 bytes. Directory entries are sorted as
+// required by the resource directory format.
+func buildResourceTable(root *ResourceDirectory, relAddr uint32) []byte {
+	const (
+		dirSize       = 16
+		entrySize     = 8
+		dataEntrySize = 16
+	)
+	// Compute layout.
+	var dirs []*ResourceDirectory
+	dirEntries := make(map[*ResourceDirectory][]ResourceDirectoryEntry)
+	dirOffsets := make(map[*ResourceDirectory]uint32)
+	offset := uint32(0)
+	for queue := []*ResourceDirectory{root}; len(queue) > 0; queue = queue[1:] {
+		dir := queue[0]
+		entries := sortedResourceEntries(dir)
+		dirs = append(dirs, dir)
+		dirEntries[dir] = entries
+		dirOffsets[dir] = offset
+		offset += dirSize + uint32(len(entries))*entrySize
+		for _, entry := range entries {
+			if entry.Dir == nil {
+				continue
+			}
+			// Skip directories shared between entries.
+			if _, ok := dirOffsets[entry.Dir]; !ok && !containsResourceDir(queue, entry.Dir) {
+				queue = append(queue, entry.Dir)
+			}
+		}
+	}
+	var datas []*ResourceData
+	dataEntryOffsets := make(map[*ResourceData]uint32)
+	for _, dir := range dirs {
+		for _, entry := range dirEntries[dir] {
+			if entry.Dir != nil || entry.Data == nil {
+				continue
+			}
+			if _, ok := dataEntryOffsets[entry.Data]; !ok {
+				datas = append(datas, entry.Data)
+				dataEntryOffsets[entry.Data] = offset
+				offset += dataEntrySize
+			}
+		}
+	}
+	var names []string
+	nameOffsets := make(map[string]uint32)
+	for _, dir := range dirs {
+		for _, entry := range dirEntries[dir] {
+			if len(entry.Name) == 0 {
+				continue
+			}
+			if _, ok := nameOffsets[entry.Name]; ok {
+				continue
+			}
+			names = append(names, entry.Name)
+			nameOffsets[entry.Name] = offset
+			offset += 2 + uint32(len(utf16.Encode([]rune(entry.Name))))*2
+		}
+	}
+	dataOffsets := make(map[*ResourceData]uint32)
+	for _, data := range datas {
+		offset = alignUp(offset, 8)
+		dataOffsets[data] = offset
+		offset += uint32(len(data.Content))
+	}
+	// Write contents.
+	buf := make([]byte, alignUp(offset, 8))
+	const highBit = 0x80000000
+	for _, dir := range dirs {
+		entries := dirEntries[dir]
+		b := buf[dirOffsets[dir]:]
+		var nnamed, nids uint16
+		for _, entry := range entries {
+			if len(entry.Name) > 0 {
+				nnamed++
+			} else {
+				nids++
+			}
+		}
+		var date uint32
+		if !dir.Date.IsZero() {
+			date = uint32(dir.Date.Unix())
+		}
+		binary.LittleEndian.PutUint32(b[0:], dir.Characteristics)
+		binary.LittleEndian.PutUint32(b[4:], date)
+		binary.LittleEndian.PutUint16(b[8:], dir.MajorVer)
+		binary.LittleEndian.PutUint16(b[10:], dir.MinorVer)
+		binary.LittleEndian.PutUint16(b[12:], nnamed)
+		binary.LittleEndian.PutUint16(b[14:], nids)
+		for i, entry := range entries {
+			e := b[dirSize+i*entrySize:]
+			if len(entry.Name) > 0 {
+				binary.LittleEndian.PutUint32(e[0:], highBit|nameOffsets[entry.Name])
+			} else {
+				binary.LittleEndian.PutUint32(e[0:], entry.ID)
+			}
+			switch {
+			case entry.Dir != nil:
+				binary.LittleEndian.PutUint32(e[4:], highBit|dirOffsets[entry.Dir])
+			case entry.Data != nil:
+				binary.LittleEndian.PutUint32(e[4:], dataEntryOffsets[entry.Data])
+			}
+		}
+	}
+	for _, data := range datas {
+		b := buf[dataEntryOffsets[data]:]
+		binary.LittleEndian.PutUint32(b[0:], relAddr+dataOffsets[data])
+		binary.LittleEndian.PutUint32(b[4:], uint32(len(data.Content)))
+		binary.LittleEndian.PutUint32(b[8:], data.CodePage)
+		binary.LittleEndian.PutUint32(b[12:], data.Reserved)
+		copy(buf[dataOffsets[data]:], data.Content)
+	}
+	for _, name := range names {
+		b := buf[nameOffsets[name]:]
+		u := utf16.Encode([]rune(name))
+		binary.LittleEndian.PutUint16(b, uint16(len(u)))
+		for i, x := range u {
+			binary.LittleEndian.PutUint16(b[2+i*2:], x)
+		}
+	}
+	return buf
+}
+
+// sortedResourceEntries returns the entries of the given resource directory,
+// sorted as required by the resource directory format. Entries with neither
+// subdirectory nor resource data are omitted.
+func sortedResourceEntries(dir *ResourceDirectory) []ResourceDirectoryEntry {
+	var entries []ResourceDirectoryEntry
+	for _, entry := range dir.Entries {
+		if entry.Dir == nil && entry.Data == nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].less(entries[j].ResourceID)
+	})
+	return entries
+}
+
+// containsResourceDir reports whether the given list of resource directories
+// contains dir.
+func containsResourceDir(dirs []*ResourceDirectory, dir *ResourceDirectory) bool {
+	for _, d := range dirs {
+		if d == dir {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+package pe
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/mewmew/pe/enum"
+)
+
+func TestRebuildResources(t *testing.T) {
+	const path = "testdata/gcc-386-mingw-no-symbols-exec"
+	orig, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%q: unable to read file; %v", path, err)
+	}
+	var (
+		rcdata  = ResourceID{ID: 10}
+		config  = ResourceID{Name: "CONFIG"}
+		one     = ResourceID{ID: 1}
+		english = ResourceID{ID: 0x409}
+	)
+	// Resource section dedicated to the resource table, as rebuilt in a new
+	// section.
+	dedicated, err := rebuildResources(orig, func(file *File, root *ResourceDirectory) error {
+		root.Set(rcdata, one, english, []byte("resource one"))
+		root.Set(rcdata, config, english, bytes.Repeat([]byte("config "), 16))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("%q: unable to rebuild resources; %+v", path, err)
+	}
+	// Resource section holding the resource table followed by other contents.
+	const marker = "contents after resource table"
+	shared, err := rebuildResources(orig, func(file *File, root *ResourceDirectory) error {
+		root.Set(rcdata, one, english, []byte("resource one"))
+		root.Set(rcdata, config, english, bytes.Repeat([]byte("config "), 16))
+		relAddr := file.imageEnd()
+		table := buildResourceTable(root, relAddr)
+		data := append(append([]byte(nil), table...), marker...)
+		if _, err := file.AddSection(resourceSectName, enum.SectionFlagContainsInitializedData|enum.SectionFlagMemRead, data); err != nil {
+			return err
+		}
+		file.DataDirs[dataDirResource] = DataDirectory{RelAddr: relAddr, Size: uint32(len(table))}
+		// Skip rebuild.
+		file.Rsrcs = nil
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("%q: unable to add resource section; %+v", path, err)
+	}
+	golden := []struct {
+		name    string
+		content []byte
+		edit    func(root *ResourceDirectory)
+		// Expected relative address of the rebuilt resource table, relative to
+		// the original resource table; or -1 if located in a new section.
+		delta int64
+		// Specifies whether the contents of the resource section after the
+		// original resource table are preserved.
+		preserved bool
+		// Specifies whether the rebuilt file is identical to the original.
+		same bool
+	}{
+		{name: "dedicated-unchanged", content: dedicated, same: true},
+		{name: "dedicated-smaller", content: dedicated, edit: func(root *ResourceDirectory) {
+			root.Delete(rcdata, config, english)
+		}},
+		// The section is last in the file, and is resized.
+		{name: "dedicated-larger", content: dedicated, edit: func(root *ResourceDirectory) {
+			root.Set(rcdata, ResourceID{ID: 2}, english, make([]byte, 0x3000))
+		}},
+		{name: "shared-unchanged", content: shared, preserved: true, same: true},
+		// The original table is overwritten.
+		{name: "shared-smaller", content: shared, preserved: true, edit: func(root *ResourceDirectory) {
+			root.Set(rcdata, one, english, []byte("one"))
+		}},
+		// The table is rebuilt in a new section, keeping the original section.
+		{name: "shared-larger", content: shared, preserved: true, delta: -1, edit: func(root *ResourceDirectory) {
+			root.Set(rcdata, ResourceID{ID: 2}, english, make([]byte, 0x3000))
+		}},
+	}
+	for _, g := range golden {
+		file, err := ParseBytes(g.content)
+		if err != nil {
+			t.Errorf("%s: unable to parse file; %+v", g.name, err)
+			continue
+		}
+		oldDir := file.DataDirs[dataDirResource]
+		nsects := len(file.SectHdrs)
+		var want map[string][]byte
+		buf, err := rebuildResources(g.content, func(file *File, root *ResourceDirectory) error {
+			if g.edit != nil {
+				g.edit(root)
+			}
+			want = resourceContents(root)
+			return nil
+		})
+		if err != nil {
+			t.Errorf("%s: unable to rebuild resources; %+v", g.name, err)
+			continue
+		}
+		if g.same && !bytes.Equal(buf, g.content) {
+			t.Errorf("%s: contents mismatch after rebuilding unchanged resources", g.name)
+		}
+		rebuilt, err := ParseBytes(buf)
+		if err != nil {
+			t.Errorf("%s: unable to parse rebuilt file; %+v", g.name, err)
+			continue
+		}
+		root, err := rebuilt.Resources()
+		if err != nil {
+			t.Errorf("%s: unable to parse rebuilt resources; %+v", g.name, err)
+			continue
+		}
+		if got := resourceContents(root); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: resources mismatch; expected %q, got %q", g.name, want, got)
+		}
+		newDir := rebuilt.DataDirs[dataDirResource]
+		switch {
+		case g.delta == -1:
+			last := rebuilt.SectHdrs[len(rebuilt.SectHdrs)-1]
+			if len(rebuilt.SectHdrs) != nsects+1 || newDir.RelAddr != last.RelAddr {
+				t.Errorf("%s: resource table at 0x%08X not located in new section %q at 0x%08X", g.name, newDir.RelAddr, last.Name, last.RelAddr)
+			}
+		case newDir.RelAddr != oldDir.RelAddr:
+			t.Errorf("%s: relative address mismatch of resource table; expected 0x%08X, got 0x%08X", g.name, oldDir.RelAddr, newDir.RelAddr)
+		}
+		if g.preserved {
+			got, err := rebuilt.ReadImage(oldDir.RelAddr+oldDir.Size, int64(len(marker)))
+			if err != nil {
+				t.Errorf("%s: unable to read image; %+v", g.name, err)
+			} else if string(got) != marker {
+				t.Errorf("%s: contents mismatch after resource table; expected %q, got %q", g.name, marker, got)
+			}
+		}
+	}
+}
+
+// rebuildResources parses the given PE file, edits its resource tree and
+// rebuilds its resource table, and returns the contents of the rebuilt file.
+// A resource tree is created if not present.
+func rebuildResources(content []byte, edit func(file *File, root *ResourceDirectory) error) ([]byte, error) {
+	file, err := ParseBytes(content)
+	if err != nil {
+		return nil, err
+	}
+	root, err := file.Resources()
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		root = &ResourceDirectory{}
+		file.Rsrcs = root
+	}
+	if err := edit(file, root); err != nil {
+		return nil, err
+	}
+	if file.Rsrcs != nil {
+		if err := file.RebuildResources(); err != nil {
+			return nil, err
+		}
+	}
+	buf := &bytes.Buffer{}
+	if _, err := file.WriteTo(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// resourceContents returns the contents of the resource data of the given
+// resource tree, indexed by resource path (e.g. "10/CONFIG/1033").
+func resourceContents(root *ResourceDirectory) map[string][]byte {
+	m := make(map[string][]byte)
+	var walk func(dir *ResourceDirectory, path string)
+	walk = func(dir *ResourceDirectory, path string) {
+		for _, entry := range dir.Entries {
+			name := fmt.Sprint(entry.ID)
+			if len(entry.Name) > 0 {
+				name = entry.Name
+			}
+			switch {
+			case entry.Dir != nil:
+				walk(entry.Dir, path+name+"/")
+			case entry.Data != nil:
+				m[path+name] = entry.Data.Content
+			}
+		}
+	}
+	if root != nil {
+		walk(root, "")
+	}
+	return m
+}
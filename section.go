@@ -0,0 +1,251 @@
+package pe
+
+import (
+	"github.com/mewmew/pe/enum"
+	"github.com/pkg/errors"
+)
+
+// --- [ Section builder ] -----------------------------------------------------
+
+// AddSection appends a section with the given name, section flags and raw
+// contents to the PE file, located after the last section both in memory and
+// on file. The headers are grown to fit the section header if needed, as long
+// as they precede the sections. The number of sections, the size of image and
+// headers and the file offsets of structures located in the overlay are
+// updated; and the checksum is cleared. The section header of the added
+// section is returned.
+//
+// The changes take effect when writing the PE file; see WriteTo.
+func (file *File) AddSection(name string, flags enum.SectionFlag, data []byte) (SectionHeader, error) {
+	if err := file.checkLayout(); err != nil {
+		return SectionHeader{}, errors.WithStack(err)
+	}
+	if len(name) > 8 {
+		return SectionHeader{}, errors.Errorf("invalid section name %q; longer than 8 bytes", name)
+	}
+	if len(data) == 0 {
+		return SectionHeader{}, errors.Errorf("invalid contents of section %q; empty", name)
+	}
+	if err := file.growHeaders(len(file.SectHdrs) + 1); err != nil {
+		return SectionHeader{}, errors.WithStack(err)
+	}
+	oldEnd := file.layoutEnd()
+	sectHdr := SectionHeader{
+		Name:        name,
+		VirtualSize: uint32(len(data)),
+		RelAddr:     file.imageEnd(),
+		DataSize:    alignUp(uint32(len(data)), file.OptHdr.FileAlign),
+		DataOffset:  alignUp(uint32(oldEnd), file.OptHdr.FileAlign),
+		Flags:       flags,
+	}
+	file.SectHdrs = append(file.SectHdrs, sectHdr)
+	file.sectSrcs = append(file.sectSrcs, sectionSource{data: append([]byte(nil), data...)})
+	if len(file.SectHdrs) > file.sectTableLen {
+		file.sectTableLen = len(file.SectHdrs)
+	}
+	file.updateLayout(oldEnd)
+	return sectHdr, nil
+}
+
+// ResizeLastSection resizes the last section of the PE file to the given size
+// in bytes; the raw data size of the section is aligned to the file alignment,
+// and padded with zeros. The last section must succeed the other sections both
+// in memory and on file. The size of image and the file offsets of structures
+// located in the overlay are updated; and the checksum is cleared.
+//
+// The changes take effect when writing the PE file; see WriteTo.
+func (file *File) ResizeLastSection(size uint32) error {
+	if err := file.checkLayout(); err != nil {
+		return errors.WithStack(err)
+	}
+	if len(file.SectHdrs) == 0 {
+		return errors.New("unable to resize last section; no sections present")
+	}
+	if size == 0 {
+		return errors.New("invalid section size 0; use RemoveSection to remove the section")
+	}
+	idx := len(file.SectHdrs) - 1
+	sectHdr := &file.SectHdrs[idx]
+	for _, other := range file.SectHdrs[:idx] {
+		if other.RelAddr >= sectHdr.RelAddr {
+			return errors.Errorf("unable to resize section %q; not last in memory (section %q at relative address 0x%08X)", sectHdr.Name, other.Name, other.RelAddr)
+		}
+		if sectHdr.DataSize != 0 && other.DataSize != 0 && other.DataOffset >= sectHdr.DataOffset {
+			return errors.Errorf("unable to resize section %q; not last on file (section %q at file offset 0x%08X)", sectHdr.Name, other.Name, other.DataOffset)
+		}
+	}
+	oldEnd := file.layoutEnd()
+	if sectHdr.DataSize != 0 {
+		data, err := file.sectionContents(idx)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if uint32(len(data)) > size {
+			data = data[:size]
+		}
+		if data == nil {
+			data = []byte{}
+		}
+		file.sectSrcs[idx].data = data
+		sectHdr.DataSize = alignUp(size, file.OptHdr.FileAlign)
+	}
+	sectHdr.VirtualSize = size
+	file.updateLayout(oldEnd)
+	return nil
+}
+
+// RemoveSection removes the section with the given index from the PE file,
+// clearing its raw data. Data directories located in the section are cleared.
+// The preceding section in memory is extended to cover the address range of
+// removed sections followed by other sections, to keep the image contiguous.
+// The number of sections, the size of image and the file offsets of structures
+// located in the overlay are updated; and the checksum is cleared.
+//
+// The changes take effect when writing the PE file; see WriteTo.
+func (file *File) RemoveSection(idx int) error {
+	if err := file.checkLayout(); err != nil {
+		return errors.WithStack(err)
+	}
+	if idx < 0 || idx >= len(file.SectHdrs) {
+		return errors.Errorf("invalid section index %d; expected < %d", idx, len(file.SectHdrs))
+	}
+	sectHdr := file.SectHdrs[idx]
+	start, end := sectHdr.RelAddr, sectHdr.RelAddr+alignUp(virtualSize(sectHdr), file.OptHdr.SectionAlign)
+	if start <= file.OptHdr.EntryRelAddr && file.OptHdr.EntryRelAddr < end {
+		return errors.Errorf("unable to remove section %q; contains entry point at relative address 0x%08X", sectHdr.Name, file.OptHdr.EntryRelAddr)
+	}
+	// Locate the preceding and succeeding sections in memory.
+	prev, hasNext := -1, false
+	for i, other := range file.SectHdrs {
+		switch {
+		case other.RelAddr < start:
+			if prev == -1 || other.RelAddr > file.SectHdrs[prev].RelAddr {
+				prev = i
+			}
+		case other.RelAddr > start:
+			hasNext = true
+		}
+	}
+	if hasNext {
+		if prev == -1 {
+			return errors.Errorf("unable to remove section %q; first section in memory followed by other sections", sectHdr.Name)
+		}
+		file.SectHdrs[prev].VirtualSize = end - file.SectHdrs[prev].RelAddr
+	}
+	for i, dataDir := range file.DataDirs {
+		// The certificate table is located by file offset.
+		if i == dataDirCert {
+			continue
+		}
+		if dataDir.RelAddr != 0 && start <= dataDir.RelAddr && dataDir.RelAddr < end {
+			file.DataDirs[i] = DataDirectory{}
+		}
+	}
+	oldEnd := file.layoutEnd()
+	file.removedSectHdrs = append(file.removedSectHdrs, sectHdr)
+	file.SectHdrs = append(file.SectHdrs[:idx], file.SectHdrs[idx+1:]...)
+	if idx < len(file.sectSrcs) {
+		file.sectSrcs = append(file.sectSrcs[:idx], file.sectSrcs[idx+1:]...)
+	}
+	file.updateLayout(oldEnd)
+	return nil
+}
+
+// checkLayout reports an error if the layout of sections of the PE file cannot
+// be changed.
+func (file *File) checkLayout() error {
+	if file.mapped {
+		return errors.New("unable to change sections of mapped image; unmap first")
+	}
+	if file.FileHdr == nil || file.OptHdr == nil {
+		return errors.New("unable to change sections; missing file header or optional header")
+	}
+	if file.OptHdr.FileAlign == 0 || file.OptHdr.SectionAlign == 0 {
+		return errors.Errorf("unable to change sections; invalid file alignment 0x%X or section alignment 0x%X", file.OptHdr.FileAlign, file.OptHdr.SectionAlign)
+	}
+	// Keep section contents in sync with section headers added directly.
+	for len(file.sectSrcs) < len(file.SectHdrs) {
+		file.sectSrcs = append(file.sectSrcs, sectionSource{})
+	}
+	return nil
+}
+
+// growHeaders grows the headers of the PE file to fit a section table of the
+// given number of section headers, as long as the headers precede the
+// sections both in memory and on file. The bound import table, commonly
+// located after the section table, is cleared if overlapped.
+func (file *File) growHeaders(nsects int) error {
+	oldEnd := file.sectHdrOffset(file.sectTableLen)
+	newEnd := file.sectHdrOffset(nsects)
+	if newEnd <= oldEnd {
+		return nil
+	}
+	// Fit the section table within the headers.
+	hdrsSize := file.OptHdr.HeadersSize
+	if uint32(newEnd) > hdrsSize {
+		hdrsSize = alignUp(uint32(newEnd), file.OptHdr.FileAlign)
+		for _, sectHdr := range file.SectHdrs {
+			if hdrsSize > sectHdr.RelAddr || (sectHdr.DataSize != 0 && hdrsSize > sectHdr.DataOffset) {
+				return errors.Errorf("unable to fit %d section headers (end offset 0x%X); no room left in headers before section %q", nsects, newEnd, sectHdr.Name)
+			}
+		}
+	}
+	// Clear the bound import table if overlapped; the imports are then bound
+	// by the loader.
+	var bound DataDirectory
+	if dataDirBoundImport < len(file.DataDirs) {
+		bound = file.DataDirs[dataDirBoundImport]
+		if bound.RelAddr != 0 && overlaps(uint32(oldEnd), uint32(newEnd-oldEnd), bound.RelAddr, bound.Size) {
+			file.DataDirs[dataDirBoundImport] = DataDirectory{}
+		} else {
+			bound = DataDirectory{}
+		}
+	}
+	// Require the remaining header area overlapped to be unused.
+	buf := make([]byte, newEnd-oldEnd)
+	file.ReadAt(buf, oldEnd)
+	for i, b := range buf {
+		offset := uint32(oldEnd) + uint32(i)
+		if b != 0 && !(bound.RelAddr <= offset && offset < bound.RelAddr+bound.Size) {
+			return errors.Errorf("unable to fit %d section headers (end offset 0x%X); header area at file offset 0x%X in use", nsects, newEnd, offset)
+		}
+	}
+	file.OptHdr.HeadersSize = hdrsSize
+	file.regions = nil
+	return nil
+}
+
+// imageEnd returns the end relative address of the last section in memory,
+// aligned to the section alignment; or of the headers if no sections present.
+func (file *File) imageEnd() uint32 {
+	sectAlign := file.OptHdr.SectionAlign
+	end := alignUp(file.OptHdr.HeadersSize, sectAlign)
+	for _, sectHdr := range file.SectHdrs {
+		end = maxUint32(end, alignUp(sectHdr.RelAddr+virtualSize(sectHdr), sectAlign))
+	}
+	return end
+}
+
+// updateLayout updates the headers of the PE file after the layout of sections
+// changes; the number of sections, the size of image and the file offsets of
+// structures located in the overlay (i.e. the COFF symbol table and the
+// certificate table), given the end offset of the headers and section contents
+// before the change. The checksum is cleared, as it is not verified for
+// user-mode executables.
+func (file *File) updateLayout(oldEnd uint64) {
+	file.FileHdr.NSections = uint16(len(file.SectHdrs))
+	file.OptHdr.ImageSize = file.imageEnd()
+	file.OptHdr.Checksum = 0
+	file.regions = nil
+	newEnd := file.layoutEnd()
+	move := func(offset uint32) uint32 {
+		if offset == 0 || uint64(offset) < oldEnd {
+			return offset
+		}
+		return uint32(uint64(offset) - oldEnd + newEnd)
+	}
+	file.FileHdr.SymbolTableOffset = move(file.FileHdr.SymbolTableOffset)
+	if dataDirCert < len(file.DataDirs) {
+		file.DataDirs[dataDirCert].RelAddr = move(file.DataDirs[dataDirCert].RelAddr)
+	}
+}
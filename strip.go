@@ -0,0 +1,240 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+
+	"github.com/mewmew/pe/enum"
+	"github.com/mewmew/pe/internal/pe"
+	"github.com/pkg/errors"
+)
+
+// --- [ Strip ] ---------------------------------------------------------------
+
+// StripOptions specifies the parts of a PE file removed by Strip.
+type StripOptions struct {
+	// Remove the debug directory and debug data (e.g. CodeView PDB paths).
+	Debug bool
+	// Remove the certificate table (e.g. Authenticode signatures).
+	Certs bool
+	// Remove the overlay, including the certificate table and COFF symbol
+	// table if located in the overlay.
+	Overlay bool
+	// Remove the COFF symbol table and string table.
+	Symbols bool
+	// Remove the Rich header.
+	Rich bool
+}
+
+// Strip removes the parts of the PE file specified by the given options,
+// leaving the PE file structurally valid. The data directories of removed
+// tables are cleared, the file characteristics are updated to indicate
+// stripped debug information and symbols, and the checksum is recomputed when
+// writing.
+//
+// Removed parts located in the overlay are cut from the file; other removed
+// parts are cleared with zeros. No parts are removed if opts is nil. The
+// changes take effect when writing the PE file; see WriteTo.
+func (file *File) Strip(opts *StripOptions) error {
+	if file.mapped {
+		return errors.New("unable to strip mapped image; unmap first")
+	}
+	if file.FileHdr == nil || file.OptHdr == nil {
+		return errors.New("unable to strip PE file; missing file header or optional header")
+	}
+	if opts == nil {
+		opts = &StripOptions{}
+	}
+	overlayOffset := minInt64(file.origEnd, file.Size())
+	symOffset, _ := file.origSymbolTable()
+	certTable := file.origCertTable()
+	if opts.Debug {
+		if err := file.stripDebug(); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if opts.Symbols || (opts.Overlay && symOffset >= overlayOffset) {
+		file.stripSymbols()
+	}
+	if opts.Certs || (opts.Overlay && int64(certTable.RelAddr) >= overlayOffset) {
+		file.stripCerts()
+	}
+	if opts.Overlay && overlayOffset < file.Size() {
+		file.removeContents(overlayOffset, file.Size()-overlayOffset)
+	}
+	if opts.Rich {
+		if start, end, ok := file.richHeaderRange(); ok {
+			file.removeContents(start, end-start)
+		}
+	}
+	file.fixChecksum = true
+	return nil
+}
+
+// stripDebug removes the debug directory and the debug data of each debug
+// directory entry.
+func (file *File) stripDebug() error {
+	if dataDirDebug >= len(file.DataDirs) {
+		return nil
+	}
+	dataDir := file.DataDirs[dataDirDebug]
+	if dataDir.RelAddr == 0 {
+		return nil
+	}
+	buf, err := file.ReadImage(dataDir.RelAddr, int64(dataDir.Size))
+	if err != nil {
+		return errors.Wrap(err, "unable to read debug directory")
+	}
+	r := bytes.NewReader(buf)
+	for r.Len() > 0 {
+		var raw pe.RawDebugDirectory
+		if err := binary.Read(r, binary.LittleEndian, &raw); err != nil {
+			// Ignore trailing bytes of malformed debug directories.
+			break
+		}
+		if raw.Offset != 0 && raw.Size != 0 {
+			file.removeContents(int64(raw.Offset), int64(raw.Size))
+		}
+	}
+	if offset, ok := file.fileOffset(dataDir.RelAddr, dataDir.Size); ok {
+		file.removeContents(int64(offset), int64(dataDir.Size))
+	}
+	file.DataDirs[dataDirDebug] = DataDirectory{}
+	file.FileHdr.Characteristics |= enum.CharacteristicDebugStripped
+	return nil
+}
+
+// stripSymbols removes the COFF symbol table and the string table following
+// it.
+func (file *File) stripSymbols() {
+	if file.FileHdr.SymbolTableOffset == 0 {
+		return
+	}
+	if offset, n := file.origSymbolTable(); offset != 0 {
+		file.removeContents(offset, n)
+	}
+	file.FileHdr.SymbolTableOffset = 0
+	file.FileHdr.NSymbols = 0
+	file.FileHdr.Characteristics |= enum.CharacteristicLineNumsStripped | enum.CharacteristicLocalSymsStripped
+}
+
+// stripCerts removes the certificate table.
+func (file *File) stripCerts() {
+	if dataDirCert >= len(file.DataDirs) || file.DataDirs[dataDirCert].RelAddr == 0 {
+		return
+	}
+	if certTable := file.origCertTable(); certTable.RelAddr != 0 {
+		file.removeContents(int64(certTable.RelAddr), int64(certTable.Size))
+	}
+	file.DataDirs[dataDirCert] = DataDirectory{}
+}
+
+// removeContents removes the given range of the original file contents when
+// writing. Ranges located in the overlay are cut, moving the COFF symbol table
+// and certificate table located after the range; other ranges are cleared.
+func (file *File) removeContents(offset, n int64) {
+	n = minInt64(n, file.Size()-offset)
+	if n <= 0 {
+		return
+	}
+	overlayOffset := minInt64(file.origEnd, file.Size())
+	if offset < overlayOffset {
+		file.clearedRanges = append(file.clearedRanges, contentRange{offset: offset, n: n})
+		return
+	}
+	end := offset + n
+	symOffset, _ := file.origSymbolTable()
+	certTable := file.origCertTable()
+	moveCert := dataDirCert < len(file.DataDirs) && file.DataDirs[dataDirCert].RelAddr != 0 && int64(certTable.RelAddr) >= end
+	cut := n
+	if moveCert {
+		// Keep the certificate table aligned to 8 bytes, as required by
+		// Authenticode.
+		cut = n &^ 7
+		if cut < n {
+			file.clearedRanges = append(file.clearedRanges, contentRange{offset: offset + cut, n: n - cut})
+		}
+		file.DataDirs[dataDirCert].RelAddr -= uint32(cut)
+	}
+	if cut == 0 {
+		return
+	}
+	if file.FileHdr.SymbolTableOffset != 0 && symOffset >= end {
+		file.FileHdr.SymbolTableOffset -= uint32(cut)
+	}
+	file.overlayCuts = append(file.overlayCuts, contentRange{offset: offset, n: cut})
+	sort.Slice(file.overlayCuts, func(i, j int) bool {
+		return file.overlayCuts[i].offset < file.overlayCuts[j].offset
+	})
+}
+
+// origSymbolTable returns the file offset and size in bytes of the COFF
+// symbol table and following string table, as parsed; or zero if not present.
+func (file *File) origSymbolTable() (offset, n int64) {
+	// PointerToSymbolTable and NumberOfSymbols of the COFF file header.
+	fileHdrOffset := file.optHdrOffset() - 20
+	var buf [8]byte
+	if _, err := file.ReadAt(buf[:], fileHdrOffset+8); err != nil {
+		return 0, 0
+	}
+	offset = int64(binary.LittleEndian.Uint32(buf[0:]))
+	if offset == 0 {
+		return 0, 0
+	}
+	const symSize = 18
+	strTabOffset := offset + int64(binary.LittleEndian.Uint32(buf[4:]))*symSize
+	n = strTabOffset - offset
+	// The first 4 bytes of the string table specify its size in bytes,
+	// including the size field.
+	if _, err := file.ReadAt(buf[:4], strTabOffset); err == nil {
+		n += int64(binary.LittleEndian.Uint32(buf[:4]))
+	}
+	return offset, minInt64(n, file.Size()-offset)
+}
+
+// origCertTable returns the certificate table data directory, as parsed; or
+// zero if not present.
+func (file *File) origCertTable() DataDirectory {
+	if dataDirCert >= len(file.DataDirs) {
+		return DataDirectory{}
+	}
+	var buf [8]byte
+	if _, err := file.ReadAt(buf[:], file.dataDirOffset(dataDirCert)); err != nil {
+		return DataDirectory{}
+	}
+	return DataDirectory{
+		RelAddr: binary.LittleEndian.Uint32(buf[0:]),
+		Size:    binary.LittleEndian.Uint32(buf[4:]),
+	}
+}
+
+// richHeaderRange returns the file offset range of the Rich header, from the
+// "DanS" signature up to and including the XOR key following the "Rich"
+// signature. The boolean return value indicates whether a Rich header was
+// located.
+func (file *File) richHeaderRange() (start, end int64, ok bool) {
+	// The Rich header is located between the MS-DOS stub and the PE header.
+	peOffset := file.optHdrOffset() - 20 - 4
+	const dosHdrSize = 0x40
+	if peOffset <= dosHdrSize {
+		return 0, 0, false
+	}
+	buf := make([]byte, peOffset)
+	if _, err := file.ReadAt(buf, 0); err != nil {
+		return 0, 0, false
+	}
+	richOffset := bytes.Index(buf, []byte("Rich"))
+	if richOffset == -1 || int64(richOffset)+8 > peOffset {
+		return 0, 0, false
+	}
+	key := binary.LittleEndian.Uint32(buf[richOffset+4:])
+	// "DanS" as little-endian 32-bit value.
+	const dansSig = 0x536E6144
+	for offset := richOffset - 4; offset >= dosHdrSize; offset -= 4 {
+		if binary.LittleEndian.Uint32(buf[offset:])^key == dansSig {
+			return int64(offset), int64(richOffset) + 8, true
+		}
+	}
+	return 0, 0, false
+}
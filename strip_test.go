@@ -0,0 +1,124 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+
+	"github.com/mewmew/pe/enum"
+)
+
+func TestStrip(t *testing.T) {
+	// The COFF symbol table of the executable is located in the overlay; a
+	// certificate table is appended to the overlay.
+	const path = "testdata/gcc-386-mingw-exec"
+	orig, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%q: unable to read file; %v", path, err)
+	}
+	file, err := ParseBytes(orig)
+	if err != nil {
+		t.Fatalf("%q: unable to parse file; %+v", path, err)
+	}
+	fileHdrOffset := file.optHdrOffset() - 20
+	checksumOffset := file.optHdrOffset() + optChecksumOffset
+	certDirOffset := file.dataDirOffset(dataDirCert)
+	// Signed file.
+	certOffset := int64(alignUp(uint32(len(orig)), 8))
+	cert := make([]byte, 0x100)
+	binary.LittleEndian.PutUint32(cert[0:], uint32(len(cert)))
+	binary.LittleEndian.PutUint16(cert[4:], 0x0200) // WIN_CERT_REVISION_2_0
+	binary.LittleEndian.PutUint16(cert[6:], 0x0002) // WIN_CERT_TYPE_PKCS_SIGNED_DATA
+	for i := 8; i < len(cert); i++ {
+		cert[i] = byte(i)
+	}
+	signed := make([]byte, certOffset)
+	copy(signed, orig)
+	signed = append(signed, cert...)
+	binary.LittleEndian.PutUint32(signed[certDirOffset:], uint32(certOffset))
+	binary.LittleEndian.PutUint32(signed[certDirOffset+4:], uint32(len(cert)))
+	binary.LittleEndian.PutUint32(signed[checksumOffset:], checksum(signed, checksumOffset))
+	// Symbol table and string table.
+	symOffset := int64(file.FileHdr.SymbolTableOffset)
+	strTabOffset := symOffset + int64(file.FileHdr.NSymbols)*18
+	symSize := strTabOffset - symOffset + int64(binary.LittleEndian.Uint32(orig[strTabOffset:]))
+	overlayOffset, ok := file.OverlayOffset()
+	if !ok || symOffset < overlayOffset || symOffset+symSize > certOffset {
+		t.Fatalf("%q: symbol table [0x%X, 0x%X) not located in overlay at 0x%X", path, symOffset, symOffset+symSize, overlayOffset)
+	}
+	// Expected contents.
+	withoutCert := func(buf []byte) []byte {
+		binary.LittleEndian.PutUint64(buf[certDirOffset:], 0)
+		return buf
+	}
+	withoutSyms := func(buf []byte) []byte {
+		binary.LittleEndian.PutUint32(buf[fileHdrOffset+8:], 0)
+		binary.LittleEndian.PutUint32(buf[fileHdrOffset+12:], 0)
+		chars := enum.Characteristic(binary.LittleEndian.Uint16(buf[fileHdrOffset+18:]))
+		chars |= enum.CharacteristicLineNumsStripped | enum.CharacteristicLocalSymsStripped
+		binary.LittleEndian.PutUint16(buf[fileHdrOffset+18:], uint16(chars))
+		return buf
+	}
+	clone := func(buf []byte) []byte {
+		return append([]byte(nil), buf...)
+	}
+	// The symbol table is cut in multiples of 8 bytes, keeping the certificate
+	// table aligned; the remainder is cleared.
+	cut := symSize &^ 7
+	symsCut := clone(signed[:symOffset])
+	symsCut = append(symsCut, make([]byte, symSize-cut)...)
+	symsCut = append(symsCut, signed[symOffset+symSize:]...)
+	symsCut = withoutSyms(symsCut)
+	binary.LittleEndian.PutUint32(symsCut[certDirOffset:], uint32(certOffset-cut))
+	golden := []struct {
+		name string
+		opts *StripOptions
+		want []byte
+	}{
+		{name: "nil", opts: nil, want: signed},
+		{name: "none", opts: &StripOptions{}, want: signed},
+		{name: "certs", opts: &StripOptions{Certs: true}, want: withoutCert(clone(signed[:certOffset]))},
+		{name: "symbols", opts: &StripOptions{Symbols: true}, want: symsCut},
+		{name: "overlay", opts: &StripOptions{Overlay: true}, want: withoutSyms(withoutCert(clone(signed[:overlayOffset])))},
+	}
+	for _, g := range golden {
+		file, err := ParseBytes(signed)
+		if err != nil {
+			t.Errorf("%s: unable to parse file; %+v", g.name, err)
+			continue
+		}
+		if err := file.Strip(g.opts); err != nil {
+			t.Errorf("%s: unable to strip file; %+v", g.name, err)
+			continue
+		}
+		buf := &bytes.Buffer{}
+		if _, err := file.WriteTo(buf); err != nil {
+			t.Errorf("%s: unable to write file; %+v", g.name, err)
+			continue
+		}
+		got := buf.Bytes()
+		// The checksum is recomputed.
+		want := clone(g.want)
+		binary.LittleEndian.PutUint32(want[checksumOffset:], checksum(want, checksumOffset))
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: contents mismatch; expected %d bytes, got %d bytes", g.name, len(want), len(got))
+			continue
+		}
+		stripped, err := ParseBytes(got)
+		if err != nil {
+			t.Errorf("%s: unable to parse stripped file; %+v", g.name, err)
+			continue
+		}
+		if sum := checksum(got, checksumOffset); stripped.OptHdr.Checksum != sum {
+			t.Errorf("%s: checksum mismatch; expected 0x%08X, got 0x%08X", g.name, sum, stripped.OptHdr.Checksum)
+		}
+		// The certificate table is intact, if kept.
+		if certDir := stripped.DataDirs[dataDirCert]; certDir.RelAddr != 0 {
+			end := int64(certDir.RelAddr) + int64(certDir.Size)
+			if certDir.RelAddr%8 != 0 || end > int64(len(got)) || !bytes.Equal(got[certDir.RelAddr:end], cert) {
+				t.Errorf("%s: certificate table mismatch at file offset 0x%X", g.name, certDir.RelAddr)
+			}
+		}
+	}
+}
@@ -0,0 +1,496 @@
+package pe
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+
+	"github.com/mewmew/pe/enum"
+	"github.com/pkg/errors"
+)
+
+// --- [ Unmap ] ---------------------------------------------------------------
+
+// UnmapOptions specifies options for rebuilding an on-disk PE file from an
+// in-memory image.
+type UnmapOptions struct {
+	// (optional) Exports of the DLLs loaded in the address space of the dumped
+	// process, used to rebuild the import table from the resolved addresses of
+	// the import address table. The import table is kept as is if nil.
+	Exports *ExportMap
+	// (optional) Relative address and size in bytes of the import address
+	// table, used when the import directories of the image are corrupt (e.g.
+	// destroyed by a packer). If zero, the import address tables of the import
+	// directories are used; or the IAT data directory if no import directories
+	// are present.
+	IATRelAddr uint32
+	IATSize    uint32
+	// (optional) Relative address of the original entry point (e.g. located
+	// while unpacking); the entry point is kept as is if zero.
+	EntryRelAddr uint32
+}
+
+// Name of sections holding rebuilt import tables (see Unmap and AddImports).
+const importSectName = ".idata2"
+
+// Unmap rebuilds an on-disk PE file from the in-memory image of a PE file
+// parsed with ParseMapped or NewMappedFile, and returns its contents.
+//
+// The raw data of each section is realigned to the file alignment, covering
+// the section contents in memory, and the raw data offset and size of section
+// headers are updated accordingly. When opts.Exports is set, the import table
+// is rebuilt in a new section from the resolved addresses of the import
+// address table, which is reset to the contents of the rebuilt import name
+// tables for the Windows loader to resolve.
+//
+// The image base of the optional header is kept, as updated by the Windows
+// loader; the base relocations of the image may thus be applied when loading
+// the rebuilt file.
+func (file *File) Unmap(opts *UnmapOptions) ([]byte, error) {
+	if !file.mapped {
+		return nil, errors.New("unable to unmap PE file; file contents not in mapped layout")
+	}
+	if opts == nil {
+		opts = &UnmapOptions{}
+	}
+	image := file.Image()
+	sectHdrs := append([]SectionHeader(nil), file.SectHdrs...)
+	// Data directories to update, indexed by data directory index.
+	dataDirs := make(map[int]DataDirectory)
+	if opts.Exports != nil {
+		runs, err := file.iatRuns(image, opts)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		dlls, err := resolveIATRuns(runs, opts.Exports, file.ptrSize())
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		// Append import section after the last section in memory, and after
+		// the end of the image contents.
+		end := uint64(maxUint32(file.OptHdr.HeadersSize, uint32(len(image))))
+		for _, sectHdr := range sectHdrs {
+			if sectEnd := uint64(sectHdr.RelAddr) + uint64(virtualSize(sectHdr)); sectEnd > end {
+				end = sectEnd
+			}
+		}
+		relAddr64 := alignUp64(end, uint64(file.OptHdr.SectionAlign))
+		if relAddr64 > math.MaxUint32 {
+			return nil, errors.Errorf("unable to add import section at relative address 0x%X; exceeds 32-bit address space", relAddr64)
+		}
+		relAddr := uint32(relAddr64)
+		table := buildImportTable(dlls, relAddr, file.ptrSize())
+		imageSize := alignUp64(uint64(relAddr)+uint64(len(table.data)), uint64(file.OptHdr.SectionAlign))
+		if imageSize > math.MaxUint32 {
+			return nil, errors.Errorf("unable to add import section of %d bytes at relative address 0x%08X; exceeds 32-bit address space", len(table.data), relAddr)
+		}
+		// Reset IAT to INT contents.
+		for i, dll := range dlls {
+			iatEnd := uint64(dll.iatRelAddr) + uint64(len(table.thunks[i]))*uint64(file.ptrSize())
+			if iatEnd > uint64(len(image)) {
+				return nil, errors.Errorf("invalid import address table of %q at relative address 0x%08X; end 0x%X exceeds image size 0x%X", dll.name, dll.iatRelAddr, iatEnd, len(image))
+			}
+			for j, thunk := range table.thunks[i] {
+				file.putPtr(image[dll.iatRelAddr+uint32(j)*file.ptrSize():], thunk)
+			}
+		}
+		sectHdr := SectionHeader{
+			Name:        importSectName,
+			VirtualSize: uint32(len(table.data)),
+			RelAddr:     relAddr,
+			Flags:       enum.SectionFlagContainsInitializedData | enum.SectionFlagMemRead,
+		}
+		sectHdrs = append(sectHdrs, sectHdr)
+		// Grow the image to hold the import section.
+		if imageSize > uint64(len(image)) {
+			image = append(image, make([]byte, imageSize-uint64(len(image)))...)
+		}
+		copy(image[relAddr:], table.data)
+		dataDirs[dataDirImport] = DataDirectory{RelAddr: relAddr, Size: table.dirSize}
+		// Bound imports are invalidated by the reset IAT.
+		dataDirs[dataDirBoundImport] = DataDirectory{}
+		if opts.IATSize != 0 {
+			dataDirs[dataDirIAT] = DataDirectory{RelAddr: opts.IATRelAddr, Size: opts.IATSize}
+		}
+	}
+	return file.unmapImage(image, sectHdrs, dataDirs, opts.EntryRelAddr)
+}
+
+// unmapImage returns the contents of an on-disk PE file based on the given
+// image, with the raw data of each section realigned to the file alignment.
+// The given data directories and entry point (if non-zero) are updated in the
+// headers.
+func (file *File) unmapImage(image []byte, sectHdrs []SectionHeader, dataDirs map[int]DataDirectory, entryRelAddr uint32) ([]byte, error) {
+	fileAlign := file.OptHdr.FileAlign
+	if fileAlign == 0 {
+		fileAlign = 0x200
+	}
+	// Grow the headers to fit the section table, as long as they precede the
+	// sections in memory.
+	hdrsSize := file.OptHdr.HeadersSize
+	sectTableEnd := uint32(file.sectHdrOffset(len(sectHdrs)))
+	if sectTableEnd > hdrsSize {
+		hdrsSize = alignUp(sectTableEnd, fileAlign)
+		for _, sectHdr := range sectHdrs {
+			if hdrsSize > sectHdr.RelAddr {
+				return nil, errors.Errorf("unable to fit %d section headers (end offset 0x%X) before section %q at relative address 0x%08X", len(sectHdrs), sectTableEnd, sectHdr.Name, sectHdr.RelAddr)
+			}
+		}
+	}
+	// Headers.
+	hdrSize := alignUp(hdrsSize, fileAlign)
+	buf := make([]byte, hdrSize)
+	copy(buf, image[:minUint32(hdrsSize, uint32(len(image)))])
+	// Sections.
+	for i := range sectHdrs {
+		sectHdr := &sectHdrs[i]
+		size := alignUp(virtualSize(*sectHdr), fileAlign)
+		if size == 0 {
+			sectHdr.DataOffset = 0
+			sectHdr.DataSize = 0
+			continue
+		}
+		sectHdr.DataOffset = uint32(len(buf))
+		sectHdr.DataSize = size
+		data := make([]byte, size)
+		if uint64(sectHdr.RelAddr) < uint64(len(image)) {
+			copy(data, image[sectHdr.RelAddr:])
+		}
+		buf = append(buf, data...)
+	}
+	// Update headers.
+	for i, sectHdr := range sectHdrs {
+		file.putSectHdr(buf[file.sectHdrOffset(i):], sectHdr)
+	}
+	nsectsOffset := file.optHdrOffset() - 20 + 2
+	binary.LittleEndian.PutUint16(buf[nsectsOffset:], uint16(len(sectHdrs)))
+	optHdrOffset := file.optHdrOffset()
+	imageSize := alignUp(uint32(len(image)), file.OptHdr.SectionAlign)
+	binary.LittleEndian.PutUint32(buf[optHdrOffset+optImageSizeOffset:], imageSize)
+	binary.LittleEndian.PutUint32(buf[optHdrOffset+optHeadersSizeOffset:], hdrsSize)
+	if entryRelAddr != 0 {
+		binary.LittleEndian.PutUint32(buf[optHdrOffset+optEntryRelAddrOffset:], entryRelAddr)
+	}
+	// Clear checksum, which is not verified for user-mode executables.
+	binary.LittleEndian.PutUint32(buf[optHdrOffset+optChecksumOffset:], 0)
+	for idx, dataDir := range dataDirs {
+		if idx >= len(file.DataDirs) {
+			continue
+		}
+		offset := file.dataDirOffset(idx)
+		binary.LittleEndian.PutUint32(buf[offset:], dataDir.RelAddr)
+		binary.LittleEndian.PutUint32(buf[offset+4:], dataDir.Size)
+	}
+	return buf, nil
+}
+
+// iatRun is a run of consecutive import address table entries, terminated by
+// a zero entry; i.e. the IAT of a single DLL.
+type iatRun struct {
+	// Relative address of the run.
+	relAddr uint32
+	// Resolved addresses of the entries of the run.
+	addrs []uint64
+	// (optional) DLL name, as specified by the import directory of the run.
+	dllName string
+}
+
+// iatRuns returns the runs of import address table entries of the given image.
+func (file *File) iatRuns(image []byte, opts *UnmapOptions) ([]iatRun, error) {
+	iatRelAddr, iatSize := opts.IATRelAddr, opts.IATSize
+	if iatSize == 0 {
+		imps, err := file.Imports()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if len(imps) > 0 {
+			var runs []iatRun
+			for _, imp := range imps {
+				run := iatRun{
+					relAddr: imp.ImpDir.IATRelAddr,
+					addrs:   imp.IATAddrs,
+					dllName: imp.ImpDir.Name,
+				}
+				runs = append(runs, run)
+			}
+			return runs, nil
+		}
+		if dataDirIAT < len(file.DataDirs) {
+			iatRelAddr = file.DataDirs[dataDirIAT].RelAddr
+			iatSize = file.DataDirs[dataDirIAT].Size
+		}
+	}
+	if iatSize == 0 {
+		return nil, errors.New("unable to locate import address table; no import directories or IAT data directory present")
+	}
+	end := uint64(iatRelAddr) + uint64(iatSize)
+	if end > uint64(len(image)) {
+		return nil, errors.Errorf("invalid import address table [0x%08X, 0x%08X); image size 0x%08X", iatRelAddr, end, len(image))
+	}
+	// Split IAT into runs, terminated by zero entries.
+	ptrSize := file.ptrSize()
+	var runs []iatRun
+	cur := iatRun{relAddr: iatRelAddr}
+	for relAddr := iatRelAddr; uint64(relAddr)+uint64(ptrSize) <= end; relAddr += ptrSize {
+		addr := file.ptr(image[relAddr:])
+		if addr == 0 {
+			if len(cur.addrs) > 0 {
+				runs = append(runs, cur)
+			}
+			cur = iatRun{relAddr: relAddr + ptrSize}
+			continue
+		}
+		cur.addrs = append(cur.addrs, addr)
+	}
+	if len(cur.addrs) > 0 {
+		runs = append(runs, cur)
+	}
+	return runs, nil
+}
+
+// resolveIATRuns resolves the entries of the given import address table runs
+// to the symbols exported at their addresses, and returns the DLLs imported by
+// each run.
+//
+// The DLL of each run is the DLL providing every symbol of the run, either
+// directly or through forwarders; the DLL specified by the import directory of
+// the run is preferred.
+func resolveIATRuns(runs []iatRun, exports *ExportMap, ptrSize uint32) ([]importDLL, error) {
+	var dlls []importDLL
+	for _, run := range runs {
+		// Candidate DLLs providing every symbol of the run, in order of
+		// preference.
+		var candidates []string
+		for i, addr := range run.addrs {
+			syms := exports.Lookup(addr)
+			if len(syms) == 0 {
+				return nil, errors.Errorf("unable to resolve import address table entry at relative address 0x%08X; no symbol exported at address 0x%X", run.relAddr+uint32(i)*ptrSize, addr)
+			}
+			var dllNames []string
+			for _, sym := range syms {
+				if i == 0 || containsFold(candidates, sym.DLL) {
+					dllNames = appendUniqueFold(dllNames, sym.DLL)
+				}
+			}
+			if len(dllNames) == 0 {
+				return nil, errors.Errorf("unable to resolve import address table entries at relative address 0x%08X to a single DLL; no DLL among %q exports symbol at address 0x%X", run.relAddr, candidates, addr)
+			}
+			candidates = dllNames
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+		dllName := candidates[0]
+		for _, candidate := range candidates {
+			if strings.EqualFold(trimDLLExt(candidate), trimDLLExt(run.dllName)) {
+				dllName = candidate
+				break
+			}
+		}
+		dll := importDLL{
+			name:       dllName,
+			iatRelAddr: run.relAddr,
+		}
+		for _, addr := range run.addrs {
+			for _, sym := range exports.Lookup(addr) {
+				if strings.EqualFold(sym.DLL, dllName) {
+					dll.syms = append(dll.syms, sym.intEntry())
+					break
+				}
+			}
+		}
+		dlls = append(dlls, dll)
+	}
+	return dlls, nil
+}
+
+// ~~~ [ Import table ] ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+
+// importDLL is a DLL of an import table being built.
+type importDLL struct {
+	// DLL name.
+	name string
+	// Imported symbols.
+	syms []INTEntry
+	// Relative address of the import address table of the DLL; allocated
+	// within the import table if zero.
+	iatRelAddr uint32
+}
+
+// importTable is an import table built for a given relative address.
+type importTable struct {
+	// Contents of the import table.
+	data []byte
+	// Size in bytes of the import directories, including the zero terminator.
+	dirSize uint32
+	// Import name table entries (thunks) of each DLL, excluding the zero
+	// terminator; also the initial contents of the import address tables.
+	thunks [][]uint64
+	// Relative addresses of the import address tables of each DLL.
+	iatRelAddrs []uint32
+}
+
+// buildImportTable builds an import table of the given DLLs, located at the
+// specified relative address.
+//
+// The import table is laid out as import directories, import name tables,
+// allocated import address tables, DLL names and hint/name entries.
+func buildImportTable(dlls []importDLL, relAddr uint32, ptrSize uint32) *importTable {
+	const impDirSize = 20
+	table := &importTable{
+		dirSize: uint32(len(dlls)+1) * impDirSize,
+	}
+	// Compute layout.
+	offset := table.dirSize
+	intOffsets := make([]uint32, len(dlls))
+	for i, dll := range dlls {
+		intOffsets[i] = offset
+		offset += uint32(len(dll.syms)+1) * ptrSize
+	}
+	table.iatRelAddrs = make([]uint32, len(dlls))
+	for i, dll := range dlls {
+		if dll.iatRelAddr != 0 {
+			table.iatRelAddrs[i] = dll.iatRelAddr
+			continue
+		}
+		table.iatRelAddrs[i] = relAddr + offset
+		offset += uint32(len(dll.syms)+1) * ptrSize
+	}
+	nameOffsets := make([]uint32, len(dlls))
+	for i, dll := range dlls {
+		nameOffsets[i] = offset
+		offset += uint32(len(dll.name) + 1)
+	}
+	// Hint/name entries are aligned to 2 bytes.
+	offset = alignUp(offset, 2)
+	hintNameOffsets := make([][]uint32, len(dlls))
+	for i, dll := range dlls {
+		hintNameOffsets[i] = make([]uint32, len(dll.syms))
+		for j, sym := range dll.syms {
+			if sym.IsOrdinal {
+				continue
+			}
+			hintNameOffsets[i][j] = offset
+			offset += alignUp(2+uint32(len(sym.NameEntry.Name))+1, 2)
+		}
+	}
+	// Write contents.
+	data := make([]byte, offset)
+	ordinalFlag := uint64(0x80000000)
+	if ptrSize == 8 {
+		ordinalFlag = 0x8000000000000000
+	}
+	table.thunks = make([][]uint64, len(dlls))
+	for i, dll := range dlls {
+		impDir := data[i*impDirSize:]
+		binary.LittleEndian.PutUint32(impDir[0:], relAddr+intOffsets[i])
+		binary.LittleEndian.PutUint32(impDir[12:], relAddr+nameOffsets[i])
+		binary.LittleEndian.PutUint32(impDir[16:], table.iatRelAddrs[i])
+		copy(data[nameOffsets[i]:], dll.name)
+		for j, sym := range dll.syms {
+			var thunk uint64
+			if sym.IsOrdinal {
+				thunk = ordinalFlag | uint64(sym.Ordinal)
+			} else {
+				thunk = uint64(relAddr + hintNameOffsets[i][j])
+				hintName := data[hintNameOffsets[i][j]:]
+				binary.LittleEndian.PutUint16(hintName, sym.NameEntry.Hint)
+				copy(hintName[2:], sym.NameEntry.Name)
+			}
+			table.thunks[i] = append(table.thunks[i], thunk)
+			putUintN(data[intOffsets[i]+uint32(j)*ptrSize:], thunk, ptrSize)
+			if dll.iatRelAddr == 0 {
+				iatOffset := table.iatRelAddrs[i] - relAddr
+				putUintN(data[iatOffset+uint32(j)*ptrSize:], thunk, ptrSize)
+			}
+		}
+	}
+	table.data = data
+	return table
+}
+
+// ### [ Helper functions ] ####################################################
+
+// ptrSize returns the pointer size in bytes of the PE file.
+func (file *File) ptrSize() uint32 {
+	if file.OptHdr.Magic == magic64 {
+		return 8
+	}
+	return 4
+}
+
+// ptr returns the pointer stored at the start of buf, based on the pointer
+// size of the PE file.
+func (file *File) ptr(buf []byte) uint64 {
+	if file.ptrSize() == 8 {
+		return binary.LittleEndian.Uint64(buf)
+	}
+	return uint64(binary.LittleEndian.Uint32(buf))
+}
+
+// putPtr stores the given pointer at the start of buf, based on the pointer
+// size of the PE file.
+func (file *File) putPtr(buf []byte, x uint64) {
+	putUintN(buf, x, file.ptrSize())
+}
+
+// putUintN stores the given value at the start of buf, as a little-endian
+// integer of n bytes (4 or 8).
+func putUintN(buf []byte, x uint64, n uint32) {
+	if n == 8 {
+		binary.LittleEndian.PutUint64(buf, x)
+		return
+	}
+	binary.LittleEndian.PutUint32(buf, uint32(x))
+}
+
+// putSectHdr stores the given section header at the start of buf.
+func (file *File) putSectHdr(buf []byte, sectHdr SectionHeader) {
+	var name [8]byte
+	copy(name[:], sectHdr.Name)
+	copy(buf[0:8], name[:])
+	binary.LittleEndian.PutUint32(buf[8:], sectHdr.VirtualSize)
+	binary.LittleEndian.PutUint32(buf[12:], sectHdr.RelAddr)
+	binary.LittleEndian.PutUint32(buf[16:], sectHdr.DataSize)
+	binary.LittleEndian.PutUint32(buf[20:], sectHdr.DataOffset)
+	binary.LittleEndian.PutUint32(buf[24:], sectHdr.RelocsOffset)
+	binary.LittleEndian.PutUint32(buf[28:], sectHdr.LineNumsOffset)
+	binary.LittleEndian.PutUint16(buf[32:], sectHdr.NRelocs)
+	binary.LittleEndian.PutUint16(buf[34:], sectHdr.NLineNums)
+	binary.LittleEndian.PutUint32(buf[36:], uint32(sectHdr.Flags))
+}
+
+// containsFold reports whether the given list contains s, ignoring case.
+func containsFold(list []string, s string) bool {
+	for _, x := range list {
+		if strings.EqualFold(x, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// appendUniqueFold appends s to the given list, unless already present
+// (ignoring case).
+func appendUniqueFold(list []string, s string) []string {
+	if containsFold(list, s) {
+		return list
+	}
+	return append(list, s)
+}
+
+// maxUint32 returns the maximum of x and y.
+func maxUint32(x, y uint32) uint32 {
+	if x > y {
+		return x
+	}
+	return y
+}
+
+// minUint32 returns the minimum of x and y.
+func minUint32(x, y uint32) uint32 {
+	if x < y {
+		return x
+	}
+	return y
+}
@@ -0,0 +1,95 @@
+package pe
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestUnmap(t *testing.T) {
+	const path = "testdata/gcc-386-mingw-exec"
+	file, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("%q: unable to parse file; %+v", path, err)
+	}
+	imps, err := file.Imports()
+	if err != nil {
+		t.Fatalf("%q: unable to parse imports; %+v", path, err)
+	}
+	// Resolve the import address tables of the image, as done by the Windows
+	// loader, and record the symbols exported at each resolved address.
+	orig := file.Image()
+	exports := &ExportMap{syms: make(map[uint64][]ExportSymbol)}
+	for i, imp := range imps {
+		base := uint64(0x70000000 + i*0x1000000)
+		for j, entry := range imp.INTs {
+			addr := base + uint64(0x1000+j*0x10)
+			binary.LittleEndian.PutUint32(orig[imp.ImpDir.IATRelAddr+uint32(j)*4:], uint32(addr))
+			sym := ExportSymbol{DLL: imp.ImpDir.Name, Ordinal: entry.Ordinal, Name: entry.NameEntry.Name, Hint: entry.NameEntry.Hint}
+			exports.syms[addr] = append(exports.syms[addr], sym)
+		}
+	}
+	golden := []struct {
+		name string
+		// Size of memory past the last section, added to the image and to
+		// SizeOfImage of the headers.
+		extraSize uint32
+	}{
+		{name: "unchanged"},
+		{name: "memory-past-sections", extraSize: 0x10000},
+	}
+	for _, g := range golden {
+		image := append(append([]byte(nil), orig...), make([]byte, g.extraSize)...)
+		imageSizeOffset := file.optHdrOffset() + optImageSizeOffset
+		binary.LittleEndian.PutUint32(image[imageSizeOffset:], file.OptHdr.ImageSize+g.extraSize)
+		mapped, err := ParseMapped(image)
+		if err != nil {
+			t.Errorf("%s: unable to parse mapped image; %+v", g.name, err)
+			continue
+		}
+		buf, err := mapped.Unmap(&UnmapOptions{Exports: exports})
+		if err != nil {
+			t.Errorf("%s: unable to unmap image; %+v", g.name, err)
+			continue
+		}
+		unmapped, err := ParseBytes(buf)
+		if err != nil {
+			t.Errorf("%s: unable to parse unmapped file; %+v", g.name, err)
+			continue
+		}
+		// The import section is located after the last section in memory, and
+		// after the end of the image.
+		last := mapped.SectHdrs[len(mapped.SectHdrs)-1]
+		end := maxUint32(last.RelAddr+virtualSize(last), uint32(len(image)))
+		sectHdr := unmapped.SectHdrs[len(unmapped.SectHdrs)-1]
+		if want := alignUp(end, file.OptHdr.SectionAlign); sectHdr.Name != importSectName || sectHdr.RelAddr != want {
+			t.Errorf("%s: import section mismatch; expected %q at 0x%08X, got %q at 0x%08X", g.name, importSectName, want, sectHdr.Name, sectHdr.RelAddr)
+		}
+		if want := alignUp(sectHdr.RelAddr+sectHdr.VirtualSize, file.OptHdr.SectionAlign); unmapped.OptHdr.ImageSize != want {
+			t.Errorf("%s: image size mismatch; expected 0x%X, got 0x%X", g.name, want, unmapped.OptHdr.ImageSize)
+		}
+		// The rebuilt import table imports the same symbols, using the
+		// original import address tables.
+		got, err := unmapped.Imports()
+		if err != nil {
+			t.Errorf("%s: unable to parse imports of unmapped file; %+v", g.name, err)
+			continue
+		}
+		if len(got) != len(imps) {
+			t.Errorf("%s: number of imported DLLs mismatch; expected %d, got %d", g.name, len(imps), len(got))
+			continue
+		}
+		for i, imp := range imps {
+			if got[i].ImpDir.Name != imp.ImpDir.Name || got[i].ImpDir.IATRelAddr != imp.ImpDir.IATRelAddr {
+				t.Errorf("%s: imported DLL %d mismatch; expected %q (IAT at 0x%08X), got %q (IAT at 0x%08X)", g.name, i, imp.ImpDir.Name, imp.ImpDir.IATRelAddr, got[i].ImpDir.Name, got[i].ImpDir.IATRelAddr)
+				continue
+			}
+			if !reflect.DeepEqual(got[i].INTs, imp.INTs) {
+				t.Errorf("%s: imported symbols of %q mismatch; expected %v, got %v", g.name, imp.ImpDir.Name, imp.INTs, got[i].INTs)
+			}
+			if !reflect.DeepEqual(got[i].IATs, got[i].INTs) {
+				t.Errorf("%s: import address table of %q mismatch; expected %v, got %v", g.name, imp.ImpDir.Name, got[i].INTs, got[i].IATs)
+			}
+		}
+	}
+}
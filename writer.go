@@ -0,0 +1,297 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sort"
+
+	"github.com/mewmew/pe/internal/pe"
+	"github.com/pkg/errors"
+)
+
+// WriteTo writes the PE file to w, serializing the file header, optional
+// header, data directories and section headers together with the raw contents
+// of sections and the overlay. Unmodified files are written byte-for-byte as
+// parsed. It implements the io.WriterTo interface.
+//
+// The contents of each section are read from its original location in the PE
+// file, unless added or resized (see AddSection), and written at the file
+// offset specified by its section header; truncated or padded with zeros to
+// the raw data size of the section. Mapped images cannot be written; see Unmap.
+func (file *File) WriteTo(w io.Writer) (int64, error) {
+	buf, err := file.encode()
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	n, err := w.Write(buf)
+	if err != nil {
+		return int64(n), errors.WithStack(err)
+	}
+	return int64(n), nil
+}
+
+// encode returns the contents of the PE file, serialized from its headers.
+func (file *File) encode() ([]byte, error) {
+	if file.mapped {
+		return nil, errors.New("unable to write mapped image; unmap first")
+	}
+	if file.FileHdr == nil || file.OptHdr == nil {
+		return nil, errors.New("unable to write PE file; missing file header or optional header")
+	}
+	optHdr, err := file.encodeOptHeader()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	fileHdrOffset := file.optHdrOffset() - 20
+	optHdrOffset := file.optHdrOffset()
+	sectTableOffset := file.sectHdrOffset(0)
+	hdrsEnd := maxInt64(optHdrOffset+int64(len(optHdr)), sectTableOffset+int64(len(file.SectHdrs))*40)
+	// Output size, as specified by the headers. Files truncated by the end of
+	// file remain truncated, unless the layout of sections changes.
+	end := int64(file.layoutEnd())
+	overlayOffset := minInt64(file.origEnd, file.Size())
+	if end == file.origEnd {
+		end = overlayOffset
+	}
+	end = maxInt64(end, hdrsEnd)
+	// The headers of malformed files may specify raw data far past the end of
+	// file; require the output to be backed by the contents of the PE file.
+	if limit := file.contentsEnd(hdrsEnd, overlayOffset); end > limit {
+		return nil, errors.Errorf("unable to write PE file; output size 0x%X specified by headers exceeds end 0x%X of headers and section contents (file size 0x%X)", end, limit, file.Size())
+	}
+	buf := make([]byte, end)
+	// Keep the contents between the serialized structures (e.g. the MS-DOS
+	// stub and padding) as parsed.
+	if _, err := file.ReadAt(buf[:minInt64(end, overlayOffset)], 0); err != nil && err != io.EOF {
+		return nil, errors.WithStack(err)
+	}
+	file.clearContents(buf[:minInt64(end, overlayOffset)], 0)
+	// Raw data of removed sections.
+	for _, sectHdr := range file.removedSectHdrs {
+		start := minInt64(end, int64(sectHdr.DataOffset))
+		dst := buf[start:minInt64(end, int64(sectHdr.DataOffset)+int64(sectHdr.DataSize))]
+		for j := range dst {
+			dst[j] = 0
+		}
+	}
+	// Section contents; written before the headers, which take precedence over
+	// sections overlapping them.
+	for i, sectHdr := range file.SectHdrs {
+		if sectHdr.DataSize == 0 || int64(sectHdr.DataOffset) >= end {
+			continue
+		}
+		data, err := file.sectionContents(i)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		dst := buf[sectHdr.DataOffset:minInt64(end, int64(sectHdr.DataOffset)+int64(sectHdr.DataSize))]
+		n := copy(dst, data)
+		// Zero padding.
+		for j := n; j < len(dst); j++ {
+			dst[j] = 0
+		}
+	}
+	// Headers.
+	fileHdr := &bytes.Buffer{}
+	if err := binary.Write(fileHdr, binary.LittleEndian, rawFileHeader(file.FileHdr)); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	copy(buf[fileHdrOffset:], fileHdr.Bytes())
+	copy(buf[optHdrOffset:], optHdr)
+	// Section headers.
+	for i, sectHdr := range file.SectHdrs {
+		var orig *pe.RawSectionHeader
+		if i < len(file.sectSrcs) {
+			orig = file.sectSrcs[i].orig
+		}
+		sectHdrBuf := &bytes.Buffer{}
+		if err := binary.Write(sectHdrBuf, binary.LittleEndian, rawSectionHeader(sectHdr, orig)); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		copy(buf[file.sectHdrOffset(i):], sectHdrBuf.Bytes())
+	}
+	// Unused section headers of removed sections.
+	for i := len(file.SectHdrs); i < file.sectTableLen; i++ {
+		dst := buf[minInt64(end, file.sectHdrOffset(i)):minInt64(end, file.sectHdrOffset(i+1))]
+		for j := range dst {
+			dst[j] = 0
+		}
+	}
+	// Overlay.
+	if overlayOffset < file.Size() {
+		overlay := make([]byte, file.Size()-overlayOffset)
+		if _, err := file.ReadAt(overlay, overlayOffset); err != nil && err != io.EOF {
+			return nil, errors.WithStack(err)
+		}
+		file.clearContents(overlay, overlayOffset)
+		buf = append(buf, file.cutOverlay(overlay, overlayOffset)...)
+	}
+	if file.fixChecksum {
+		checksumOffset := optHdrOffset + optChecksumOffset
+		binary.LittleEndian.PutUint32(buf[checksumOffset:], checksum(buf, checksumOffset))
+	}
+	return buf, nil
+}
+
+// contentsEnd returns the end offset of the headers and raw section data of the
+// output, as backed by the contents of the PE file; given the end offset of the
+// serialized headers and the offset of the original overlay. The overlay, if
+// present, is appended after the raw section data (including the COFF symbol
+// table and certificate table moved by changes to the layout of sections).
+//
+// The original contents before the overlay are backed as is. Sections are
+// traversed in order of file offset, and the raw data of each section located
+// before the current end offset (aligned to the file alignment) or within the
+// original file extends the end offset; by the raw data size of added or
+// resized sections, and by the size of the original raw data present on file
+// (aligned to the file alignment) of other sections.
+func (file *File) contentsEnd(hdrsEnd, overlayOffset int64) int64 {
+	fileAlign := int64(file.OptHdr.FileAlign)
+	end := maxInt64(overlayOffset, int64(alignUp64(uint64(hdrsEnd), uint64(fileAlign))))
+	var idxs []int
+	for i, sectHdr := range file.SectHdrs {
+		if sectHdr.DataSize != 0 {
+			idxs = append(idxs, i)
+		}
+	}
+	sort.SliceStable(idxs, func(i, j int) bool {
+		return file.SectHdrs[idxs[i]].DataOffset < file.SectHdrs[idxs[j]].DataOffset
+	})
+	for _, idx := range idxs {
+		sectHdr := file.SectHdrs[idx]
+		start := int64(sectHdr.DataOffset)
+		if start > int64(alignUp64(uint64(end), uint64(fileAlign))) && start >= file.Size() {
+			// Raw data not backed by contents.
+			break
+		}
+		var size int64
+		if idx < len(file.sectSrcs) {
+			switch src := file.sectSrcs[idx]; {
+			case src.data != nil:
+				size = int64(sectHdr.DataSize)
+			case src.orig != nil && int64(src.orig.DataOffset) < file.Size():
+				n := minInt64(int64(src.orig.DataSize), file.Size()-int64(src.orig.DataOffset))
+				size = minInt64(int64(sectHdr.DataSize), int64(alignUp64(uint64(n), uint64(fileAlign))))
+			}
+		}
+		end = maxInt64(end, start+size)
+	}
+	return end
+}
+
+// encodeOptHeader returns the serialized optional header, including the magic
+// number and data directories.
+func (file *File) encodeOptHeader() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := binary.Write(buf, binary.LittleEndian, file.OptHdr.Magic); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var raw interface{}
+	switch file.OptHdr.Magic {
+	case magic32:
+		raw = rawOptHeader32(file.OptHdr)
+	case magic64:
+		raw = rawOptHeader64(file.OptHdr)
+	default:
+		return nil, errors.Errorf("support for optional header magic number 0x%04X not yet implemented", file.OptHdr.Magic)
+	}
+	if err := binary.Write(buf, binary.LittleEndian, raw); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := binary.Write(buf, binary.LittleEndian, file.DataDirs); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// sectionSource specifies the source of the raw contents of a section when
+// writing.
+type sectionSource struct {
+	// Raw section header as parsed; or nil for added sections.
+	orig *pe.RawSectionHeader
+	// Raw contents of the section; or nil to read the raw contents from the
+	// original location of the section.
+	data []byte
+}
+
+// sectionContents returns the raw contents of the section with the given
+// index; as stored at its original location in the PE file, unless replaced.
+func (file *File) sectionContents(idx int) ([]byte, error) {
+	if idx >= len(file.sectSrcs) {
+		return nil, nil
+	}
+	src := file.sectSrcs[idx]
+	if src.data != nil || src.orig == nil {
+		return src.data, nil
+	}
+	start := int64(src.orig.DataOffset)
+	if start >= file.Size() {
+		return nil, nil
+	}
+	n := minInt64(int64(src.orig.DataSize), file.Size()-start)
+	data := make([]byte, n)
+	if _, err := file.ReadAt(data, start); err != nil && err != io.EOF {
+		return nil, errors.WithStack(err)
+	}
+	file.clearContents(data, start)
+	return data, nil
+}
+
+// contentRange is a range of the original contents of the PE file.
+type contentRange struct {
+	// File offset of the range.
+	offset int64
+	// Size of the range in bytes.
+	n int64
+}
+
+// clearContents clears the cleared ranges of the original file contents in
+// buf, which holds the original contents at the given file offset.
+func (file *File) clearContents(buf []byte, offset int64) {
+	for _, r := range file.clearedRanges {
+		start := maxInt64(r.offset, offset)
+		end := minInt64(r.offset+r.n, offset+int64(len(buf)))
+		for i := start; i < end; i++ {
+			buf[i-offset] = 0
+		}
+	}
+}
+
+// cutOverlay returns the given overlay, located at the specified file offset,
+// with the cut ranges of the original overlay removed.
+func (file *File) cutOverlay(overlay []byte, offset int64) []byte {
+	if len(file.overlayCuts) == 0 {
+		return overlay
+	}
+	var buf []byte
+	pos := offset
+	for _, r := range file.overlayCuts {
+		start := minInt64(maxInt64(r.offset, pos), offset+int64(len(overlay)))
+		buf = append(buf, overlay[pos-offset:start-offset]...)
+		pos = maxInt64(pos, minInt64(r.offset+r.n, offset+int64(len(overlay))))
+	}
+	return append(buf, overlay[pos-offset:]...)
+}
+
+// checksum returns the checksum of the given PE file contents, as computed by
+// CheckSumMappedFile; the checksum field, located at the given file offset, is
+// excluded.
+func checksum(buf []byte, checksumOffset int64) uint32 {
+	var sum uint32
+	for i := int64(0); i < int64(len(buf)); i += 2 {
+		if i == checksumOffset || i == checksumOffset+2 {
+			continue
+		}
+		var x uint32
+		if i+1 < int64(len(buf)) {
+			x = uint32(binary.LittleEndian.Uint16(buf[i:]))
+		} else {
+			x = uint32(buf[i])
+		}
+		sum += x
+		sum = (sum & 0xFFFF) + (sum >> 16)
+	}
+	sum = (sum & 0xFFFF) + (sum >> 16)
+	return sum + uint32(len(buf))
+}
@@ -0,0 +1,130 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/mewmew/pe/enum"
+)
+
+func TestWriteTo(t *testing.T) {
+	golden := []struct {
+		path string
+	}{
+		{path: "testdata/gcc-386-mingw-exec"},
+		{path: "testdata/gcc-386-mingw-no-symbols-exec"},
+	}
+	for _, g := range golden {
+		want, err := ioutil.ReadFile(g.path)
+		if err != nil {
+			t.Errorf("%q: unable to read file; %v", g.path, err)
+			continue
+		}
+		// Eagerly parsed file.
+		file, err := ParseBytes(want)
+		if err != nil {
+			t.Errorf("%q: unable to parse file; %+v", g.path, err)
+			continue
+		}
+		buf := &bytes.Buffer{}
+		if _, err := file.WriteTo(buf); err != nil {
+			t.Errorf("%q: unable to write file; %+v", g.path, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("%q: contents mismatch after round-trip of parsed file", g.path)
+		}
+		// Lazily parsed file, read on demand.
+		file, err = NewFile(bytes.NewReader(want), int64(len(want)))
+		if err != nil {
+			t.Errorf("%q: unable to parse file; %+v", g.path, err)
+			continue
+		}
+		buf.Reset()
+		if _, err := file.WriteTo(buf); err != nil {
+			t.Errorf("%q: unable to write file; %+v", g.path, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("%q: contents mismatch after round-trip of file read on demand", g.path)
+		}
+	}
+}
+
+func TestWriteToContentsEnd(t *testing.T) {
+	const path = "testdata/gcc-386-mingw-exec"
+	orig, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%q: unable to read file; %v", path, err)
+	}
+	file, err := ParseBytes(orig)
+	if err != nil {
+		t.Fatalf("%q: unable to parse file; %+v", path, err)
+	}
+	last := len(file.SectHdrs) - 1
+	// dataOffset patches the raw data offset of the last section.
+	dataOffset := func(offset uint32) func(content []byte) {
+		return func(content []byte) {
+			binary.LittleEndian.PutUint32(content[file.sectHdrOffset(last)+20:], offset)
+		}
+	}
+	golden := []struct {
+		name  string
+		patch func(content []byte)
+		// Specifies whether to add a section before writing.
+		add bool
+		// Expected error; empty if none.
+		err string
+	}{
+		{name: "unchanged"},
+		{name: "add-section", add: true},
+		// Raw data far past the end of file is kept as is, unless the layout
+		// of sections changes.
+		{name: "offset-past-end", patch: dataOffset(0xFFFFF000)},
+		{name: "offset-past-end-add-section", patch: dataOffset(0xFFFFF000), add: true, err: "exceeds end"},
+	}
+	for _, g := range golden {
+		content := append([]byte(nil), orig...)
+		if g.patch != nil {
+			g.patch(content)
+		}
+		file, err := ParseBytes(content)
+		if err != nil {
+			t.Errorf("%s: unable to parse file; %+v", g.name, err)
+			continue
+		}
+		if g.add {
+			if _, err := file.AddSection(".test", enum.SectionFlagContainsInitializedData|enum.SectionFlagMemRead, []byte("section contents")); err != nil {
+				t.Errorf("%s: unable to add section; %+v", g.name, err)
+				continue
+			}
+		}
+		buf := &bytes.Buffer{}
+		_, err = file.WriteTo(buf)
+		if len(g.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), g.err) {
+				t.Errorf("%s: error mismatch; expected %q, got %v", g.name, g.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unable to write file; %+v", g.name, err)
+			continue
+		}
+		if !g.add {
+			if !bytes.Equal(buf.Bytes(), content) {
+				t.Errorf("%s: contents mismatch after round-trip", g.name)
+			}
+			continue
+		}
+		// The added section is followed by the overlay.
+		sectHdr := file.SectHdrs[len(file.SectHdrs)-1]
+		overlaySize := int64(len(content)) - file.origEnd
+		if want := int64(sectHdr.DataOffset) + int64(sectHdr.DataSize) + overlaySize; int64(buf.Len()) != want {
+			t.Errorf("%s: output size mismatch; expected 0x%X, got 0x%X", g.name, want, buf.Len())
+		}
+	}
+}